@@ -0,0 +1,213 @@
+package main
+
+import apperrors "oc-message-explorer/internal/errors"
+
+// mainBranch is the branch ID of a session's main line: the path through
+// its messages that leads to where the conversation went on.
+const mainBranch = "main"
+
+// Branch is a line of messages in a session. A fork happens where a
+// message has several replies, after the user retried or edited a turn:
+// the reply leading to the latest message carries on the branch, and
+// each of the others starts an abandoned one named after its first
+// message. Reverted branches are those whose every message OpenCode
+// deleted.
+type Branch struct {
+	ID             string `json:"id"`
+	ForkedFrom     string `json:"forkedFrom,omitempty"` // the message the branch replies to
+	Main           bool   `json:"main"`
+	Reverted       bool   `json:"reverted,omitempty"`
+	Messages       int    `json:"messages"`
+	FirstMessageAt string `json:"firstMessageAt,omitempty"`
+	LastMessageAt  string `json:"lastMessageAt,omitempty"`
+
+	deleted int
+}
+
+// BranchNode is a message in the branch-aware tree of a session, with
+// its replies.
+type BranchNode struct {
+	*MessageNode
+	Branch  string        `json:"branch"`
+	Main    bool          `json:"main"`
+	Replies []*BranchNode `json:"replies"`
+}
+
+// SessionBranches is returned by GET /api/sessions/{id}/branches. Tree
+// holds the messages without a parent, which OpenCode writes for each
+// prompt, in order, each with the replies to it.
+type SessionBranches struct {
+	SessionID string        `json:"sessionId"`
+	Forks     int           `json:"forks"` // messages with more than one reply
+	Branches  []*Branch     `json:"branches"`
+	Tree      []*BranchNode `json:"tree"`
+}
+
+// branchPlan is which branch each message of a session is on.
+type branchPlan struct {
+	roots    []*MessageNode
+	replies  map[string][]*MessageNode
+	branchOf map[string]string
+	branches []*Branch // the main line first, then in the order they forked
+	forks    int
+}
+
+// planBranches works out the branches of a session's messages, given in
+// thread order. Prompts without a parent follow one another on the main
+// line unless everything in reply to them was deleted.
+func planBranches(nodes []*MessageNode) *branchPlan {
+	roots, replies := threadReplies(nodes)
+	plan := &branchPlan{roots: roots, replies: replies, branchOf: make(map[string]string, len(nodes))}
+
+	// latest is the newest message still on disk in reply to a message,
+	// or the message itself; empty when all of them were deleted.
+	latest := make(map[string]string, len(nodes))
+	var latestOf func(node *MessageNode) string
+	latestOf = func(node *MessageNode) string {
+		if newest, done := latest[node.ID]; done {
+			return newest
+		}
+		latest[node.ID] = "" // guards against parents forming a loop
+		newest := ""
+		if node.DeletedUpstreamAt == "" {
+			newest = node.Timestamp
+		}
+		for _, reply := range replies[node.ID] {
+			if candidate := latestOf(reply); timestampAfter(candidate, newest) {
+				newest = candidate
+			}
+		}
+		latest[node.ID] = newest
+		return newest
+	}
+
+	byID := make(map[string]*Branch)
+	branchFor := func(id, forkedFrom string) *Branch {
+		if branch := byID[id]; branch != nil {
+			return branch
+		}
+		branch := &Branch{ID: id, ForkedFrom: forkedFrom, Main: id == mainBranch}
+		byID[id] = branch
+		plan.branches = append(plan.branches, branch)
+		return branch
+	}
+	branchFor(mainBranch, "")
+
+	var walk func(node *MessageNode, branch *Branch)
+	walk = func(node *MessageNode, branch *Branch) {
+		if _, done := plan.branchOf[node.ID]; done {
+			return
+		}
+		plan.branchOf[node.ID] = branch.ID
+		branch.Messages++
+		if branch.FirstMessageAt == "" || timestampBefore(node.Timestamp, branch.FirstMessageAt) {
+			branch.FirstMessageAt = node.Timestamp
+		}
+		if timestampAfter(node.Timestamp, branch.LastMessageAt) {
+			branch.LastMessageAt = node.Timestamp
+		}
+		if node.DeletedUpstreamAt != "" {
+			branch.deleted++
+		}
+
+		// The reply leading to the newest message carries the branch on,
+		// the newest reply when all of them were deleted; the main line
+		// never carries on into deleted messages.
+		children := replies[node.ID]
+		var carriesOn *MessageNode
+		for _, child := range children {
+			if carriesOn == nil {
+				carriesOn = child
+				continue
+			}
+			newest, current := latestOf(child), latestOf(carriesOn)
+			if timestampAfter(newest, current) ||
+				(!timestampBefore(newest, current) && !timestampBefore(child.Timestamp, carriesOn.Timestamp)) {
+				carriesOn = child
+			}
+		}
+		if carriesOn != nil && branch.Main && latestOf(carriesOn) == "" {
+			carriesOn = nil
+		}
+		if len(children) > 1 {
+			plan.forks++
+		}
+		for _, child := range children {
+			if child == carriesOn {
+				walk(child, branch)
+			} else {
+				walk(child, branchFor(child.ID, node.ID))
+			}
+		}
+	}
+
+	for _, root := range roots {
+		if latestOf(root) == "" {
+			walk(root, branchFor(root.ID, ""))
+		} else {
+			walk(root, byID[mainBranch])
+		}
+	}
+	// Messages whose parents form a loop are reachable from no root.
+	for _, node := range nodes {
+		walk(node, byID[mainBranch])
+	}
+
+	for _, branch := range plan.branches {
+		branch.Reverted = branch.Messages > 0 && branch.deleted == branch.Messages
+	}
+	return plan
+}
+
+// tree builds the branch-aware tree of the planned messages.
+func (p *branchPlan) tree() []*BranchNode {
+	seen := make(map[string]bool)
+	var build func(node *MessageNode) *BranchNode
+	build = func(node *MessageNode) *BranchNode {
+		seen[node.ID] = true
+		branchNode := &BranchNode{MessageNode: node, Branch: p.branchOf[node.ID], Replies: []*BranchNode{}}
+		branchNode.Main = branchNode.Branch == mainBranch
+		for _, reply := range p.replies[node.ID] {
+			if !seen[reply.ID] {
+				branchNode.Replies = append(branchNode.Replies, build(reply))
+			}
+		}
+		return branchNode
+	}
+	tree := []*BranchNode{}
+	for _, root := range p.roots {
+		tree = append(tree, build(root))
+	}
+	return tree
+}
+
+// SessionBranches returns the branches of a session for
+// /api/sessions/{id}/branches. Messages deleted upstream are included,
+// since a revert in OpenCode deletes the messages it abandons.
+func (s *Store) SessionBranches(sessionID string) (*SessionBranches, error) {
+	if s.db == nil {
+		return nil, apperrors.NewNotFoundError("Session not found", nil)
+	}
+	nodes, _, err := s.db.SessionMessages(sessionID, true)
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("failed to load session branches", err)
+	}
+	if len(nodes) == 0 {
+		detail, err := s.db.GetSession(sessionID)
+		if err != nil {
+			return nil, apperrors.NewDatabaseError("failed to load session", err)
+		}
+		if detail == nil {
+			return nil, apperrors.NewNotFoundError("Session not found", nil)
+		}
+	}
+
+	plan := planBranches(nodes)
+	branches := []*Branch{}
+	for _, branch := range plan.branches {
+		if branch.Messages > 0 {
+			branches = append(branches, branch)
+		}
+	}
+	return &SessionBranches{SessionID: sessionID, Forks: plan.forks, Branches: branches, Tree: plan.tree()}, nil
+}
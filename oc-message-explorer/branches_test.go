@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSessionBranches(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	sm := NewSyncManager(store.db, store, t.TempDir(), nil)
+	sm.historySources = nil
+
+	dir := filepath.Join(sm.msgPath, "ses_f")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	message := func(id, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, id+".json"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	message("msg_u1", `{"id":"msg_u1","sessionID":"ses_f","role":"user","time":{"created":1759309200000}}`)
+	message("msg_a1", `{"id":"msg_a1","sessionID":"ses_f","role":"assistant","parentID":"msg_u1","time":{"created":1759309210000}}`)
+	message("msg_a1r", `{"id":"msg_a1r","sessionID":"ses_f","role":"assistant","parentID":"msg_u1","time":{"created":1759309220000}}`)
+	message("msg_u2", `{"id":"msg_u2","sessionID":"ses_f","role":"user","time":{"created":1759309300000}}`)
+	message("msg_a2", `{"id":"msg_a2","sessionID":"ses_f","role":"assistant","parentID":"msg_u2","time":{"created":1759309310000}}`)
+	message("msg_u3", `{"id":"msg_u3","sessionID":"ses_f","role":"user","time":{"created":1759309400000}}`)
+	message("msg_a3", `{"id":"msg_a3","sessionID":"ses_f","role":"assistant","parentID":"msg_u3","time":{"created":1759309410000}}`)
+	syncAndHydrate(sm)
+
+	// Reverting to msg_a2 and prompting again deletes msg_u3 and its reply.
+	for _, id := range []string{"msg_u3", "msg_a3"} {
+		if err := os.Remove(filepath.Join(dir, id+".json")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	message("msg_u4", `{"id":"msg_u4","sessionID":"ses_f","role":"user","time":{"created":1759309500000}}`)
+	syncAndHydrate(sm)
+
+	branches, err := store.SessionBranches("ses_f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branches.Forks != 1 || len(branches.Branches) != 3 {
+		t.Fatalf("expected one fork and three branches, got %+v", branches.Branches)
+	}
+	main, retried, reverted := branches.Branches[0], branches.Branches[1], branches.Branches[2]
+	if !main.Main || main.Messages != 5 || main.Reverted {
+		t.Errorf("unexpected main line %+v", main)
+	}
+	if retried.ID != "msg_a1" || retried.ForkedFrom != "msg_u1" || retried.Main || retried.Reverted || retried.Messages != 1 {
+		t.Errorf("expected the first answer to be abandoned for its retry, got %+v", retried)
+	}
+	if reverted.ID != "msg_u3" || !reverted.Reverted || reverted.Messages != 2 {
+		t.Errorf("expected the reverted turn on a branch of its own, got %+v", reverted)
+	}
+
+	if len(branches.Tree) != 4 || branches.Tree[0].ID != "msg_u1" || len(branches.Tree[0].Replies) != 2 {
+		t.Fatalf("unexpected tree %+v", branches.Tree)
+	}
+	if first, retry := branches.Tree[0].Replies[0], branches.Tree[0].Replies[1]; first.Main || first.Branch != "msg_a1" || !retry.Main {
+		t.Errorf("expected the retry on the main line, got %+v and %+v", first, retry)
+	}
+	if u3 := branches.Tree[2]; u3.ID != "msg_u3" || u3.Main || len(u3.Replies) != 1 || u3.Replies[0].Branch != "msg_u3" {
+		t.Errorf("unexpected reverted turn %+v", u3)
+	}
+
+	opts, err := parseTranscriptOptions(url.Values{"branch": {"main"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript, err := store.Transcript("ses_f", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, message := range transcript.Messages {
+		if !message.MainLine {
+			t.Errorf("expected only the main line, got %s on %s", message.ID, message.Branch)
+		}
+		order = append(order, message.ID)
+	}
+	if len(order) != 5 || order[1] != "msg_a1r" || order[4] != "msg_u4" {
+		t.Errorf("unexpected main line %v", order)
+	}
+
+	if _, err := store.SessionBranches("ses_missing"); err == nil {
+		t.Error("expected an unknown session to be an error")
+	}
+}
+
+func TestBranchesCompareInstants(t *testing.T) {
+	// The retry was written in UTC and the first answer at +02:00, so
+	// their text orders the other way round from their instants.
+	nodes := []*MessageNode{
+		{ID: "u1", Type: "user", Timestamp: "2025-10-01T12:00:00+02:00"},
+		{ID: "a1", Type: "assistant", ParentID: "u1", Timestamp: "2025-10-01T12:00:10+02:00"},
+		{ID: "a1r", Type: "assistant", ParentID: "u1", Timestamp: "2025-10-01T10:00:20Z"},
+	}
+	plan := planBranches(nodes)
+	if plan.branchOf["a1r"] != mainBranch || plan.branchOf["a1"] != "a1" {
+		t.Fatalf("expected the later retry on the main line, got %v", plan.branchOf)
+	}
+	main := plan.branches[0]
+	if main.FirstMessageAt != nodes[0].Timestamp || main.LastMessageAt != nodes[2].Timestamp {
+		t.Errorf("expected the main line to span u1 to a1r, got %s to %s", main.FirstMessageAt, main.LastMessageAt)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SyncCheckpoint records what a file looked like when it was last synced.
+// Session directories get one too, summarising their listing, so a session
+// with no changed files is skipped without comparing file by file.
+type SyncCheckpoint struct {
+	Path     string
+	Size     int64
+	ModTime  int64 // UnixNano
+	Hash     string
+	FolderID string // the folder a session log was imported into
+}
+
+// GetSyncCheckpoints returns every checkpoint under root, keyed by path.
+func (d *Database) GetSyncCheckpoints(root string) (map[string]SyncCheckpoint, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	rows, err := d.db.Query(
+		"SELECT path, size, mtime, hash, folder_id FROM sync_checkpoints WHERE path = ? OR substr(path, 1, ?) = ?",
+		root, len(root)+1, root+string(filepath.Separator),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := make(map[string]SyncCheckpoint)
+	for rows.Next() {
+		var cp SyncCheckpoint
+		if err := rows.Scan(&cp.Path, &cp.Size, &cp.ModTime, &cp.Hash, &cp.FolderID); err != nil {
+			return nil, err
+		}
+		checkpoints[cp.Path] = cp
+	}
+	return checkpoints, rows.Err()
+}
+
+// SaveSyncCheckpoints records checkpoints once what they describe has been
+// written to the database.
+func (d *Database) SaveSyncCheckpoints(checkpoints []SyncCheckpoint) error {
+	if len(checkpoints) == 0 {
+		return nil
+	}
+	return d.withTx(func(tx *sql.Tx) error {
+		return saveCheckpointsTx(tx, checkpoints)
+	})
+}
+
+func saveCheckpointsTx(tx *sql.Tx, checkpoints []SyncCheckpoint) error {
+	if len(checkpoints) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO sync_checkpoints (path, size, mtime, hash, folder_id) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, cp := range checkpoints {
+		if _, err := stmt.Exec(cp.Path, cp.Size, cp.ModTime, cp.Hash, cp.FolderID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClearSyncCheckpoints forgets every checkpoint under root so the next
+// sync reads it all again.
+func (d *Database) ClearSyncCheckpoints(root string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec(
+		"DELETE FROM sync_checkpoints WHERE path = ? OR substr(path, 1, ?) = ?",
+		root, len(root)+1, root+string(filepath.Separator),
+	)
+	return err
+}
+
+// ClearRequestedCheckpoints forgets the checkpoints under root, the
+// message directory, when a migration asked for that; see
+// forgetMessageCheckpoints. The request is dropped once carried out.
+func (d *Database) ClearRequestedCheckpoints(root string) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		var requested int
+		err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'message_resync'").Scan(&requested)
+		if err != nil || requested == 0 {
+			return err
+		}
+		if _, err := tx.Exec(
+			"DELETE FROM sync_checkpoints WHERE path = ? OR substr(path, 1, ?) = ?",
+			root, len(root)+1, root+string(filepath.Separator),
+		); err != nil {
+			return err
+		}
+		return execAll(tx, "DROP TABLE message_resync")
+	})
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkFile compares a file against its previous checkpoint. Size and
+// modification time are trusted when they match; otherwise the file is
+// read and hashed, so a touched but identical file still counts as
+// unchanged. data is nil when the file was not read.
+func checkFile(path string, info os.FileInfo, previous SyncCheckpoint, known bool) (cp SyncCheckpoint, data []byte, changed bool, err error) {
+	cp = SyncCheckpoint{Path: path, Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	if known && previous.Size == cp.Size && previous.ModTime == cp.ModTime {
+		cp.Hash = previous.Hash
+		return cp, nil, false, nil
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return cp, nil, false, err
+	}
+	cp.Size = int64(len(data))
+	cp.Hash = hashBytes(data)
+	return cp, data, !known || previous.Hash != cp.Hash, nil
+}
+
+// readChangedFiles hands each file that differs from its checkpoint to
+// parse, returning the checkpoints to save once what was parsed is
+// written. Files that cannot be read or parsed are logged as the given
+// kind and skipped.
+func readChangedFiles(files []string, previous map[string]SyncCheckpoint, kind string, parse func(data []byte) error) []SyncCheckpoint {
+	var checkpoints []SyncCheckpoint
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		old, known := previous[path]
+		cp, data, changed, err := checkFile(path, info, old, known)
+		if err != nil {
+			log.Printf("Failed to read %s file %s: %v", kind, path, err)
+			continue
+		}
+		if !changed {
+			if data != nil {
+				checkpoints = append(checkpoints, cp)
+			}
+			continue
+		}
+
+		if err := parse(data); err != nil {
+			log.Printf("Failed to parse %s file %s: %v", kind, path, err)
+			continue
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	return checkpoints
+}
+
+type sessionFile struct {
+	path string
+	info os.FileInfo
+}
+
+// listSessionFiles returns a session's message files and a checkpoint for
+// the directory whose hash covers every file's name, size and mtime.
+func listSessionFiles(sessionPath string) ([]sessionFile, SyncCheckpoint, error) {
+	entries, err := os.ReadDir(sessionPath)
+	if err != nil {
+		return nil, SyncCheckpoint{}, err
+	}
+
+	var files []sessionFile
+	var listing []string
+	cp := SyncCheckpoint{Path: sessionPath}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, sessionFile{path: filepath.Join(sessionPath, entry.Name()), info: info})
+		listing = append(listing, fmt.Sprintf("%s:%d:%d", entry.Name(), info.Size(), info.ModTime().UnixNano()))
+
+		cp.Size += info.Size()
+		if mtime := info.ModTime().UnixNano(); mtime > cp.ModTime {
+			cp.ModTime = mtime
+		}
+	}
+
+	sort.Strings(listing)
+	cp.Hash = hashBytes([]byte(strings.Join(listing, "\n")))
+	return files, cp, nil
+}
+
+// sessionScan is what one reader found in a session directory: the
+// messages whose files changed since their checkpoints, and the checkpoints
+// to record once those messages are written.
+type sessionScan struct {
+	path       string
+	nodes      []*MessageNode
+	files      []SyncCheckpoint
+	checkpoint SyncCheckpoint // the directory's
+	complete   bool           // every file was read, so checkpoint may be saved
+	skipped    bool           // the directory is unchanged
+	unchanged  int            // unchanged files in a changed directory
+	present    []string       // every message file listed, changed or not
+	err        error
+}
+
+// scanSession reads the files of one session that changed since their
+// checkpoints. previous is only read, so readers can share it.
+func scanSession(sessionPath string, previous map[string]SyncCheckpoint) sessionScan {
+	scan := sessionScan{path: sessionPath, complete: true}
+
+	files, sessionCP, err := listSessionFiles(sessionPath)
+	if err != nil {
+		scan.err = err
+		return scan
+	}
+	scan.checkpoint = sessionCP
+	for _, file := range files {
+		scan.present = append(scan.present, file.path)
+	}
+	if cp, known := previous[sessionPath]; known && cp == sessionCP {
+		scan.skipped = true
+		return scan
+	}
+
+	for _, file := range files {
+		old, known := previous[file.path]
+		cp, data, changed, err := checkFile(file.path, file.info, old, known)
+		if err != nil {
+			scan.complete = false
+			log.Printf("Failed to read message file %s: %v", file.path, err)
+			continue
+		}
+		if !changed {
+			scan.unchanged++
+			if data != nil {
+				// Touched without changing; remember the new mtime.
+				scan.files = append(scan.files, cp)
+			}
+			continue
+		}
+
+		node, err := parseOpenCodeMessage(data)
+		if err != nil {
+			scan.complete = false
+			log.Printf("Failed to parse message file %s: %v", file.path, err)
+			continue
+		}
+
+		scan.nodes = append(scan.nodes, node)
+		scan.files = append(scan.files, cp)
+	}
+
+	return scan
+}
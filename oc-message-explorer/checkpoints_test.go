@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSyncSkipsUnchangedFiles(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sm := NewSyncManager(db, nil, t.TempDir(), nil)
+	sm.historySources = nil
+
+	sessionPath := filepath.Join(sm.msgPath, "ses_a")
+	if err := os.MkdirAll(sessionPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeMessage := func(id, title string) string {
+		t.Helper()
+		path := filepath.Join(sessionPath, id+".json")
+		content := `{"id":"` + id + `","sessionID":"ses_a","role":"user","time":{"created":1760000000000},"summary":{"title":"` + title + `"}}`
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	first := writeMessage("msg_1", "First")
+	writeMessage("msg_2", "Second")
+	syncAndHydrate(sm)
+
+	checkpoints, err := db.GetSyncCheckpoints(sm.msgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := checkpoints[sessionPath]; !ok || len(checkpoints) != 3 {
+		t.Fatalf("expected checkpoints for the session and both files, got %d", len(checkpoints))
+	}
+
+	// An edit a sync would overwrite if it re-read msg_1.
+	node, err := db.GetNode("msg_1")
+	if err != nil || node == nil {
+		t.Fatalf("msg_1 not synced: %v", err)
+	}
+	node.Summary = "edited"
+	if err := db.UpdateNode("openchat", node); err != nil {
+		t.Fatal(err)
+	}
+
+	// Touch msg_1 without changing it and really change msg_2.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(first, later, later); err != nil {
+		t.Fatal(err)
+	}
+	writeMessage("msg_2", "Second, revised")
+	syncAndHydrate(sm)
+
+	if node, _ := db.GetNode("msg_1"); node == nil || node.Summary != "edited" {
+		t.Errorf("expected unchanged msg_1 to be skipped, got %+v", node)
+	}
+	if node, _ := db.GetNode("msg_2"); node == nil || node.Summary != "Second, revised" {
+		t.Errorf("expected msg_2 to be re-read, got %+v", node)
+	}
+
+	checkpoints, err = db.GetSyncCheckpoints(sm.msgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoints[first].ModTime != later.UnixNano() {
+		t.Error("expected the touched file's checkpoint to record its new mtime")
+	}
+}
+
+func TestMigrationsForgetMessageCheckpoints(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A configured message directory, and a session log whose name the
+	// old LIKE '%storage_message_%' would have matched.
+	msgPath := filepath.Join(t.TempDir(), "messages")
+
+	// Migrating the new database asked for a resync, which its first
+	// sync carries out.
+	if err := db.ClearRequestedCheckpoints(msgPath); err != nil {
+		t.Fatal(err)
+	}
+	message := filepath.Join(msgPath, "ses_a", "msg_1.json")
+	sessionLog := filepath.Join(t.TempDir(), "storage_message_log.jsonl")
+	if err := db.SaveSyncCheckpoints([]SyncCheckpoint{{Path: message, Hash: "a"}, {Path: sessionLog, Hash: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing more is cleared until a migration asks for it.
+	if err := db.ClearRequestedCheckpoints(msgPath); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoints, _ := db.GetSyncCheckpoints(msgPath); len(checkpoints) != 1 {
+		t.Fatalf("expected the message checkpoint to stay, got %v", checkpoints)
+	}
+
+	if err := db.withTx(forgetMessageCheckpoints); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ClearRequestedCheckpoints(msgPath); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoints, _ := db.GetSyncCheckpoints(msgPath); len(checkpoints) != 0 {
+		t.Errorf("expected the message checkpoints to be forgotten, got %v", checkpoints)
+	}
+	if checkpoints, _ := db.GetSyncCheckpoints(sessionLog); len(checkpoints) != 1 {
+		t.Errorf("expected the session log's checkpoint to stay, got %v", checkpoints)
+	}
+
+	// The request is carried out once.
+	if err := db.SaveSyncCheckpoints([]SyncCheckpoint{{Path: message, Hash: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ClearRequestedCheckpoints(msgPath); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoints, _ := db.GetSyncCheckpoints(msgPath); len(checkpoints) != 1 {
+		t.Errorf("expected the request to be dropped once carried out, got %v", checkpoints)
+	}
+}
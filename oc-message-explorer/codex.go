@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// codexImporter reads Codex CLI rollout logs, usually found under
+// ~/.codex/sessions/YYYY/MM/DD/rollout-<time>-<id>.jsonl. Current versions
+// wrap each line as {"timestamp", "type", "payload"}, with a session_meta
+// line first and the conversation in response_item lines. Older versions
+// start with a bare {"id", "timestamp"} line followed by unwrapped items.
+type codexImporter struct{}
+
+// codexContextPrefixes mark the user messages Codex adds itself.
+var codexContextPrefixes = []string{"<environment_context>", "<user_instructions>", "# AGENTS.md instructions"}
+
+func (codexImporter) Name() string { return "codex" }
+
+func (codexImporter) Match(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), "rollout-") && strings.HasSuffix(path, ".jsonl")
+}
+
+func (codexImporter) Detect(path string, head []byte) bool {
+	raw, ok := firstJSONObject(head)
+	if !ok {
+		return false
+	}
+	if raw["type"] == "session_meta" {
+		return true
+	}
+	_, hasID := raw["id"].(string)
+	_, hasTimestamp := raw["timestamp"].(string)
+	_, hasType := raw["type"]
+	return hasID && hasTimestamp && !hasType && codexImporter{}.Match(path)
+}
+
+func (codexImporter) Parse(r io.Reader, emit func(HistoryEntry)) error {
+	var sessionID, directory string
+	var sessionTime time.Time
+	calls := make(map[string]*MessagePart)
+
+	err := scanJSONLines(r, func(raw map[string]interface{}) {
+		item := raw
+		when, _ := historyEntryTime(raw)
+		if payload, ok := raw["payload"].(map[string]interface{}); ok {
+			switch raw["type"] {
+			case "session_meta":
+				sessionID, _ = payload["id"].(string)
+				directory, _ = payload["cwd"].(string)
+				sessionTime, _ = historyEntryTime(payload)
+				return
+			case "response_item":
+				item = payload
+			default:
+				// event_msg lines repeat response items for the UI, and
+				// turn_context lines only restate settings.
+				return
+			}
+		} else if _, ok := raw["record_type"]; ok {
+			return
+		} else if _, ok := raw["type"]; !ok {
+			// The older session header.
+			sessionID, _ = raw["id"].(string)
+			sessionTime = when
+			return
+		}
+		if when.IsZero() {
+			when = sessionTime
+		}
+
+		entry := HistoryEntry{SessionID: sessionID, Directory: directory, Time: when, Role: "assistant"}
+		switch item["type"] {
+		case "message":
+			role, _ := item["role"].(string)
+			text := codexText(item["content"])
+			switch {
+			case role == "user" && !isCodexContext(text):
+				entry.Role = "user"
+				entry.Input = text
+			case role == "assistant" && text != "":
+				entry.Parts = []*MessagePart{{Type: "text", Text: text}}
+			default:
+				return
+			}
+		case "reasoning":
+			text := codexText(item["summary"])
+			if text == "" {
+				return
+			}
+			entry.Parts = []*MessagePart{{Type: "reasoning", Text: text}}
+		case "function_call", "custom_tool_call", "local_shell_call":
+			part := &MessagePart{Type: "tool", Status: "running", StartedAt: formatTime(when)}
+			part.Tool, _ = item["name"].(string)
+			part.CallID, _ = item["call_id"].(string)
+			if item["type"] == "local_shell_call" {
+				part.Tool = "shell"
+				part.Input = jsonString(item["action"])
+			} else if arguments, ok := item["arguments"].(string); ok {
+				part.Input = arguments
+			} else {
+				part.Input = jsonString(item["input"])
+			}
+			if status, ok := item["status"].(string); ok && status != "in_progress" {
+				part.Status = status
+			}
+			if part.CallID != "" {
+				calls[part.CallID] = part
+			}
+			entry.Parts = []*MessagePart{part}
+		case "function_call_output", "custom_tool_call_output":
+			callID, _ := item["call_id"].(string)
+			if part, ok := calls[callID]; ok {
+				part.Output, part.Status = codexOutput(item["output"])
+				if part.Status == "error" {
+					// OpenCode keeps a failed call's output as its error.
+					part.Error, part.Output = part.Output, ""
+				}
+				part.EndedAt = formatTime(when)
+			}
+			return
+		default:
+			return
+		}
+		emit(entry)
+	})
+	return err
+}
+
+func (codexImporter) Node(source HistorySource, entry HistoryEntry) *MessageNode {
+	return sessionNode(source, entry)
+}
+
+func isCodexContext(text string) bool {
+	text = strings.TrimSpace(text)
+	for _, prefix := range codexContextPrefixes {
+		if strings.HasPrefix(text, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// codexText joins the text of a content or summary list, whose items are
+// {"type": "input_text" | "output_text" | "summary_text", "text"}.
+func codexText(value interface{}) string {
+	items, _ := value.([]interface{})
+	var texts []string
+	for _, item := range items {
+		fields, _ := item.(map[string]interface{})
+		if text, ok := fields["text"].(string); ok && text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// codexOutput reads a tool call's output, which is either a string, a
+// JSON string holding {"output", "metadata": {"exit_code"}}, or an object
+// with "content" and "success".
+func codexOutput(value interface{}) (string, string) {
+	switch output := value.(type) {
+	case string:
+		var wrapped struct {
+			Output   string `json:"output"`
+			Metadata struct {
+				ExitCode *int `json:"exit_code"`
+			} `json:"metadata"`
+		}
+		if json.Unmarshal([]byte(output), &wrapped) == nil && wrapped.Metadata.ExitCode != nil {
+			if *wrapped.Metadata.ExitCode != 0 {
+				return wrapped.Output, "error"
+			}
+			return wrapped.Output, "completed"
+		}
+		return output, "completed"
+	case map[string]interface{}:
+		content, _ := output["content"].(string)
+		if success, ok := output["success"].(bool); ok && !success {
+			return content, "error"
+		}
+		return content, "completed"
+	}
+	return "", "completed"
+}
+
+func jsonString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
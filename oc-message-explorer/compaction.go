@@ -0,0 +1,48 @@
+package main
+
+import "fmt"
+
+// Boundaries mark where a session was compacted. OpenCode compacts a long
+// session by sending a user message holding a compaction part, answered
+// by a summary that replaces everything before it in the context the
+// model sees from then on. Older versions wrote only the summary.
+const (
+	boundaryCompaction = "compaction" // the message asking for the compaction
+	boundarySummary    = "summary"    // the summary that replaced earlier context
+)
+
+// openCodeBoundary reports whether a message file is a compaction
+// summary: an assistant message flagged as a summary, or one written by
+// the compaction agent.
+func openCodeBoundary(ocMsg *OpenCodeMessage) string {
+	if ocMsg.Role != "assistant" {
+		return ""
+	}
+	if flagged, _ := ocMsg.Summary.(bool); flagged || ocMsg.Agent == "compaction" || ocMsg.Mode == "compaction" {
+		return boundarySummary
+	}
+	return ""
+}
+
+// partsBoundary reports whether a message's parts ask for a compaction.
+func partsBoundary(parts []*MessagePart) string {
+	for _, part := range parts {
+		if part.Type == "compaction" {
+			return boundaryCompaction
+		}
+	}
+	return ""
+}
+
+// compactionCutoff is the SQL for when the context of a session last
+// started over, or NULL if it was never compacted: the message asking for
+// its latest summary, or the summary itself when nothing asked for it.
+// Messages sent before it are ones the model no longer saw.
+func compactionCutoff(sessionID string) string {
+	return fmt.Sprintf(`(SELECT COALESCE(request.timestamp, summary.timestamp)
+		FROM nodes summary
+		LEFT JOIN nodes request ON request.id = summary.parent_id AND request.boundary = '%s'
+		WHERE summary.session_id = %s AND summary.boundary = '%s' AND summary.deleted_upstream_at IS NULL
+		ORDER BY julianday(COALESCE(request.timestamp, summary.timestamp)) DESC LIMIT 1)`,
+		boundaryCompaction, sessionID, boundarySummary)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompactionBoundaries(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	sm := NewSyncManager(store.db, store, t.TempDir(), nil)
+	sm.historySources = nil
+
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	message := func(sessionID, id, content string) {
+		write(filepath.Join(sm.msgPath, sessionID, id+".json"), content)
+	}
+	message("ses_long", "msg_1", `{"id":"msg_1","sessionID":"ses_long","role":"user","time":{"created":1759309200000},"summary":{"title":"Port the parser"}}`)
+	message("ses_long", "msg_2", `{"id":"msg_2","sessionID":"ses_long","role":"assistant","parentID":"msg_1","time":{"created":1759309210000,"completed":1759309260000},"mode":"build"}`)
+	message("ses_long", "msg_3", `{"id":"msg_3","sessionID":"ses_long","role":"user","time":{"created":1759309300000}}`)
+	write(filepath.Join(sm.partPath, "msg_3", "prt_1.json"), `{"id":"prt_1","sessionID":"ses_long","messageID":"msg_3","type":"compaction","auto":true}`)
+	message("ses_long", "msg_4", `{"id":"msg_4","sessionID":"ses_long","role":"assistant","parentID":"msg_3","time":{"created":1759309301000,"completed":1759309320000},"mode":"compaction","summary":true}`)
+	message("ses_long", "msg_5", `{"id":"msg_5","sessionID":"ses_long","role":"user","time":{"created":1759309400000}}`)
+	// Older versions wrote only the summary.
+	message("ses_old", "msg_a", `{"id":"msg_a","sessionID":"ses_old","role":"user","time":{"created":1759309200000}}`)
+	message("ses_old", "msg_b", `{"id":"msg_b","sessionID":"ses_old","role":"assistant","parentID":"msg_a","time":{"created":1759309300000},"summary":true}`)
+	message("ses_old", "msg_c", `{"id":"msg_c","sessionID":"ses_old","role":"user","time":{"created":1759309400000}}`)
+	if err := store.db.WriteSessions([]*Session{
+		{ID: "ses_long", Title: "Port the parser", Source: "opencode"},
+		{ID: "ses_old", Title: "Old session", Source: "opencode"},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	syncAndHydrate(sm)
+
+	for id, want := range map[string]string{"msg_1": "", "msg_2": "", "msg_3": "compaction", "msg_4": "summary", "msg_b": "summary"} {
+		node, err := store.db.GetNode(id)
+		if err != nil || node == nil {
+			t.Fatalf("expected %s: %v", id, err)
+		}
+		if node.Boundary != want {
+			t.Errorf("%s: got boundary %q, want %q", id, node.Boundary, want)
+		}
+	}
+
+	// The request is only known from its parts, so syncing its message
+	// file again keeps it.
+	message("ses_long", "msg_3", `{"id":"msg_3","sessionID":"ses_long","role":"user","time":{"created":1759309300000},"summary":{"title":"Compact"}}`)
+	syncAndHydrate(sm)
+	if node, err := store.db.GetNode("msg_3"); err != nil || node.Boundary != "compaction" {
+		t.Errorf("expected the compaction request to stay a boundary, got %+v (%v)", node, err)
+	}
+
+	for query, want := range map[string]int{
+		"boundary:true":                      3,
+		"boundary:summary":                   2,
+		"compacted:true":                     3,
+		"compacted:true session:ses_long":    2,
+		"compacted:false session:ses_long":   3,
+		"compacted:false boundary:false":     2,
+		"compacted:true boundary:compaction": 0,
+	} {
+		response, err := store.db.SearchNodes(SearchRequest{Query: query})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if response.Total != want {
+			t.Errorf("%s: got %d results, want %d", query, response.Total, want)
+		}
+	}
+
+	session, err := store.GetSession("ses_long")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Compactions != 1 || !sameTime(session.CompactedAt, "2025-10-01T09:01:40Z") {
+		t.Errorf("expected the context to start over at the request, got %+v", session.Session)
+	}
+	old, err := store.GetSession("ses_old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Compactions != 1 || !sameTime(old.CompactedAt, "2025-10-01T09:01:40Z") {
+		t.Errorf("expected the context to start over at the summary, got %+v", old.Session)
+	}
+}
@@ -1,13 +1,15 @@
 package main
 
 import (
-	"bufio"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -28,6 +30,55 @@ func placeholders(count int) string {
 	return strings.Repeat("?,", count)[:count*2-1]
 }
 
+// nodeSelectColumns is the column list scanNode expects, over nodes n.
+const nodeSelectColumns = `n.id, n.type, n.content, n.summary, n.timestamp, n.parent_id,
+		       n.expanded, n.selected, n.session_id, n.has_loaded, n.locked,
+		       COALESCE(n.sort_index, 0), COALESCE(n.deleted_upstream_at, ''), n.timestamp_estimated,
+		       n.model_id, n.provider_id, n.agent, n.tokens_input, n.tokens_output, n.tokens_reasoning,
+		       n.tokens_cache_read, n.tokens_cache_write, n.cost, n.completed_at,
+		       n.latency_ms, n.error_kind, n.error_name, n.error_message, n.boundary`
+
+// nodeSiblingOrder orders siblings: manually ranked nodes first, by rank,
+// then the rest oldest first.
+const nodeSiblingOrder = "n.sort_index IS NULL, n.sort_index, julianday(n.timestamp), n.id"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanNode(row rowScanner, extra ...any) (*MessageNode, error) {
+	var node MessageNode
+	var expanded, selected, hasLoaded, locked, estimated int
+	var tokens TokenUsage
+	var failure MessageError
+
+	dest := []any{
+		&node.ID, &node.Type, &node.Content, &node.Summary, &node.Timestamp,
+		&node.ParentID, &expanded, &selected, &node.SessionID, &hasLoaded, &locked,
+		&node.SortIndex, &node.DeletedUpstreamAt, &estimated,
+		&node.ModelID, &node.ProviderID, &node.Agent, &tokens.Input, &tokens.Output, &tokens.Reasoning,
+		&tokens.CacheRead, &tokens.CacheWrite, &node.Cost, &node.CompletedAt,
+		&node.LatencyMs, &failure.Kind, &failure.Name, &failure.Message, &node.Boundary,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	node.Expanded = expanded == 1
+	node.Selected = selected == 1
+	node.HasLoaded = hasLoaded == 1
+	node.Locked = locked == 1
+	node.TimestampEstimated = estimated == 1
+	if tokens != (TokenUsage{}) {
+		node.Tokens = &tokens
+	}
+	if failure.Kind != "" {
+		node.Error = &failure
+	}
+
+	return &node, nil
+}
+
 func NewDatabase(dbPath string) (*Database, error) {
 	db := &Database{
 		dbPath: dbPath,
@@ -54,8 +105,12 @@ func (d *Database) init() error {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	if err := d.createSchema(); err != nil {
-		return fmt.Errorf("failed to create schema: %w", err)
+	if err := d.migrate(); err != nil {
+		return fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	if err := d.ensureSearchIndex(); err != nil {
+		return fmt.Errorf("failed to build search index: %w", err)
 	}
 
 	d.db.SetMaxOpenConns(1)
@@ -64,49 +119,6 @@ func (d *Database) init() error {
 	return nil
 }
 
-func (d *Database) createSchema() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS folders (
-		id TEXT PRIMARY KEY,
-		name TEXT NOT NULL,
-		color TEXT NOT NULL,
-		created_at TEXT NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS nodes (
-		id TEXT PRIMARY KEY,
-		folder_id TEXT NOT NULL,
-		type TEXT NOT NULL,
-		content TEXT,
-		summary TEXT,
-		timestamp TEXT NOT NULL,
-		parent_id TEXT,
-		expanded INTEGER NOT NULL DEFAULT 0,
-		selected INTEGER NOT NULL DEFAULT 0,
-		session_id TEXT,
-		has_loaded INTEGER NOT NULL DEFAULT 0,
-		locked INTEGER NOT NULL DEFAULT 0,
-		FOREIGN KEY (folder_id) REFERENCES folders(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS tags (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		node_id TEXT NOT NULL,
-		tag TEXT NOT NULL,
-		FOREIGN KEY (node_id) REFERENCES nodes(id) ON DELETE CASCADE
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_nodes_folder_id ON nodes(folder_id);
-	CREATE INDEX IF NOT EXISTS idx_nodes_parent_id ON nodes(parent_id);
-	CREATE INDEX IF NOT EXISTS idx_nodes_type ON nodes(type);
-	CREATE INDEX IF NOT EXISTS idx_nodes_timestamp ON nodes(timestamp);
-	CREATE INDEX IF NOT EXISTS idx_tags_node_id ON tags(node_id);
-	`
-
-	_, err := d.db.Exec(schema)
-	return err
-}
-
 func (d *Database) Close() error {
 	return d.db.Close()
 }
@@ -155,13 +167,12 @@ func (d *Database) GetAllFolders() (map[string]*Folder, error) {
 }
 
 func (d *Database) GetNodesForFolder(folderID string) (map[string]*MessageNode, error) {
-	rows, err := d.db.Query(`
-		SELECT n.id, n.type, n.content, n.summary, n.timestamp, n.parent_id,
-		       n.expanded, n.selected, n.session_id, n.has_loaded, n.locked
+	rows, err := d.db.Query(fmt.Sprintf(`
+		SELECT %s
 		FROM nodes n
 		WHERE n.folder_id = ?
-		ORDER BY n.timestamp DESC
-	`, folderID)
+		ORDER BY %s
+	`, nodeSelectColumns, nodeSiblingOrder), folderID)
 	if err != nil {
 		return nil, err
 	}
@@ -171,23 +182,12 @@ func (d *Database) GetNodesForFolder(folderID string) (map[string]*MessageNode,
 	var nodeIDs []string
 
 	for rows.Next() {
-		var node MessageNode
-		var expanded, selected, hasLoaded, locked int
-
-		err := rows.Scan(
-			&node.ID, &node.Type, &node.Content, &node.Summary, &node.Timestamp,
-			&node.ParentID, &expanded, &selected, &node.SessionID, &hasLoaded, &locked,
-		)
+		node, err := scanNode(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		node.Expanded = expanded == 1
-		node.Selected = selected == 1
-		node.HasLoaded = hasLoaded == 1
-		node.Locked = locked == 1
-
-		nodes[node.ID] = &node
+		nodes[node.ID] = node
 		nodeIDs = append(nodeIDs, node.ID)
 	}
 
@@ -222,7 +222,8 @@ func (d *Database) GetNodesForFolder(folderID string) (map[string]*MessageNode,
 		}
 	}
 
-	for _, node := range nodes {
+	for _, nodeID := range nodeIDs {
+		node := nodes[nodeID]
 		if node.ParentID != "" {
 			if parent, exists := nodes[node.ParentID]; exists {
 				parent.Children = append(parent.Children, node.ID)
@@ -253,7 +254,7 @@ func (d *Database) getTagsForNode(nodeID string) ([]string, error) {
 }
 
 func (d *Database) getChildrenIDs(parentID string) ([]string, error) {
-	rows, err := d.db.Query("SELECT id FROM nodes WHERE parent_id = ?", parentID)
+	rows, err := d.db.Query("SELECT n.id FROM nodes n WHERE n.parent_id = ? ORDER BY "+nodeSiblingOrder, parentID)
 	if err != nil {
 		return nil, err
 	}
@@ -272,19 +273,7 @@ func (d *Database) getChildrenIDs(parentID string) ([]string, error) {
 }
 
 func (d *Database) GetNode(id string) (*MessageNode, error) {
-	var node MessageNode
-	var expanded, selected, hasLoaded, locked int
-
-	err := d.db.QueryRow(`
-		SELECT id, type, content, summary, timestamp, parent_id,
-		       expanded, selected, session_id, has_loaded, locked
-		FROM nodes
-		WHERE id = ?
-	`, id).Scan(
-		&node.ID, &node.Type, &node.Content, &node.Summary, &node.Timestamp,
-		&node.ParentID, &expanded, &selected, &node.SessionID, &hasLoaded, &locked,
-	)
-
+	node, err := scanNode(d.db.QueryRow(fmt.Sprintf("SELECT %s FROM nodes n WHERE n.id = ?", nodeSelectColumns), id))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -292,11 +281,6 @@ func (d *Database) GetNode(id string) (*MessageNode, error) {
 		return nil, err
 	}
 
-	node.Expanded = expanded == 1
-	node.Selected = selected == 1
-	node.HasLoaded = hasLoaded == 1
-	node.Locked = locked == 1
-
 	tags, err := d.getTagsForNode(node.ID)
 	if err != nil {
 		return nil, err
@@ -309,14 +293,46 @@ func (d *Database) GetNode(id string) (*MessageNode, error) {
 	}
 	node.Children = children
 
-	return &node, nil
+	return node, nil
 }
 
-func (d *Database) InsertFolder(folder *Folder) error {
+// withTx runs fn in a single write transaction, rolling back if it fails.
+func (d *Database) withTx(fn func(tx *sql.Tx) error) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	_, err := d.db.Exec(
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *Database) InsertFolder(folder *Folder) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		return insertFolderTx(tx, folder)
+	})
+}
+
+// EnsureFolder creates a folder unless one with the same ID exists.
+func (d *Database) EnsureFolder(folder *Folder) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"INSERT OR IGNORE INTO folders (id, name, color, created_at) VALUES (?, ?, ?, ?)",
+			folder.ID, folder.Name, folder.Color, folder.CreatedAt,
+		)
+		return err
+	})
+}
+
+func insertFolderTx(tx *sql.Tx, folder *Folder) error {
+	_, err := tx.Exec(
 		"INSERT OR REPLACE INTO folders (id, name, color, created_at) VALUES (?, ?, ?, ?)",
 		folder.ID, folder.Name, folder.Color, folder.CreatedAt,
 	)
@@ -324,15 +340,12 @@ func (d *Database) InsertFolder(folder *Folder) error {
 }
 
 func (d *Database) InsertNode(folderID string, node *MessageNode) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-
-	tx, err := d.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+	return d.withTx(func(tx *sql.Tx) error {
+		return insertNodeTx(tx, folderID, node)
+	})
+}
 
+func insertNodeTx(tx *sql.Tx, folderID string, node *MessageNode) error {
 	expanded := 0
 	if node.Expanded {
 		expanded = 1
@@ -349,14 +362,30 @@ func (d *Database) InsertNode(folderID string, node *MessageNode) error {
 	if node.Locked {
 		locked = 1
 	}
+	estimated := 0
+	if node.TimestampEstimated {
+		estimated = 1
+	}
+
+	if err := deindexNodeTx(tx, node.ID); err != nil {
+		return err
+	}
 
-	_, err = tx.Exec(`
+	tokens := node.tokenCounts()
+	errorKind, errorName, errorMessage := node.errorFields()
+	_, err := tx.Exec(`
 		INSERT OR REPLACE INTO nodes 
 		(id, folder_id, type, content, summary, timestamp, parent_id, 
-		 expanded, selected, session_id, has_loaded, locked)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 expanded, selected, session_id, has_loaded, locked, sort_index, deleted_upstream_at, timestamp_estimated,
+		 model_id, provider_id, agent, tokens_input, tokens_output, tokens_reasoning,
+		 tokens_cache_read, tokens_cache_write, cost, completed_at,
+		 latency_ms, error_kind, error_name, error_message, boundary)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, 0), NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`, node.ID, folderID, node.Type, node.Content, node.Summary, node.Timestamp,
-		node.ParentID, expanded, selected, node.SessionID, hasLoaded, locked)
+		node.ParentID, expanded, selected, node.SessionID, hasLoaded, locked, node.SortIndex, node.DeletedUpstreamAt, estimated,
+		node.ModelID, node.ProviderID, node.Agent, tokens.Input, tokens.Output, tokens.Reasoning,
+		tokens.CacheRead, tokens.CacheWrite, node.Cost, node.CompletedAt,
+		node.LatencyMs, errorKind, errorName, errorMessage, node.Boundary)
 	if err != nil {
 		return err
 	}
@@ -373,126 +402,683 @@ func (d *Database) InsertNode(folderID string, node *MessageNode) error {
 		}
 	}
 
-	return tx.Commit()
+	return indexNodeTx(tx, node.ID)
+}
+
+// InsertNodeWithFolder creates a folder and files its first node in one
+// transaction.
+func (d *Database) InsertNodeWithFolder(folder *Folder, node *MessageNode) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		if err := insertFolderTx(tx, folder); err != nil {
+			return err
+		}
+		return insertNodeTx(tx, folder.ID, node)
+	})
 }
 
 func (d *Database) UpdateNode(folderID string, node *MessageNode) error {
 	return d.InsertNode(folderID, node)
 }
 
-func (d *Database) DeleteFolder(id string) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// WriteSyncBatch files synced messages together with the checkpoints that
+// describe them, so a checkpoint is never recorded for a message that did
+// not make it into the database. A message that is already known only has
+// its summary and tags refreshed, keeping whatever the user or hydration
+// has set. It returns the messages that were new.
+func (d *Database) WriteSyncBatch(folderID string, nodes []*MessageNode, checkpoints []SyncCheckpoint) ([]*MessageNode, error) {
+	var inserted []*MessageNode
+	err := d.withTx(func(tx *sql.Tx) error {
+		inserted = inserted[:0]
+		for _, node := range nodes {
+			var exists bool
+			if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM nodes WHERE id = ?)", node.ID).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				if err := insertNodeTx(tx, folderID, node); err != nil {
+					return err
+				}
+				inserted = append(inserted, node)
+				continue
+			}
+			if err := refreshNodeTx(tx, node); err != nil {
+				return err
+			}
+		}
+		return saveCheckpointsTx(tx, checkpoints)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
 
-	_, err := d.db.Exec("DELETE FROM folders WHERE id = ?", id)
-	return err
+// MarkDeletedUpstream tombstones nodes whose source files are gone and
+// drops the checkpoints of those files. Nodes are kept so nothing the user
+// curated is lost. It returns how many nodes were newly marked.
+func (d *Database) MarkDeletedUpstream(ids []string, checkpointPaths []string) (int, error) {
+	if len(ids) == 0 && len(checkpointPaths) == 0 {
+		return 0, nil
+	}
+
+	marked := 0
+	err := d.withTx(func(tx *sql.Tx) error {
+		now := time.Now().Format(time.RFC3339)
+		for _, id := range ids {
+			result, err := tx.Exec("UPDATE nodes SET deleted_upstream_at = ? WHERE id = ? AND deleted_upstream_at IS NULL", now, id)
+			if err != nil {
+				return err
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			marked += int(affected)
+		}
+		for _, path := range checkpointPaths {
+			if _, err := tx.Exec("DELETE FROM sync_checkpoints WHERE path = ?", path); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return marked, nil
 }
 
-func (d *Database) DeleteNode(id string) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// PurgeDeletedUpstream removes tombstoned nodes. Locked nodes are always
+// kept. It returns the IDs of the removed nodes.
+func (d *Database) PurgeDeletedUpstream() ([]string, error) {
+	var purged []string
+	err := d.withTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id FROM nodes WHERE deleted_upstream_at IS NOT NULL AND locked = 0")
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			purged = append(purged, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
 
-	_, err := d.db.Exec("DELETE FROM nodes WHERE id = ?", id)
-	return err
+		for _, id := range purged {
+			if err := deleteNodeTx(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
 }
 
-func (d *Database) UpdateNodeLock(id string, locked bool) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// refreshNodeTx updates the fields of a known node that come from its
+// source file, including the usage and outcome OpenCode fills in once a
+// response completes or fails. A file that reappeared upstream lifts the
+// node's tombstone.
+func refreshNodeTx(tx *sql.Tx, node *MessageNode) error {
+	if err := deindexNodeTx(tx, node.ID); err != nil {
+		return err
+	}
+	tokens := node.tokenCounts()
+	errorKind, errorName, errorMessage := node.errorFields()
+	if _, err := tx.Exec(`
+		UPDATE nodes SET summary = ?, deleted_upstream_at = NULL,
+			model_id = ?, provider_id = ?, agent = ?, tokens_input = ?, tokens_output = ?, tokens_reasoning = ?,
+			tokens_cache_read = ?, tokens_cache_write = ?, cost = ?, completed_at = ?,
+			latency_ms = ?, error_kind = ?, error_name = ?, error_message = ?,
+			boundary = COALESCE(NULLIF(?, ''), boundary)
+		WHERE id = ?`,
+		node.Summary, node.ModelID, node.ProviderID, node.Agent, tokens.Input, tokens.Output, tokens.Reasoning,
+		tokens.CacheRead, tokens.CacheWrite, node.Cost, node.CompletedAt,
+		node.LatencyMs, errorKind, errorName, errorMessage, node.Boundary, node.ID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM tags WHERE node_id = ?", node.ID); err != nil {
+		return err
+	}
+	for _, tag := range node.Tags {
+		if _, err := tx.Exec("INSERT INTO tags (node_id, tag) VALUES (?, ?)", node.ID, tag); err != nil {
+			return err
+		}
+	}
+	return indexNodeTx(tx, node.ID)
+}
 
-	lockedInt := 0
-	if locked {
-		lockedInt = 1
+// DeleteFolder removes a folder together with every node filed under it.
+func (d *Database) DeleteFolder(id string) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		return deleteFolderTx(tx, id)
+	})
+}
+
+func deleteFolderTx(tx *sql.Tx, id string) error {
+	rows, err := tx.Query("SELECT id FROM nodes WHERE folder_id = ?", id)
+	if err != nil {
+		return err
+	}
+	var nodeIDs []string
+	for rows.Next() {
+		var nodeID string
+		if err := rows.Scan(&nodeID); err != nil {
+			rows.Close()
+			return err
+		}
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
 	}
 
-	_, err := d.db.Exec("UPDATE nodes SET locked = ? WHERE id = ?", lockedInt, id)
+	for _, nodeID := range nodeIDs {
+		if err := deleteNodeTx(tx, nodeID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM folders WHERE id = ?", id)
 	return err
 }
 
-func (d *Database) SearchNodes(query string, searchRaw bool) (map[string]*MessageNode, error) {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+func (d *Database) DeleteNode(id string) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		return deleteNodeTx(tx, id)
+	})
+}
 
-	results := make(map[string]*MessageNode)
-	queryLower := strings.ToLower(query)
+func deleteNodeTx(tx *sql.Tx, id string) error {
+	if err := deindexNodeTx(tx, id); err != nil {
+		return err
+	}
 
-	rows, err := d.db.Query(`
-		SELECT id, type, content, summary, timestamp, parent_id, 
-		       expanded, selected, session_id, has_loaded, locked
-		FROM nodes
-	`)
-	if err != nil {
-		return nil, err
+	for _, query := range []string{
+		"DELETE FROM tags WHERE node_id = ?",
+		"DELETE FROM parts WHERE message_id = ?",
+		"DELETE FROM hydration_state WHERE message_id = ?",
+		"DELETE FROM nodes WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var node MessageNode
-		var expanded, selected, hasLoaded, locked int
+	return nil
+}
 
-		err := rows.Scan(
-			&node.ID, &node.Type, &node.Content, &node.Summary, &node.Timestamp,
-			&node.ParentID, &expanded, &selected, &node.SessionID, &hasLoaded, &locked,
-		)
+// ReorderNode moves a node under newParentID (its folder's root level when
+// empty) and ranks it among its new siblings. When preceding is not nil it
+// lists the siblings shown above the node's new place, top to bottom;
+// otherwise newIndex indexes the siblings in sibling order, and an index
+// out of range leaves the node unranked, in time order. The new parent
+// must be in the node's folder. Only the ranks that must change are
+// written; they are returned, 0 meaning none.
+func (d *Database) ReorderNode(nodeID, newParentID string, newIndex int, preceding []string) (map[string]int, error) {
+	var ranks map[string]int
+
+	err := d.withTx(func(tx *sql.Tx) error {
+		var folderID string
+		if err := tx.QueryRow("SELECT folder_id FROM nodes WHERE id = ?", nodeID).Scan(&folderID); err != nil {
+			return err
+		}
+		if newParentID != "" {
+			var parentFolderID string
+			if err := tx.QueryRow("SELECT folder_id FROM nodes WHERE id = ?", newParentID).Scan(&parentFolderID); err != nil {
+				return err
+			}
+			if parentFolderID != folderID {
+				return fmt.Errorf("parent %s is in folder %s, not %s", newParentID, parentFolderID, folderID)
+			}
+		}
+
+		var rows *sql.Rows
+		var err error
+		if newParentID != "" {
+			rows, err = tx.Query("SELECT n.id, COALESCE(n.sort_index, 0) FROM nodes n WHERE n.parent_id = ? AND n.id != ? ORDER BY "+nodeSiblingOrder,
+				newParentID, nodeID)
+		} else {
+			rows, err = tx.Query("SELECT n.id, COALESCE(n.sort_index, 0) FROM nodes n WHERE n.folder_id = ? AND COALESCE(n.parent_id, '') = '' AND n.id != ? ORDER BY "+nodeSiblingOrder,
+				folderID, nodeID)
+		}
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		var siblings []rankedSibling
+		for rows.Next() {
+			var sibling rankedSibling
+			if err := rows.Scan(&sibling.id, &sibling.rank); err != nil {
+				rows.Close()
+				return err
+			}
+			siblings = append(siblings, sibling)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
 		}
 
-		node.Expanded = expanded == 1
-		node.Selected = selected == 1
-		node.HasLoaded = hasLoaded == 1
-		node.Locked = locked == 1
+		ranks = placeRanks(siblings, nodeID, newIndex, preceding)
 
-		tags, err := d.getTagsForNode(node.ID)
-		if err != nil {
-			return nil, err
+		if _, err := tx.Exec("UPDATE nodes SET parent_id = ? WHERE id = ?", newParentID, nodeID); err != nil {
+			return err
 		}
-		node.Tags = tags
 
-		children, err := d.getChildrenIDs(node.ID)
+		stmt, err := tx.Prepare("UPDATE nodes SET sort_index = NULLIF(?, 0) WHERE id = ?")
 		if err != nil {
-			return nil, err
+			return err
 		}
-		node.Children = children
+		defer stmt.Close()
 
-		score, _ := calculateMatchScore(queryLower, &node, searchRaw)
-		if score > 0 {
-			results[node.ID] = &node
+		for id, rank := range ranks {
+			if _, err := stmt.Exec(rank, id); err != nil {
+				return err
+			}
 		}
-	}
+		return nil
+	})
 
-	return results, nil
+	return ranks, err
 }
 
-func (d *Database) GetTotalMessageCount() (int, error) {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+// rankGap spaces the ranks placeRanks hands out, so a node can usually be
+// placed between two others without re-ranking either.
+const rankGap = 1024
 
-	var count int
-	err := d.db.QueryRow("SELECT COUNT(*) FROM nodes").Scan(&count)
-	return count, err
+// rankedSibling is a sibling and its rank, 0 when it has none.
+type rankedSibling struct {
+	id   string
+	rank int
 }
 
-func (d *Database) IsEmpty() (bool, error) {
-	count, err := d.GetTotalMessageCount()
-	return count == 0, err
+// placeRanks works out the ranks that place nodeID among siblings, given
+// in sibling order without it, as ReorderNode describes. The preceding
+// siblings keep their ranks where those are already in order and the node
+// goes between them and the next ranked sibling; the ranked siblings after
+// it are pushed down only as far as they collide. It returns the ranks
+// that change.
+func placeRanks(siblings []rankedSibling, nodeID string, newIndex int, preceding []string) map[string]int {
+	ranks := map[string]int{}
+	if preceding == nil {
+		if newIndex < 0 || newIndex > len(siblings) {
+			ranks[nodeID] = 0
+			return ranks
+		}
+		preceding = []string{}
+		for _, sibling := range siblings[:newIndex] {
+			preceding = append(preceding, sibling.id)
+		}
+	}
+
+	rankOf := make(map[string]int, len(siblings))
+	for _, sibling := range siblings {
+		rankOf[sibling.id] = sibling.rank
+	}
+	above := make(map[string]bool, len(preceding))
+	last := 0
+	for _, id := range preceding {
+		rank, exists := rankOf[id]
+		if !exists || above[id] {
+			continue
+		}
+		above[id] = true
+		if rank <= last {
+			rank = last + rankGap
+			ranks[id] = rank
+		}
+		last = rank
+	}
+
+	// The ranked siblings left come after the node, in rank order.
+	var below []rankedSibling
+	for _, sibling := range siblings {
+		if sibling.rank != 0 && !above[sibling.id] {
+			below = append(below, sibling)
+		}
+	}
+	if len(below) > 0 && below[0].rank > last+1 {
+		ranks[nodeID] = last + (below[0].rank-last)/2
+		return ranks
+	}
+	ranks[nodeID] = last + rankGap
+	next := ranks[nodeID]
+	for _, sibling := range below {
+		if sibling.rank > next {
+			break
+		}
+		next += rankGap
+		ranks[sibling.id] = next
+	}
+	return ranks
 }
 
-func (d *Database) DeleteAllData() error {
+// ImportFolders writes folders and all of their nodes in one transaction,
+// so a failed import leaves the database untouched.
+func (d *Database) ImportFolders(folders map[string]*Folder) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		for _, folder := range folders {
+			if err := insertFolderTx(tx, folder); err != nil {
+				return fmt.Errorf("folder %s: %w", folder.ID, err)
+			}
+			for _, node := range folder.Nodes {
+				if err := insertNodeTx(tx, folder.ID, node); err != nil {
+					return fmt.Errorf("node %s: %w", node.ID, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (d *Database) ReplaceParts(messageID string, parts []*MessagePart) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	_, err := d.db.Exec("DELETE FROM tags")
+	tx, err := d.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = d.db.Exec("DELETE FROM nodes")
-	if err != nil {
+	if err := replacePartsTx(tx, messageID, parts); err != nil {
 		return err
 	}
 
-	_, err = d.db.Exec("DELETE FROM folders")
+	return tx.Commit()
+}
+
+func replacePartsTx(tx *sql.Tx, messageID string, parts []*MessagePart) error {
+	if _, err := tx.Exec("DELETE FROM parts WHERE message_id = ?", messageID); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT OR REPLACE INTO parts
+		(id, message_id, session_id, position, type, text, tool, call_id, status, title,
+		 input, output, error, file_path, mime, hash, files, snapshot, reason, started_at, ended_at, child_session_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, part := range parts {
+		files := ""
+		if len(part.Files) > 0 {
+			data, err := json.Marshal(part.Files)
+			if err != nil {
+				return err
+			}
+			files = string(data)
+		}
+
+		_, err = stmt.Exec(part.ID, messageID, part.SessionID, part.Position, part.Type, part.Text,
+			part.Tool, part.CallID, part.Status, part.Title, part.Input, part.Output, part.Error,
+			part.FilePath, part.Mime, part.Hash, files, part.Snapshot, part.Reason, part.StartedAt, part.EndedAt,
+			part.ChildSessionID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A compaction is only known from the message's parts, so the
+	// message file being synced again keeps it.
+	if boundary := partsBoundary(parts); boundary != "" {
+		if _, err := tx.Exec("UPDATE nodes SET boundary = ? WHERE id = ? AND boundary = ''", boundary, messageID); err != nil {
+			return err
+		}
+	}
+
+	return linkSubagentsTx(tx, "message_id = ?", messageID)
+}
+
+// StoreHydration writes a message's parts, its text content and the part
+// directory signature they were read from in a single transaction. The
+// content of a locked message is left alone once it has been loaded.
+func (d *Database) StoreHydration(messageID string, parts []*MessagePart, content, signature string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replacePartsTx(tx, messageID, parts); err != nil {
+		return err
+	}
+
+	if err := deindexNodeTx(tx, messageID); err != nil {
+		return err
+	}
+
+	// Once loaded, the content of a locked message is the user's to keep.
+	if _, err := tx.Exec("UPDATE nodes SET content = ?, has_loaded = 1 WHERE id = ? AND (locked = 0 OR has_loaded = 0)", content, messageID); err != nil {
+		return err
+	}
+
+	if err := indexNodeTx(tx, messageID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT OR REPLACE INTO hydration_state (message_id, signature, hydrated_at) VALUES (?, ?, ?)",
+		messageID, signature, time.Now().Format(time.RFC3339),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UnloadedNodeIDs lists the nodes of a folder whose content has not been
+// loaded, newest first.
+func (d *Database) UnloadedNodeIDs(folderID string) ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	rows, err := d.db.Query(`
+		SELECT id FROM nodes
+		WHERE folder_id = ? AND has_loaded = 0
+		ORDER BY julianday(timestamp) DESC, id
+	`, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// HydrationSignatures returns the part signatures messages were last
+// hydrated with, keyed by message ID. Messages never hydrated are left out.
+func (d *Database) HydrationSignatures(ids []string) (map[string]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	stmt, err := d.db.Prepare("SELECT signature FROM hydration_state WHERE message_id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	signatures := make(map[string]string, len(ids))
+	for _, id := range ids {
+		var signature string
+		if err := stmt.QueryRow(id).Scan(&signature); err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			return nil, err
+		}
+		signatures[id] = signature
+	}
+	return signatures, nil
+}
+
+func (d *Database) GetParts(messageID string) ([]*MessagePart, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	rows, err := d.db.Query(`
+		SELECT id, message_id, session_id, position, type, text, tool, call_id, status, title,
+		       input, output, error, file_path, mime, hash, files, snapshot, reason, started_at, ended_at, child_session_id
+		FROM parts
+		WHERE message_id = ?
+		ORDER BY position
+	`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parts := []*MessagePart{}
+	for rows.Next() {
+		var part MessagePart
+		var files string
+
+		err := rows.Scan(
+			&part.ID, &part.MessageID, &part.SessionID, &part.Position, &part.Type, &part.Text,
+			&part.Tool, &part.CallID, &part.Status, &part.Title, &part.Input, &part.Output,
+			&part.Error, &part.FilePath, &part.Mime, &part.Hash, &files, &part.Snapshot, &part.Reason,
+			&part.StartedAt, &part.EndedAt, &part.ChildSessionID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if files != "" {
+			if err := json.Unmarshal([]byte(files), &part.Files); err != nil {
+				return nil, err
+			}
+		}
+
+		parts = append(parts, &part)
+	}
+
+	return parts, rows.Err()
+}
+
+func (d *Database) UpdateNodeLock(id string, locked bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	lockedInt := 0
+	if locked {
+		lockedInt = 1
+	}
+
+	_, err := d.db.Exec("UPDATE nodes SET locked = ? WHERE id = ?", lockedInt, id)
+	return err
+}
+
+func (d *Database) GetTotalMessageCount() (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM nodes").Scan(&count)
+	return count, err
+}
+
+// CountFolderNodes counts the nodes filed in a folder.
+func (d *Database) CountFolderNodes(folderID string) (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM nodes WHERE folder_id = ?", folderID).Scan(&count)
+	return count, err
+}
+
+// CountSessionFolderNodes counts the nodes in the session folders imported
+// from a source, whose IDs start with the source's folder ID.
+func (d *Database) CountSessionFolderNodes(folderID string) (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var count int
+	err := d.db.QueryRow(
+		"SELECT COUNT(*) FROM nodes WHERE substr(folder_id, 1, ?) = ?",
+		len(folderID)+1, folderID+"_",
+	).Scan(&count)
+	return count, err
+}
+
+func (d *Database) IsEmpty() (bool, error) {
+	count, err := d.GetTotalMessageCount()
+	return count == 0, err
+}
+
+func (d *Database) DeleteAllData() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("DELETE FROM tags")
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec("DELETE FROM parts")
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec("DELETE FROM hydration_state")
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec("DELETE FROM sync_checkpoints")
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec("DELETE FROM sessions")
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec("DELETE FROM projects")
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec("DELETE FROM nodes_fts")
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec("DELETE FROM nodes")
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec("DELETE FROM folders")
 	if err != nil {
 		return err
 	}
@@ -508,49 +1094,60 @@ type SyncProgress struct {
 	Error         string `json:"error,omitempty"`
 }
 
+// HistorySource is a prompt history file imported into a folder of its
+// own. Extra sources can be configured in config.json.
 type HistorySource struct {
-	Path     string
-	FolderID string
-	Name     string
-	Color    string
-	Format   string // "jsonl" or "raw"
+	Path     string `json:"path"`
+	FolderID string `json:"folder"`
+	Name     string `json:"name,omitempty"`
+	Color    string `json:"color,omitempty"`
+	Format   string `json:"format,omitempty"` // an importer name; detected when empty
+	Disabled bool   `json:"disabled,omitempty"`
 }
 
+const (
+	// syncBatchSize is how many changed messages the sync writer commits
+	// per transaction.
+	syncBatchSize = 500
+)
+
+// syncReaders is how many session directories are parsed at once.
+var syncReaders = min(runtime.NumCPU(), 8)
+
 type SyncManager struct {
 	db               *Database
 	store            *Store
 	dataPath         string
 	msgPath          string
 	partPath         string
+	sessionPath      string
+	projectPath      string
 	historySources   []HistorySource
 	progressCallback func(SyncProgress)
 	cancelChan       chan struct{}
 	running          bool
-	mu               sync.RWMutex
+	priorityChan     chan hydrationRequest
+
+	// The hydration queue holds the messages whose parts are still to be
+	// read; a loop works through it while hydrating is set.
+	hydrationQueue     []string
+	queued             map[string]bool
+	hydrating          bool
+	hydrationCancelled bool
+	hydrationLoops     sync.WaitGroup
+	watcher            *Watcher
+	mu                 sync.RWMutex
 }
 
 func NewSyncManager(db *Database, store *Store, dataPath string, progressCallback func(SyncProgress)) *SyncManager {
 	msgPath := filepath.Join(dataPath, "storage", "message")
 	partPath := filepath.Join(dataPath, "storage", "part")
 
-	localPath := filepath.Dir(filepath.Dir(dataPath))
-	
-	historySources := []HistorySource{
-		{
-			Path:     filepath.Join(localPath, "state", "opencode", "prompt-history.jsonl"),
-			FolderID: "webui",
-			Name:     "Web UI History",
-			Color:    "#6b8afd",
-			Format:   "jsonl",
-		},
-		{
-			Path:     filepath.Join(localPath, "share", "amp", "history.jsonl"),
-			FolderID: "amp",
-			Name:     "AMP History",
-			Color:    "#4ade80",
-			Format:   "jsonl",
-		},
+	var configured []HistorySource
+	if configManager != nil {
+		configured = configManager.getHistorySources()
 	}
+	historySources := mergeHistorySources(defaultHistorySources(dataPath), configured)
 
 	return &SyncManager{
 		db:               db,
@@ -558,9 +1155,13 @@ func NewSyncManager(db *Database, store *Store, dataPath string, progressCallbac
 		dataPath:         dataPath,
 		msgPath:          msgPath,
 		partPath:         partPath,
+		sessionPath:      filepath.Join(dataPath, "storage", "session"),
+		projectPath:      filepath.Join(dataPath, "storage", "project"),
 		historySources:   historySources,
 		progressCallback: progressCallback,
 		cancelChan:       make(chan struct{}),
+		priorityChan:     make(chan hydrationRequest, 32),
+		queued:           make(map[string]bool),
 	}
 }
 
@@ -581,11 +1182,18 @@ func (sm *SyncManager) CancelSync() {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
+	// running is cleared by performSync once it has actually stopped, so a
+	// new sync cannot start while this one is still unwinding.
 	if sm.running {
 		close(sm.cancelChan)
 		sm.cancelChan = make(chan struct{})
-		sm.running = false
 	}
+	// Hydration stops after the message it is on.
+	if sm.hydrating && len(sm.hydrationQueue) > 0 {
+		sm.hydrationCancelled = true
+	}
+	sm.hydrationQueue = nil
+	sm.queued = make(map[string]bool)
 }
 
 func (sm *SyncManager) performSync() {
@@ -595,15 +1203,46 @@ func (sm *SyncManager) performSync() {
 		sm.mu.Unlock()
 	}()
 
+	// CancelSync replaces the channel after closing it, so hold on to the
+	// one this run should watch.
+	sm.mu.RLock()
+	cancelChan := sm.cancelChan
+	sm.mu.RUnlock()
+
 	sm.reportProgress(SyncProgress{Phase: "init", Message: "Starting sync..."})
 
-	isEmpty, err := sm.db.IsEmpty()
+	// Checkpoints are only trustworthy while the nodes they describe are
+	// still there.
+	openChat, err := sm.db.GetFolder("openchat")
 	if err != nil {
 		sm.reportProgress(SyncProgress{Phase: "error", Message: "Failed to check database", Error: err.Error()})
 		return
 	}
+	if openChat == nil {
+		if err := sm.db.ClearSyncCheckpoints(sm.msgPath); err != nil {
+			sm.reportProgress(SyncProgress{Phase: "error", Message: "Failed to reset sync checkpoints", Error: err.Error()})
+			return
+		}
+	}
+	if err := sm.db.ClearRequestedCheckpoints(sm.msgPath); err != nil {
+		sm.reportProgress(SyncProgress{Phase: "error", Message: "Failed to reset sync checkpoints", Error: err.Error()})
+		return
+	}
 
-	messageNodes := make(map[string]*MessageNode)
+	checkpoints, err := sm.db.GetSyncCheckpoints(sm.msgPath)
+	if err != nil {
+		sm.reportProgress(SyncProgress{Phase: "error", Message: "Failed to read sync checkpoints", Error: err.Error()})
+		return
+	}
+
+	// Projects and session titles first, so messages can be grouped by
+	// them as soon as they arrive.
+	if err := sm.syncProjectInfo(); err != nil {
+		log.Printf("Failed to read OpenCode projects: %v", err)
+	}
+	if err := sm.syncSessionInfo(); err != nil {
+		log.Printf("Failed to read OpenCode sessions: %v", err)
+	}
 
 	sm.reportProgress(SyncProgress{Phase: "reading", Message: "Reading OpenCode messages..."})
 
@@ -616,287 +1255,372 @@ func (sm *SyncManager) performSync() {
 	totalSessions := len(sessions)
 	sm.reportProgress(SyncProgress{Phase: "reading", Message: fmt.Sprintf("Found %d sessions...", totalSessions), TotalMessages: totalSessions})
 
-	sessionCount := 0
-	totalMessages := 0
-
-	for _, sessionDir := range sessions {
-		select {
-		case <-sm.cancelChan:
-			sm.reportProgress(SyncProgress{Phase: "cancelled", Message: "Sync cancelled by user"})
-			return
-		default:
-		}
-
-		if !sessionDir.IsDir() {
-			continue
-		}
-
-		sessionPath := filepath.Join(sm.msgPath, sessionDir.Name())
-		messageFiles, err := os.ReadDir(sessionPath)
-		if err != nil {
-			log.Printf("Failed to read session %s: %v", sessionDir.Name(), err)
-			continue
-		}
-
-		msgCount := 0
-		for _, msgFile := range messageFiles {
-			if !strings.HasSuffix(msgFile.Name(), ".json") {
-				continue
-			}
+	if err := sm.db.EnsureFolder(openChatFolder()); err != nil {
+		sm.reportProgress(SyncProgress{Phase: "error", Message: "Failed to write to database", Error: err.Error()})
+		return
+	}
 
-			msgFilePath := filepath.Join(sessionPath, msgFile.Name())
-			msgData, err := os.ReadFile(msgFilePath)
-			if err != nil {
-				log.Printf("Failed to read message file %s: %v", msgFile.Name(), err)
-				continue
-			}
+	// Readers parse session directories concurrently; a single writer
+	// commits what they find.
+	paths := make(chan string)
+	scans := make(chan sessionScan, syncReaders)
 
-			var ocMsg OpenCodeMessage
-			if err := json.Unmarshal(msgData, &ocMsg); err != nil {
-				log.Printf("Failed to unmarshal message %s: %v", msgFile.Name(), err)
+	go func() {
+		defer close(paths)
+		for _, sessionDir := range sessions {
+			if !sessionDir.IsDir() {
 				continue
 			}
-
-			var nodeType string
-			var nodeTags []string
-
-			switch ocMsg.Role {
-			case "assistant":
-				nodeType = "response"
-				nodeTags = []string{ocMsg.Agent, ocMsg.Role}
-			case "system":
-				nodeType = "system"
-				nodeTags = []string{ocMsg.Agent, ocMsg.Role}
-			case "user":
-				nodeType = "user"
-				summaryTitle := getSummaryTitle(ocMsg.Summary)
-				if isAutoGenerated(summaryTitle) {
-					nodeType = "auto"
-					nodeTags = []string{ocMsg.Agent, ocMsg.Role, "auto-generated"}
-				} else {
-					nodeTags = []string{ocMsg.Agent, ocMsg.Role}
-				}
-			default:
-				nodeType = "prompt"
-				nodeTags = []string{ocMsg.Agent, ocMsg.Role}
-			}
-
-			title := getSummaryTitle(ocMsg.Summary)
-			if title == "" {
-				if ocMsg.Role == "assistant" {
-					title = "AI response"
-				} else if ocMsg.Role == "system" {
-					title = "System message"
-				} else {
-					title = fmt.Sprintf("%s message", ocMsg.Role)
-				}
-			}
-
-			node := &MessageNode{
-				ID:        ocMsg.ID,
-				Type:      nodeType,
-				Content:   "",
-				Summary:   title,
-				Timestamp: formatTimestamp(ocMsg.Time.Created),
-				ParentID:  ocMsg.ParentID,
-				Children:  []string{},
-				Tags:      nodeTags,
-				Expanded:  false,
-				Selected:  false,
-				SessionID: ocMsg.SessionID,
-				HasLoaded: false,
-			}
-
-			messageNodes[ocMsg.ID] = node
-			msgCount++
-			totalMessages++
-
-			if msgCount%100 == 0 || msgCount == 1 {
-				sm.reportProgress(SyncProgress{
-					Phase:         "reading",
-					Message:       fmt.Sprintf("Read %d/%d sessions (%d msgs)...", sessionCount+1, totalSessions, totalMessages),
-					Processed:     totalMessages,
-					TotalMessages: totalMessages,
-				})
+			select {
+			case paths <- filepath.Join(sm.msgPath, sessionDir.Name()):
+			case <-cancelChan:
+				return
 			}
 		}
+	}()
 
-		log.Printf("Loaded %d messages from session %s", msgCount, sessionDir.Name())
-		sessionCount++
-
-		if sessionCount%10 == 0 {
-			sm.reportProgress(SyncProgress{
-				Phase:         "reading",
-				Message:       fmt.Sprintf("Read %d/%d sessions (%d msgs)...", sessionCount, totalSessions, totalMessages),
-				Processed:     totalMessages,
-				TotalMessages: totalMessages,
-			})
-		}
-	}
-
-	sm.reportProgress(SyncProgress{
-		Phase:         "building",
-		Message:       "Building parent-child relationships...",
-		Processed:     totalMessages,
-		TotalMessages: totalMessages,
-	})
-
-	for _, node := range messageNodes {
-		select {
-		case <-sm.cancelChan:
-			sm.reportProgress(SyncProgress{Phase: "cancelled", Message: "Sync cancelled by user"})
-			return
-		default:
-		}
-
-		if node.ParentID != "" {
-			if parent, exists := messageNodes[node.ParentID]; exists {
-				parent.Children = append(parent.Children, node.ID)
+	var readers sync.WaitGroup
+	for i := 0; i < syncReaders; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for path := range paths {
+				scans <- scanSession(path, checkpoints)
 			}
-		}
+		}()
 	}
+	go func() {
+		readers.Wait()
+		close(scans)
+	}()
 
-	log.Printf("Built relationships for %d messages from %d sessions", len(messageNodes), totalSessions)
+	stats, cancelled := sm.writeScans(scans, totalSessions, cancelChan)
+	if cancelled {
+		sm.reportProgress(SyncProgress{Phase: "cancelled", Message: "Sync cancelled by user"})
+		return
+	}
 
-	if len(messageNodes) > 0 {
-		sm.reportProgress(SyncProgress{
-			Phase:         "writing",
-			Message:       "Writing to database...",
-			Processed:     0,
-			TotalMessages: len(messageNodes),
-		})
+	log.Printf("Wrote %d changed messages (%d new, %d failed); skipped %d unchanged sessions and %d unchanged files",
+		stats.written, stats.inserted, stats.failed, stats.skipped, stats.unchanged)
 
-		if isEmpty {
-			if err := sm.writeFullSync(messageNodes, totalSessions); err != nil {
-				sm.reportProgress(SyncProgress{Phase: "error", Message: "Failed to write to database", Error: err.Error()})
-				return
-			}
-		} else {
-			if err := sm.writeIncrementalSync(messageNodes); err != nil {
-				sm.reportProgress(SyncProgress{Phase: "error", Message: "Failed to write to database", Error: err.Error()})
-				return
-			}
-		}
+	if tombstoned, err := sm.tombstoneMissing(checkpoints, stats); err != nil {
+		log.Printf("Failed to mark messages deleted upstream: %v", err)
+	} else if tombstoned > 0 {
+		log.Printf("Marked %d messages as deleted upstream", tombstoned)
 	}
 
 	sm.reportProgress(SyncProgress{
 		Phase:         "reading_histories",
 		Message:       "Reading prompt histories...",
-		Processed:     len(messageNodes),
-		TotalMessages: len(messageNodes),
+		Processed:     stats.written,
+		TotalMessages: stats.written,
 	})
 
 	for _, source := range sm.historySources {
+		if source.Disabled {
+			continue
+		}
 		log.Printf("[SYNC] Syncing history source: %s (folder: %s) from path: %s", source.Name, source.FolderID, source.Path)
-		if err := sm.syncHistorySource(source); err != nil {
+		err := sm.syncHistorySource(source)
+		if err != nil {
 			log.Printf("[SYNC] Failed to sync history from %s: %v", source.Name, err)
 		} else {
 			log.Printf("[SYNC] Successfully synced history source: %s", source.Name)
 		}
+		sm.recordSourceSync(source, err)
 	}
 
 	sm.reportProgress(SyncProgress{
 		Phase:         "complete",
-		Message:       fmt.Sprintf("Sync complete: %d messages from %d sessions", len(messageNodes), totalSessions),
-		Processed:     len(messageNodes),
-		TotalMessages: len(messageNodes),
+		Message:       fmt.Sprintf("Sync complete: %d changed messages from %d sessions", stats.written, totalSessions),
+		Processed:     stats.written,
+		TotalMessages: stats.written,
 	})
+
+	// Content is loaded outside the sync, so it counts as done and another
+	// can start while the queue is worked through.
+	if !sm.hydrateSynced(stats.touched) {
+		sm.resolveHistoryTimes()
+	}
 }
 
-func (sm *SyncManager) writeFullSync(messageNodes map[string]*MessageNode, sessionCount int) error {
-	folder := &Folder{
+// openChatFolder is the folder OpenCode sessions are synced into.
+func openChatFolder() *Folder {
+	return &Folder{
 		ID:        "openchat",
 		Name:      "OpenChat History",
 		Color:     "#e94560",
 		CreatedAt: time.Now().Format(time.RFC3339),
-		Nodes:     messageNodes,
 	}
+}
 
-	if err := sm.db.InsertFolder(folder); err != nil {
-		return err
+// parseOpenCodeMessage turns one storage/message file into an unhydrated
+// node; content is filled in later from the message's parts.
+func parseOpenCodeMessage(msgData []byte) (*MessageNode, error) {
+	var ocMsg OpenCodeMessage
+	if err := json.Unmarshal(msgData, &ocMsg); err != nil {
+		return nil, err
+	}
+	if ocMsg.ID == "" {
+		return nil, fmt.Errorf("message has no id")
 	}
 
-	processed := 0
-	for _, node := range messageNodes {
-		select {
-		case <-sm.cancelChan:
-			return fmt.Errorf("sync cancelled")
-		default:
+	agent := ocMsg.Agent
+	if agent == "" {
+		agent = ocMsg.Mode
+	}
+
+	var nodeType string
+	var nodeTags []string
+
+	switch ocMsg.Role {
+	case "assistant":
+		nodeType = "response"
+		nodeTags = []string{agent, ocMsg.Role}
+	case "system":
+		nodeType = "system"
+		nodeTags = []string{agent, ocMsg.Role}
+	case "user":
+		nodeType = "user"
+		summaryTitle := getSummaryTitle(ocMsg.Summary)
+		if isAutoGenerated(summaryTitle) {
+			nodeType = "auto"
+			nodeTags = []string{agent, ocMsg.Role, "auto-generated"}
+		} else {
+			nodeTags = []string{agent, ocMsg.Role}
 		}
+	default:
+		nodeType = "prompt"
+		nodeTags = []string{agent, ocMsg.Role}
+	}
 
-		if err := sm.db.InsertNode(folder.ID, node); err != nil {
-			log.Printf("Failed to insert node %s: %v", node.ID, err)
-			continue
+	title := getSummaryTitle(ocMsg.Summary)
+	if title == "" {
+		if ocMsg.Role == "assistant" {
+			title = "AI response"
+		} else if ocMsg.Role == "system" {
+			title = "System message"
+		} else {
+			title = fmt.Sprintf("%s message", ocMsg.Role)
 		}
+	}
 
-		processed++
-		if processed%100 == 0 || processed == 1 {
-			sm.reportProgress(SyncProgress{
-				Phase:         "writing",
-				Message:       fmt.Sprintf("Writing %d/%d messages...", processed, len(messageNodes)),
-				Processed:     processed,
-				TotalMessages: len(messageNodes),
-			})
+	usage := MessageUsage{
+		ModelID:    ocMsg.ModelID,
+		ProviderID: ocMsg.ProviderID,
+		Agent:      agent,
+		Cost:       ocMsg.Cost,
+	}
+	if usage.ModelID == "" {
+		usage.ModelID, usage.ProviderID = ocMsg.Model.ModelID, ocMsg.Model.ProviderID
+	}
+	tokens := TokenUsage{
+		Input:      ocMsg.Tokens.Input,
+		Output:     ocMsg.Tokens.Output,
+		Reasoning:  ocMsg.Tokens.Reasoning,
+		CacheRead:  ocMsg.Tokens.Cache.Read,
+		CacheWrite: ocMsg.Tokens.Cache.Write,
+	}
+	if tokens != (TokenUsage{}) {
+		usage.Tokens = &tokens
+	}
+	if ocMsg.Time.Completed > 0 {
+		usage.CompletedAt = formatTimestamp(ocMsg.Time.Completed)
+		if ocMsg.Time.Created > 0 && ocMsg.Time.Completed >= ocMsg.Time.Created {
+			usage.LatencyMs = ocMsg.Time.Completed - ocMsg.Time.Created
+		}
+	}
+	if ocMsg.Error != nil {
+		usage.Error = &MessageError{
+			Kind:    openCodeErrorKind(ocMsg.Error.Name),
+			Name:    ocMsg.Error.Name,
+			Message: ocMsg.Error.Data.Message,
+		}
+		// Tagged so failed turns show up in the tag cloud and tag: filters.
+		if usage.Error.Kind == "aborted" {
+			nodeTags = append(nodeTags, "aborted")
+		} else {
+			nodeTags = append(nodeTags, "error")
 		}
 	}
 
-	return nil
+	return &MessageNode{
+		ID:           ocMsg.ID,
+		Type:         nodeType,
+		Content:      "",
+		Summary:      title,
+		Timestamp:    formatTimestamp(ocMsg.Time.Created),
+		ParentID:     ocMsg.ParentID,
+		Children:     []string{},
+		Tags:         nodeTags,
+		Expanded:     false,
+		Selected:     false,
+		SessionID:    ocMsg.SessionID,
+		HasLoaded:    false,
+		Boundary:     openCodeBoundary(&ocMsg),
+		MessageUsage: usage,
+	}, nil
 }
 
-func (sm *SyncManager) writeIncrementalSync(messageNodes map[string]*MessageNode) error {
-	existingFolder, err := sm.db.GetFolder("openchat")
-	if err != nil {
-		return err
+// openCodeErrorKind sorts the errors OpenCode records on assistant
+// messages into the kinds the error: filter matches.
+func openCodeErrorKind(name string) string {
+	switch name {
+	case "MessageAbortedError":
+		return "aborted"
+	case "MessageOutputLengthError":
+		return "output-length"
+	case "ProviderAuthError":
+		return "auth"
+	case "APIError":
+		return "api"
+	default:
+		return "unknown"
 	}
+}
 
-	if existingFolder == nil {
-		return fmt.Errorf("folder not found")
-	}
+// syncStats summarises what a sync saw and wrote.
+type syncStats struct {
+	sessions  int
+	skipped   int // unchanged sessions
+	unchanged int // unchanged files in changed sessions
+	written   int
+	inserted  int
+	failed    int
+	touched   []string // the messages of changed sessions, whose parts may have changed too
+
+	// What is on disk now, for spotting what was deleted upstream.
+	listed     map[string]bool // session directories
+	present    map[string]bool // message files
+	unreadable map[string]bool // session directories that could not be listed
+}
 
-	existingNodes, err := sm.db.GetNodesForFolder("openchat")
-	if err != nil {
-		return err
-	}
+// writeScans is the single writer behind the session readers. It commits
+// changed messages in batched transactions and pushes the new ones to
+// clients as each batch lands, so the first messages show up long before
+// a large sync finishes. A session's messages and checkpoints always land
+// in the same batch. It reports whether the sync was cancelled.
+func (sm *SyncManager) writeScans(scans <-chan sessionScan, totalSessions int, cancelChan <-chan struct{}) (syncStats, bool) {
+	stats := syncStats{
+		listed:     make(map[string]bool),
+		present:    make(map[string]bool),
+		unreadable: make(map[string]bool),
+	}
+	var nodes []*MessageNode
+	var checkpoints []SyncCheckpoint
+	cancelled := false
+
+	flush := func() {
+		if len(nodes) == 0 && len(checkpoints) == 0 {
+			return
+		}
 
-	newCount := 0
-	updatedCount := 0
+		inserted, err := sm.db.WriteSyncBatch("openchat", nodes, checkpoints)
+		if err != nil {
+			// Nothing in the batch is checkpointed, so the next sync
+			// reads these sessions again.
+			log.Printf("Failed to write %d synced messages: %v", len(nodes), err)
+			stats.failed += len(nodes)
+		} else {
+			stats.written += len(nodes)
+			stats.inserted += len(inserted)
+			if sm.store != nil && len(inserted) > 0 {
+				if err := sm.store.applyNodes("openchat", inserted); err != nil {
+					log.Printf("Failed to push %d synced messages: %v", len(inserted), err)
+				}
+			}
+		}
+
+		nodes, checkpoints = nil, nil
+		sm.reportProgress(SyncProgress{
+			Phase:         "writing",
+			Message:       fmt.Sprintf("Synced %d/%d sessions (%d changed msgs)...", stats.sessions, totalSessions, stats.written),
+			Processed:     stats.sessions,
+			TotalMessages: totalSessions,
+		})
+	}
 
-	for id, newNode := range messageNodes {
+	// Keep draining after a cancel so the readers can exit.
+	for scan := range scans {
+		if cancelled {
+			continue
+		}
 		select {
-		case <-sm.cancelChan:
-			return fmt.Errorf("sync cancelled")
+		case <-cancelChan:
+			cancelled = true
+			continue
 		default:
 		}
 
-		if existingNode, exists := existingNodes[id]; exists {
-			existingNode.Summary = newNode.Summary
-			existingNode.Tags = newNode.Tags
-			existingNode.Children = newNode.Children
-
-			if err := sm.db.UpdateNode("openchat", existingNode); err != nil {
-				log.Printf("Failed to update node %s: %v", id, err)
-			} else {
-				updatedCount++
+		stats.sessions++
+		stats.listed[scan.path] = true
+		for _, path := range scan.present {
+			stats.present[path] = true
+		}
+		switch {
+		case scan.err != nil:
+			stats.unreadable[scan.path] = true
+			log.Printf("Failed to read session %s: %v", filepath.Base(scan.path), scan.err)
+		case scan.skipped:
+			stats.skipped++
+		default:
+			if len(scan.nodes) > 0 {
+				log.Printf("Read %d changed messages from session %s", len(scan.nodes), filepath.Base(scan.path))
 			}
-		} else {
-			if err := sm.db.InsertNode("openchat", newNode); err != nil {
-				log.Printf("Failed to insert node %s: %v", id, err)
-			} else {
-				newCount++
+			stats.unchanged += scan.unchanged
+			for _, path := range scan.present {
+				stats.touched = append(stats.touched, strings.TrimSuffix(filepath.Base(path), ".json"))
+			}
+			nodes = append(nodes, scan.nodes...)
+			checkpoints = append(checkpoints, scan.files...)
+			if scan.complete {
+				checkpoints = append(checkpoints, scan.checkpoint)
 			}
 		}
 
-		if (newCount+updatedCount)%100 == 0 || (newCount+updatedCount) == 1 {
+		if len(nodes) >= syncBatchSize {
+			flush()
+		} else if stats.sessions%10 == 0 {
 			sm.reportProgress(SyncProgress{
-				Phase:         "writing",
-				Message:       fmt.Sprintf("Writing %d new, %d updated...", newCount, updatedCount),
-				Processed:     newCount + updatedCount,
-				TotalMessages: len(messageNodes),
+				Phase:         "reading",
+				Message:       fmt.Sprintf("Checked %d/%d sessions (%d changed msgs)...", stats.sessions, totalSessions, stats.written+len(nodes)),
+				Processed:     stats.sessions,
+				TotalMessages: totalSessions,
 			})
 		}
 	}
 
-	return nil
+	if !cancelled {
+		flush()
+	}
+	return stats, cancelled
+}
+
+// tombstoneMissing marks the messages whose files an earlier sync
+// checkpointed but which are gone now, and forgets those checkpoints.
+// OpenCode names message files after the message ID.
+func (sm *SyncManager) tombstoneMissing(previous map[string]SyncCheckpoint, stats syncStats) (int, error) {
+	var ids, stale []string
+	for path := range previous {
+		rel, ok := relativeTo(sm.msgPath, path)
+		if !ok {
+			continue
+		}
+		switch len(rel) {
+		case 1:
+			if !stats.listed[path] {
+				stale = append(stale, path)
+			}
+		case 2:
+			if stats.present[path] || stats.unreadable[filepath.Dir(path)] {
+				continue
+			}
+			ids = append(ids, strings.TrimSuffix(rel[1], ".json"))
+			stale = append(stale, path)
+		}
+	}
+
+	return sm.db.MarkDeletedUpstream(ids, stale)
 }
 
 func (sm *SyncManager) reportProgress(progress SyncProgress) {
@@ -911,141 +1635,217 @@ func (sm *SyncManager) IsRunning() bool {
 	return sm.running
 }
 
-type PromptHistoryEntry struct {
-	Input string `json:"input"`
-	Parts []struct {
-		Type     string `json:"type"`
-		Text     string `json:"text,omitempty"`
-		Filename string `json:"filename,omitempty"`
-		URL      string `json:"url,omitempty"`
-	} `json:"parts,omitempty"`
-	Mode string `json:"mode"`
-}
-
 func (sm *SyncManager) syncHistorySource(source HistorySource) error {
-	if _, err := os.Stat(source.Path); os.IsNotExist(err) {
+	info, err := os.Stat(source.Path)
+	if os.IsNotExist(err) {
 		log.Printf("History file not found, skipping: %s", source.Path)
 		return nil
 	}
+	if err != nil {
+		return err
+	}
+
+	importer, err := sourceImporter(source)
+	if err != nil {
+		return err
+	}
+	if session, ok := importer.(SessionImporter); ok {
+		return sm.syncSessionSource(source, session)
+	}
+
+	checkpoints, err := sm.db.GetSyncCheckpoints(source.Path)
+	if err != nil {
+		return err
+	}
+	previous, known := checkpoints[source.Path]
+	if folder, err := sm.db.GetFolder(source.FolderID); err != nil || folder == nil {
+		known = false
+	}
+	checkpoint, data, changed, err := checkFile(source.Path, info, previous, known)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		log.Printf("%s unchanged since last sync, skipping", source.Name)
+		if data != nil {
+			return sm.db.SaveSyncCheckpoints([]SyncCheckpoint{checkpoint})
+		}
+		return nil
+	}
+
+	if _, err := sm.importHistory(source, func(count int) {
+		sm.reportProgress(SyncProgress{
+			Phase:     "reading_history",
+			Message:   fmt.Sprintf("Read %d %s entries...", count, source.Name),
+			Processed: count,
+		})
+	}); err != nil {
+		return err
+	}
+
+	return sm.db.SaveSyncCheckpoints([]SyncCheckpoint{checkpoint})
+}
+
+// importHistory reads a history file and writes what it finds, returning
+// the IDs of the nodes that were added or given a real time.
+func (sm *SyncManager) importHistory(source HistorySource, onProgress func(count int)) (map[string]bool, error) {
+	promptNodes, order, err := readPromptHistory(source, sm.db.UserMessageTimes, onProgress)
+	if err != nil {
+		return nil, err
+	}
+	count := len(order)
+
+	log.Printf("Loaded %d entries from %s", count, source.Name)
+
+	if slices.Contains(legacyHistoryFolders, source.FolderID) {
+		if err := sm.db.RenameLegacyHistoryNodes(source.FolderID, order); err != nil {
+			return nil, fmt.Errorf("failed to rename line-numbered %s entries: %w", source.FolderID, err)
+		}
+	}
+
+	if len(promptNodes) == 0 {
+		return nil, nil
+	}
+	return sm.writeHistoryToDB(source, promptNodes, count)
+}
+
+// readPromptHistory parses a prompt history file into nodes with the
+// source's importer, returning them along with their IDs in file order.
+// Entries without a time of their own are matched to OpenCode messages
+// through lookup, if set, and are otherwise given an estimated one.
+// onProgress, if set, is called every 50 entries.
+func readPromptHistory(source HistorySource, lookup promptTimesLookup, onProgress func(count int)) (map[string]*MessageNode, []string, error) {
+	importer, err := sourceImporter(source)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	file, err := os.Open(source.Path)
 	if err != nil {
-		return fmt.Errorf("failed to open history file: %w", err)
+		return nil, nil, fmt.Errorf("failed to open history file: %w", err)
 	}
 	defer file.Close()
 
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
+		return nil, nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
 	baseTime := fileInfo.ModTime()
 
-	scanner := bufio.NewScanner(file)
 	promptNodes := make(map[string]*MessageNode)
+	occurrences := make(map[string]int)
+	pending := make(map[string][]*MessageNode)
+	var order []string
 	count := 0
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" {
-			continue
-		}
+	err = importer.Parse(file, func(entry HistoryEntry) {
+		count++
 
-		var entry PromptHistoryEntry
-		if strings.HasPrefix(trimmed, "{") {
-			// Try standard jsonl format
-			if err := json.Unmarshal([]byte(trimmed), &entry); err != nil {
-				// Fallback for different key names like "text" instead of "input"
-				var raw map[string]interface{}
-				if err2 := json.Unmarshal([]byte(trimmed), &raw); err2 == nil {
-					if txt, ok := raw["text"].(string); ok {
-						entry.Input = txt
-					} else if txt, ok := raw["input"].(string); ok {
-						entry.Input = txt
-					}
-					if mode, ok := raw["mode"].(string); ok {
-						entry.Mode = mode
-					}
-				} else {
-					log.Printf("Failed to parse history line: %v", err)
-					continue
-				}
-			}
-		} else {
-			// Try unquoting if it's a JSON string, otherwise raw
-			var txt string
-			if err := json.Unmarshal([]byte(trimmed), &txt); err == nil {
-				entry.Input = txt
-			} else {
-				entry.Input = trimmed
-			}
-			entry.Mode = "normal"
-		}
+		node := importer.Node(source, entry)
+		occurrences[node.Content]++
+		node.ID = historyNodeID(source.FolderID, node.Content, occurrences[node.Content])
 
-		if entry.Input == "" {
-			continue
+		if entry.Time.IsZero() {
+			// Ensure unique timestamps but close to file time
+			node.Timestamp = baseTime.Add(time.Duration(-count) * time.Minute).Format(time.RFC3339)
+			node.TimestampEstimated = true
+			pending[entry.Input] = append(pending[entry.Input], node)
+		} else {
+			node.Timestamp = entry.Time.Format(time.RFC3339)
 		}
 
-		count++
-		nodeId := fmt.Sprintf("%s_%d", source.FolderID, count)
+		promptNodes[node.ID] = node
+		order = append(order, node.ID)
 
-		summary := entry.Input
-		if len(summary) > 100 {
-			summary = summary[:97] + "..."
+		if count%50 == 0 && onProgress != nil {
+			onProgress(count)
 		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-		// Ensure unique timestamps but close to file time
-		timestamp := baseTime.Add(time.Duration(-count) * time.Minute).Format(time.RFC3339)
+	inferPromptTimes(pending, lookup)
 
-		content := entry.Input
-		if len(entry.Parts) > 0 {
-			content += "\n\nAttachments:\n"
-			for _, part := range entry.Parts {
-				if part.Type == "file" {
-					content += fmt.Sprintf("- %s\n", part.Filename)
-				}
+	return promptNodes, order, nil
+}
+
+// RenameLegacyHistoryNodes gives the nodes of a history source still named
+// after their line the ID that line has now, order holding the file's IDs
+// by line. Lines are paired by position, not content, so an entry the user
+// has edited keeps its node and is not imported again. Tags, parts,
+// children and hydration state follow, wherever the node was moved.
+func (d *Database) RenameLegacyHistoryNodes(folderID string, order []string) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		prefix := folderID + "_"
+		// Content-derived IDs are longer than any line number.
+		rows, err := tx.Query("SELECT id FROM nodes WHERE id LIKE ? ESCAPE '\\' AND length(id) < ?", escapeLike(prefix)+"%", len(prefix)+16)
+		if err != nil {
+			return err
+		}
+		renames := make(map[string]string)
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
 			}
+			suffix := strings.TrimPrefix(id, prefix)
+			line, err := strconv.Atoi(suffix)
+			if err != nil || line <= 0 || line > len(order) || strconv.Itoa(line) != suffix {
+				continue
+			}
+			renames[id] = order[line-1]
 		}
-
-		node := &MessageNode{
-			ID:        nodeId,
-			Type:      "prompt",
-			Content:   content,
-			Summary:   summary,
-			Timestamp: timestamp,
-			Tags:      []string{source.FolderID, entry.Mode},
-			HasLoaded: true,
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
 		}
 
-		promptNodes[nodeId] = node
-
-		if count%50 == 0 {
-			sm.reportProgress(SyncProgress{
-				Phase:     "reading_history",
-				Message:   fmt.Sprintf("Read %d %s entries...", count, source.Name),
-				Processed: count,
-			})
+		for oldID, newID := range renames {
+			var taken bool
+			if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM nodes WHERE id = ?)", newID).Scan(&taken); err != nil {
+				return err
+			}
+			if taken {
+				continue
+			}
+			for _, statement := range []string{
+				"UPDATE nodes SET id = ? WHERE id = ?",
+				"UPDATE nodes SET parent_id = ? WHERE parent_id = ?",
+				"UPDATE tags SET node_id = ? WHERE node_id = ?",
+				"UPDATE parts SET message_id = ? WHERE message_id = ?",
+				"UPDATE hydration_state SET message_id = ? WHERE message_id = ?",
+			} {
+				if _, err := tx.Exec(statement, newID, oldID); err != nil {
+					return err
+				}
+			}
 		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading history: %w", err)
-	}
-
-	log.Printf("Loaded %d entries from %s", count, source.Name)
-
-	if len(promptNodes) == 0 {
 		return nil
-	}
+	})
+}
 
-	return sm.writeHistoryToDB(source, promptNodes, count)
+// historyNodeID derives a prompt history entry's ID from its content, so
+// it survives the history file being trimmed or rotated. occurrence counts
+// repeats of the same prompt, in file order, from 1.
+func historyNodeID(folderID, content string, occurrence int) string {
+	id := folderID + "_" + hashBytes([]byte(content))[:16]
+	if occurrence > 1 {
+		id += fmt.Sprintf("_%d", occurrence)
+	}
+	return id
 }
 
-func (sm *SyncManager) writeHistoryToDB(source HistorySource, promptNodes map[string]*MessageNode, count int) error {
+// writeHistoryToDB adds entries the database does not have yet and gives
+// existing ones with an estimated time the real one, if it is now known.
+// It returns the IDs of the nodes it wrote.
+func (sm *SyncManager) writeHistoryToDB(source HistorySource, promptNodes map[string]*MessageNode, count int) (map[string]bool, error) {
 	existingFolder, err := sm.db.GetFolder(source.FolderID)
 	folderExists := (err == nil && existingFolder != nil)
 
+	changed := make(map[string]bool)
 	if !folderExists {
 		folder := &Folder{
 			ID:        source.FolderID,
@@ -1056,46 +1856,68 @@ func (sm *SyncManager) writeHistoryToDB(source HistorySource, promptNodes map[st
 		}
 
 		if err := sm.db.InsertFolder(folder); err != nil {
-			return fmt.Errorf("failed to create folder %s: %w", source.FolderID, err)
+			return nil, fmt.Errorf("failed to create folder %s: %w", source.FolderID, err)
 		}
 
-		processed := 0
 		for _, node := range promptNodes {
 			if err := sm.db.InsertNode(source.FolderID, node); err != nil {
 				log.Printf("Failed to insert %s entry %s: %v", source.FolderID, node.ID, err)
 				continue
 			}
-			processed++
+			changed[node.ID] = true
 		}
-		log.Printf("Wrote %d %s entries to database (new folder)", processed, source.Name)
-		return nil
+		log.Printf("Wrote %d %s entries to database (new folder)", len(changed), source.Name)
+		return changed, nil
 	}
 
 	existingNodes, err := sm.db.GetNodesForFolder(source.FolderID)
 	if err != nil {
-		return fmt.Errorf("failed to get existing %s nodes: %w", source.FolderID, err)
+		return nil, fmt.Errorf("failed to get existing %s nodes: %w", source.FolderID, err)
 	}
 
 	newCount := 0
+	resolved := make(map[string]string)
 	for id, newNode := range promptNodes {
-		if _, exists := existingNodes[id]; !exists {
-			if err := sm.db.InsertNode(source.FolderID, newNode); err != nil {
-				log.Printf("Failed to insert %s entry %s: %v", source.FolderID, id, err)
-				continue
-			}
-			newCount++
-
-			if newCount%20 == 0 {
-				sm.reportProgress(SyncProgress{
-					Phase:         "writing_history",
-					Message:       fmt.Sprintf("Writing %d/%d new %s entries...", newCount, count, source.Name),
-					Processed:     newCount,
-					TotalMessages: count,
-				})
+		if existing, exists := existingNodes[id]; exists {
+			if existing.TimestampEstimated && !newNode.TimestampEstimated {
+				resolved[id] = newNode.Timestamp
 			}
+			continue
+		}
+		// Entries the user moved to another folder stay there.
+		moved, err := sm.db.GetNode(id)
+		if err != nil {
+			log.Printf("Failed to look up %s entry %s: %v", source.FolderID, id, err)
+			continue
+		}
+		if moved != nil {
+			continue
+		}
+
+		if err := sm.db.InsertNode(source.FolderID, newNode); err != nil {
+			log.Printf("Failed to insert %s entry %s: %v", source.FolderID, id, err)
+			continue
+		}
+		changed[id] = true
+		newCount++
+
+		if newCount%20 == 0 {
+			sm.reportProgress(SyncProgress{
+				Phase:         "writing_history",
+				Message:       fmt.Sprintf("Writing %d/%d new %s entries...", newCount, count, source.Name),
+				Processed:     newCount,
+				TotalMessages: count,
+			})
 		}
 	}
 
-	log.Printf("Added %d new %s entries to database", newCount, source.Name)
-	return nil
+	if err := sm.db.ResolveTimestamps(resolved); err != nil {
+		return changed, fmt.Errorf("failed to update %s timestamps: %w", source.FolderID, err)
+	}
+	for id := range resolved {
+		changed[id] = true
+	}
+
+	log.Printf("Added %d new %s entries to database and found the time of %d more", newCount, source.Name, len(resolved))
+	return changed, nil
 }
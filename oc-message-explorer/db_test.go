@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestSessions(t *testing.T, msgPath string, sessions, perSession int) {
+	t.Helper()
+	for s := 0; s < sessions; s++ {
+		sessionID := fmt.Sprintf("ses_%03d", s)
+		dir := filepath.Join(msgPath, sessionID)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for m := 0; m < perSession; m++ {
+			id := fmt.Sprintf("msg_%03d_%03d", s, m)
+			content := fmt.Sprintf(`{"id":%q,"sessionID":%q,"role":"user","time":{"created":%d},"summary":{"title":"Message %d"}}`,
+				id, sessionID, 1760000000000+int64(s*perSession+m)*1000, m)
+			if err := os.WriteFile(filepath.Join(dir, id+".json"), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestPerformSyncWritesInBatches(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	sm := NewSyncManager(store.db, store, t.TempDir(), nil)
+	sm.historySources = nil
+
+	writeTestSessions(t, sm.msgPath, 30, 20)
+	syncAndHydrate(sm)
+
+	count, err := store.db.GetTotalMessageCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 600 {
+		t.Fatalf("expected 600 messages, got %d", count)
+	}
+
+	// New messages are pushed batch by batch, before the sync completes.
+	pushed := 0
+	for len(store.broadcastCh) > 0 {
+		msg := <-store.broadcastCh
+		if update, ok := msg.Data.(NodesUpdate); ok && msg.Type == MessageTypeNodes {
+			pushed += len(update.Nodes)
+		}
+	}
+	if pushed != 600 {
+		t.Errorf("expected 600 pushed messages, got %d", pushed)
+	}
+	if got := len(store.Folders["openchat"].Nodes); got != 600 {
+		t.Errorf("expected 600 messages in the store, got %d", got)
+	}
+}
+
+func TestWriteScansStopsOnCancel(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sm := NewSyncManager(db, nil, t.TempDir(), nil)
+	if err := db.EnsureFolder(openChatFolder()); err != nil {
+		t.Fatal(err)
+	}
+
+	scans := make(chan sessionScan, 3)
+	for i := 0; i < 3; i++ {
+		scans <- sessionScan{
+			path:     fmt.Sprintf("ses_%d", i),
+			nodes:    []*MessageNode{{ID: fmt.Sprintf("msg_%d", i), Type: "user", Timestamp: "2026-01-01T00:00:00Z"}},
+			complete: true,
+		}
+	}
+	close(scans)
+
+	cancelChan := make(chan struct{})
+	close(cancelChan)
+
+	stats, cancelled := sm.writeScans(scans, 3, cancelChan)
+	if !cancelled {
+		t.Fatal("expected the writer to report cancellation")
+	}
+	if stats.written != 0 {
+		t.Errorf("expected nothing written after cancel, got %d", stats.written)
+	}
+	if count, _ := db.GetTotalMessageCount(); count != 0 {
+		t.Errorf("expected an empty database, got %d messages", count)
+	}
+}
+
+func TestSyncTombstonesDeletedMessages(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sm := NewSyncManager(db, nil, t.TempDir(), nil)
+	sm.historySources = nil
+
+	writeTestSessions(t, sm.msgPath, 2, 3)
+	syncAndHydrate(sm)
+
+	if err := db.UpdateNodeLock("msg_001_000", true); err != nil {
+		t.Fatal(err)
+	}
+
+	removed := filepath.Join(sm.msgPath, "ses_000", "msg_000_000.json")
+	backup, err := os.ReadFile(removed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(sm.msgPath, "ses_001")); err != nil {
+		t.Fatal(err)
+	}
+	syncAndHydrate(sm)
+
+	deleted := map[string]bool{"msg_000_000": true, "msg_001_000": true, "msg_001_001": true, "msg_001_002": true}
+	for _, id := range []string{"msg_000_000", "msg_000_001", "msg_000_002", "msg_001_000", "msg_001_001", "msg_001_002"} {
+		node, err := db.GetNode(id)
+		if err != nil || node == nil {
+			t.Fatalf("expected %s to be kept, got %v", id, err)
+		}
+		if (node.DeletedUpstreamAt != "") != deleted[id] {
+			t.Errorf("%s: deletedUpstreamAt = %q", id, node.DeletedUpstreamAt)
+		}
+	}
+
+	response, err := db.SearchNodes(SearchRequest{Query: "message"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 2 {
+		t.Errorf("expected deleted messages to be hidden from search, got %d results", response.Total)
+	}
+	response, err = db.SearchNodes(SearchRequest{Query: "deleted:true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 4 {
+		t.Errorf("expected 4 results for deleted:true, got %d", response.Total)
+	}
+
+	// A file that comes back lifts the tombstone.
+	if err := os.WriteFile(removed, backup, 0644); err != nil {
+		t.Fatal(err)
+	}
+	syncAndHydrate(sm)
+	if node, _ := db.GetNode("msg_000_000"); node == nil || node.DeletedUpstreamAt != "" {
+		t.Errorf("expected restored message to be live again, got %+v", node)
+	}
+
+	purged, err := db.PurgeDeletedUpstream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 2 {
+		t.Errorf("expected 2 purged messages, got %v", purged)
+	}
+	if node, _ := db.GetNode("msg_001_000"); node == nil {
+		t.Error("expected the locked message to survive the purge")
+	}
+}
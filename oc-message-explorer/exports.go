@@ -0,0 +1,541 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// conversationsFile is what both ChatGPT and claude.ai name the file
+// holding every conversation in their data exports.
+const conversationsFile = "conversations.json"
+
+// maxExportSize bounds how much of an export /api/import reads.
+const maxExportSize = 1 << 30
+
+// ExportFormat converts a chat service's data export into folders, one
+// per conversation, whose messages keep the conversation's tree.
+type ExportFormat interface {
+	Name() string
+	// Detect reports whether a conversations.json is in this format, given
+	// its first conversation.
+	Detect(first map[string]json.RawMessage) bool
+	Convert(data []byte) (map[string]*Folder, error)
+}
+
+// exportFormats are the data exports /api/import understands besides its
+// own.
+var exportFormats = []ExportFormat{chatGPTExport{}, claudeExport{}}
+
+// readImport converts what was posted to /api/import into folders: the
+// app's own export, a service's conversations.json, or a zip holding one.
+// It returns the name of the format it found.
+func readImport(data []byte) (map[string]*Folder, string, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		conversations, err := conversationsFromZip(data)
+		if err != nil {
+			return nil, "", err
+		}
+		data = conversations
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var folders map[string]*Folder
+		if err := json.Unmarshal(trimmed, &folders); err != nil {
+			return nil, "", err
+		}
+		return folders, "oc-message-explorer", nil
+	}
+
+	format, err := detectExportFormat(trimmed)
+	if err != nil {
+		return nil, "", err
+	}
+	folders, err := format.Convert(trimmed)
+	return folders, format.Name(), err
+}
+
+// readImportPath reads an export from disk: a file, a zip, or the
+// directory a zip was extracted into.
+func readImportPath(path string) (map[string]*Folder, string, error) {
+	if isDir(path) {
+		found, err := findConversationsFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		path = found
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return readImport(data)
+}
+
+// resolveImportPath is where /api/import's ?path= points, which must lie
+// within root, the IMPORT_DIR the server was started with. Without one no
+// path is read: any page that can reach the server could otherwise have
+// it read whatever the user can. A relative path is taken from root, and
+// symlinks are followed before the check so none lead out of it.
+func resolveImportPath(root, path string) (string, error) {
+	if root == "" {
+		return "", fmt.Errorf("importing from a path is off; set IMPORT_DIR to the directory exports are read from")
+	}
+	root, err := filepath.Abs(expandHome(root))
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return "", fmt.Errorf("IMPORT_DIR: %w", err)
+	}
+
+	path = expandHome(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside IMPORT_DIR", path)
+	}
+	return resolved, nil
+}
+
+func detectExportFormat(data []byte) (ExportFormat, error) {
+	var conversations []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &conversations); err != nil {
+		return nil, fmt.Errorf("unrecognised import: %w", err)
+	}
+	if len(conversations) == 0 {
+		return nil, fmt.Errorf("the export holds no conversations")
+	}
+	for _, format := range exportFormats {
+		if format.Detect(conversations[0]) {
+			return format, nil
+		}
+	}
+	return nil, fmt.Errorf("unrecognised export format")
+}
+
+// conversationsFromZip returns the conversations.json in a zipped export,
+// which may sit in a top-level directory.
+func conversationsFromZip(data []byte) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip: %w", err)
+	}
+
+	var best *zip.File
+	for _, file := range archive.File {
+		if filepath.Base(file.Name) != conversationsFile {
+			continue
+		}
+		if best == nil || strings.Count(file.Name, "/") < strings.Count(best.Name, "/") {
+			best = file
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no %s in the zip", conversationsFile)
+	}
+
+	reader, err := best.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, maxExportSize))
+}
+
+// findConversationsFile returns the shallowest conversations.json under
+// dir.
+func findConversationsFile(dir string) (string, error) {
+	found := ""
+	depth := -1
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || entry.Name() != conversationsFile {
+			return nil
+		}
+		if d := strings.Count(path, string(filepath.Separator)); depth < 0 || d < depth {
+			found, depth = path, d
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if found == "" {
+		return "", fmt.Errorf("no %s in %s", conversationsFile, dir)
+	}
+	return found, nil
+}
+
+// conversationFolder builds the folder of one conversation. Children lists
+// are filled in from the nodes' parents, in the order nodes are given.
+func conversationFolder(id, name, color string, created time.Time, nodes []*MessageNode) *Folder {
+	folder := &Folder{
+		ID:        id,
+		Name:      name,
+		Color:     color,
+		CreatedAt: created.Format(time.RFC3339),
+		Nodes:     make(map[string]*MessageNode, len(nodes)),
+	}
+	for _, node := range nodes {
+		folder.Nodes[node.ID] = node
+	}
+	for _, node := range nodes {
+		if parent, ok := folder.Nodes[node.ParentID]; ok {
+			parent.Children = append(parent.Children, node.ID)
+		}
+	}
+	return folder
+}
+
+// exportNode is how every export format maps a message.
+func exportNode(id, service, role, content, sessionID string, when time.Time, estimated bool) *MessageNode {
+	node := &MessageNode{
+		ID:                 id,
+		Type:               "response",
+		Content:            content,
+		Summary:            truncateSummary(content),
+		Timestamp:          when.Format(time.RFC3339),
+		Tags:               []string{service, role},
+		SessionID:          sessionID,
+		HasLoaded:          true,
+		TimestampEstimated: estimated,
+	}
+	if role == "user" {
+		node.Type = "user"
+	}
+	return node
+}
+
+func conversationTitle(service, title string, nodes []*MessageNode) string {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		for _, node := range nodes {
+			if node.Type == "user" && node.Summary != "" {
+				title = node.Summary
+				break
+			}
+		}
+	}
+	if title == "" {
+		title = "Untitled"
+	}
+	return service + " - " + title
+}
+
+// chatGPTExport reads the conversations.json of a ChatGPT data export.
+// Each conversation's messages form a tree in "mapping", keyed by node ID,
+// where edited prompts and regenerated answers are siblings.
+type chatGPTExport struct{}
+
+type chatGPTConversation struct {
+	ID             string                 `json:"id"`
+	ConversationID string                 `json:"conversation_id"`
+	Title          string                 `json:"title"`
+	CreateTime     float64                `json:"create_time"`
+	Mapping        map[string]chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	ID       string          `json:"id"`
+	Message  *chatGPTMessage `json:"message"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Text        string            `json:"text"`
+		Result      string            `json:"result"`
+	} `json:"content"`
+	Metadata struct {
+		Hidden bool `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+func (chatGPTExport) Name() string { return "chatgpt" }
+
+func (chatGPTExport) Detect(first map[string]json.RawMessage) bool {
+	_, ok := first["mapping"]
+	return ok
+}
+
+func (chatGPTExport) Convert(data []byte) (map[string]*Folder, error) {
+	var conversations []chatGPTConversation
+	if err := json.Unmarshal(data, &conversations); err != nil {
+		return nil, fmt.Errorf("failed to parse ChatGPT export: %w", err)
+	}
+
+	folders := make(map[string]*Folder, len(conversations))
+	for _, conversation := range conversations {
+		sessionID := conversation.ConversationID
+		if sessionID == "" {
+			sessionID = conversation.ID
+		}
+		if sessionID == "" {
+			continue
+		}
+		created, ok := unixTime(conversation.CreateTime)
+		if !ok {
+			created = time.Now()
+		}
+
+		nodes := chatGPTNodes(conversation, sessionID, created)
+		if len(nodes) == 0 {
+			continue
+		}
+		folderID := "chatgpt_" + sessionID
+		folders[folderID] = conversationFolder(folderID, conversationTitle("ChatGPT", conversation.Title, nodes), "#10a37f", created, nodes)
+	}
+	return folders, nil
+}
+
+// chatGPTNodes walks a conversation's tree from its roots, keeping the
+// messages a reader would see. Children of a skipped node, such as the
+// empty root or a system prompt, are attached to its nearest kept
+// ancestor.
+func chatGPTNodes(conversation chatGPTConversation, sessionID string, created time.Time) []*MessageNode {
+	var roots []string
+	for id, node := range conversation.Mapping {
+		if _, ok := conversation.Mapping[node.Parent]; node.Parent == "" || !ok {
+			roots = append(roots, id)
+		}
+	}
+	sort.Strings(roots)
+
+	var nodes []*MessageNode
+	last := created
+	var walk func(id, parentID string)
+	walk = func(id, parentID string) {
+		entry, ok := conversation.Mapping[id]
+		if !ok {
+			return
+		}
+		if message := entry.Message; message != nil && !message.Metadata.Hidden && message.Author.Role != "system" {
+			if content := chatGPTContent(message); content != "" {
+				when, ok := unixTime(message.CreateTime)
+				if ok {
+					last = when
+				} else {
+					when = last
+				}
+				node := exportNode("chatgpt_"+id, "chatgpt", chatGPTRole(message.Author.Role), content, sessionID, when, !ok)
+				node.ParentID = parentID
+				nodes = append(nodes, node)
+				parentID = node.ID
+			}
+		}
+		for _, child := range entry.Children {
+			walk(child, parentID)
+		}
+	}
+	for _, root := range roots {
+		walk(root, "")
+	}
+	return nodes
+}
+
+func chatGPTRole(role string) string {
+	if role == "user" {
+		return "user"
+	}
+	// Tool output such as browsing or code results reads as part of the
+	// answer.
+	return "assistant"
+}
+
+// chatGPTContent reads a message's text. Text and multimodal messages keep
+// it in parts, where images and files are objects; code and tool output
+// keep it in text or result.
+func chatGPTContent(message *chatGPTMessage) string {
+	var texts []string
+	for _, part := range message.Content.Parts {
+		var text string
+		if json.Unmarshal(part, &text) == nil {
+			if strings.TrimSpace(text) != "" {
+				texts = append(texts, text)
+			}
+			continue
+		}
+		var object struct {
+			ContentType string `json:"content_type"`
+			Text        string `json:"text"`
+		}
+		if json.Unmarshal(part, &object) == nil {
+			switch {
+			case object.Text != "":
+				texts = append(texts, object.Text)
+			case object.ContentType == "image_asset_pointer":
+				texts = append(texts, "[image]")
+			}
+		}
+	}
+	if len(texts) > 0 {
+		return strings.Join(texts, "\n")
+	}
+	if message.Content.Text != "" {
+		return message.Content.Text
+	}
+	return message.Content.Result
+}
+
+// claudeExport reads the conversations.json of a claude.ai data export.
+// Messages are listed oldest first; newer exports link each to its parent,
+// so edited prompts and retried answers branch, while older ones form a
+// single thread.
+type claudeExport struct{}
+
+type claudeConversation struct {
+	UUID         string          `json:"uuid"`
+	Name         string          `json:"name"`
+	CreatedAt    string          `json:"created_at"`
+	ChatMessages []claudeMessage `json:"chat_messages"`
+}
+
+type claudeMessage struct {
+	UUID       string `json:"uuid"`
+	ParentUUID string `json:"parent_message_uuid"`
+	Sender     string `json:"sender"`
+	Text       string `json:"text"`
+	Content    []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	CreatedAt   string `json:"created_at"`
+	Attachments []struct {
+		FileName string `json:"file_name"`
+	} `json:"attachments"`
+	Files []struct {
+		FileName string `json:"file_name"`
+	} `json:"files"`
+}
+
+func (claudeExport) Name() string { return "claude" }
+
+func (claudeExport) Detect(first map[string]json.RawMessage) bool {
+	_, ok := first["chat_messages"]
+	return ok
+}
+
+func (claudeExport) Convert(data []byte) (map[string]*Folder, error) {
+	var conversations []claudeConversation
+	if err := json.Unmarshal(data, &conversations); err != nil {
+		return nil, fmt.Errorf("failed to parse claude.ai export: %w", err)
+	}
+
+	folders := make(map[string]*Folder, len(conversations))
+	for _, conversation := range conversations {
+		if conversation.UUID == "" {
+			continue
+		}
+		created, ok := parseHistoryTime(conversation.CreatedAt)
+		if !ok {
+			created = time.Now()
+		}
+
+		nodes := claudeNodes(conversation, created)
+		if len(nodes) == 0 {
+			continue
+		}
+		folderID := "claude_" + conversation.UUID
+		folders[folderID] = conversationFolder(folderID, conversationTitle("Claude", conversation.Name, nodes), "#d97757", created, nodes)
+	}
+	return folders, nil
+}
+
+func claudeNodes(conversation claudeConversation, created time.Time) []*MessageNode {
+	// Messages with nothing to show are skipped; resolved maps each one to
+	// the node its replies hang from instead.
+	resolved := make(map[string]string, len(conversation.ChatMessages))
+
+	var nodes []*MessageNode
+	previous := ""
+	last := created
+	for _, message := range conversation.ChatMessages {
+		if message.UUID == "" {
+			continue
+		}
+		// Exports from before branching was recorded have no parents.
+		parentID := previous
+		if message.ParentUUID != "" {
+			parentID = resolved[message.ParentUUID]
+		}
+
+		content := claudeContent(message)
+		if content == "" {
+			resolved[message.UUID] = parentID
+			continue
+		}
+		when, ok := parseHistoryTime(message.CreatedAt)
+		if ok {
+			last = when
+		} else {
+			when = last
+		}
+
+		role := "assistant"
+		if message.Sender == "human" {
+			role = "user"
+		}
+		node := exportNode("claude_"+message.UUID, "claude", role, content, conversation.UUID, when, !ok)
+		node.ParentID = parentID
+		nodes = append(nodes, node)
+		resolved[message.UUID] = node.ID
+		previous = node.ID
+	}
+	return nodes
+}
+
+// claudeContent reads a message's text blocks, falling back to its text,
+// and lists the files attached to it the way prompt history entries do.
+func claudeContent(message claudeMessage) string {
+	var texts []string
+	for _, block := range message.Content {
+		if block.Type == "text" && strings.TrimSpace(block.Text) != "" {
+			texts = append(texts, block.Text)
+		}
+	}
+	content := strings.Join(texts, "\n")
+	if content == "" {
+		content = message.Text
+	}
+
+	var files []string
+	for _, attachment := range message.Attachments {
+		if attachment.FileName != "" {
+			files = append(files, attachment.FileName)
+		}
+	}
+	for _, file := range message.Files {
+		if file.FileName != "" {
+			files = append(files, file.FileName)
+		}
+	}
+	if len(files) > 0 {
+		content += "\n\nAttachments:\n"
+		for _, file := range files {
+			content += "- " + file + "\n"
+		}
+	}
+	return content
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func readTestExport(t *testing.T, path string) (map[string]*Folder, string) {
+	t.Helper()
+	folders, format, err := readImportPath(path)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return folders, format
+}
+
+// sameTime compares RFC 3339 times, which unix times are formatted as in
+// the local zone.
+func sameTime(a, b string) bool {
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	return errA == nil && errB == nil && ta.Equal(tb)
+}
+
+func TestChatGPTExport(t *testing.T) {
+	folders, format := readTestExport(t, "testdata/exports/chatgpt")
+	if format != "chatgpt" || len(folders) != 2 {
+		t.Fatalf("expected 2 chatgpt conversations, got %d %s", len(folders), format)
+	}
+
+	folder := folders["chatgpt_68dcf0a0-1111"]
+	if folder == nil || folder.Name != "ChatGPT - Parser bug" || !sameTime(folder.CreatedAt, "2025-10-01T09:00:00Z") {
+		t.Fatalf("unexpected folder %+v", folder)
+	}
+	if len(folder.Nodes) != 4 {
+		t.Fatalf("expected the system prompt and empty root to be skipped, got %d messages", len(folder.Nodes))
+	}
+
+	prompt := folder.Nodes["chatgpt_u1"]
+	if prompt.Type != "user" || prompt.ParentID != "" || prompt.Content != "[image]\nWhy does this parser test fail?" || prompt.SessionID != "68dcf0a0-1111" {
+		t.Errorf("unexpected prompt %+v", prompt)
+	}
+	if !reflect.DeepEqual(prompt.Children, []string{"chatgpt_a1", "chatgpt_a2"}) {
+		t.Errorf("expected both answers under the prompt, got %v", prompt.Children)
+	}
+	code := folder.Nodes["chatgpt_a2"]
+	if code.Type != "response" || code.ParentID != "chatgpt_u1" || code.Content != "print(tokens[:-1])" || !sameTime(code.Timestamp, "2025-10-01T09:01:40Z") {
+		t.Errorf("unexpected regenerated answer %+v", code)
+	}
+	output := folder.Nodes["chatgpt_t1"]
+	if output.ParentID != "chatgpt_a2" || output.Content != "['a', 'b']" || output.Timestamp != code.Timestamp || !output.TimestampEstimated {
+		t.Errorf("unexpected tool output %+v", output)
+	}
+
+	if untitled := folders["chatgpt_68dcf0a0-2222"]; untitled == nil || untitled.Name != "ChatGPT - Name a colour" {
+		t.Errorf("expected an untitled conversation to be named after its prompt, got %+v", untitled)
+	}
+}
+
+func TestClaudeExport(t *testing.T) {
+	folders, format := readTestExport(t, "testdata/exports/claude/conversations.json")
+	if format != "claude" || len(folders) != 2 {
+		t.Fatalf("expected 2 claude conversations, got %d %s", len(folders), format)
+	}
+
+	folder := folders["claude_c0ffee00-1111"]
+	if folder == nil || folder.Name != "Claude - Release checklist" || len(folder.Nodes) != 4 {
+		t.Fatalf("unexpected folder %+v", folder)
+	}
+	prompt := folder.Nodes["claude_m1"]
+	if prompt.Type != "user" || prompt.ParentID != "" || prompt.Content != "Draft a release checklist\n\nAttachments:\n- CHANGELOG.md\n" {
+		t.Errorf("unexpected prompt %+v", prompt)
+	}
+	if !reflect.DeepEqual(prompt.Children, []string{"claude_m2", "claude_m3"}) {
+		t.Errorf("expected the retried answers to be siblings, got %v", prompt.Children)
+	}
+	if m2 := folder.Nodes["claude_m2"]; m2.Content != "1. Tag the release" || m2.Tags[1] != "assistant" {
+		t.Errorf("unexpected answer %+v", m2)
+	}
+	if m5 := folder.Nodes["claude_m5"]; m5.ParentID != "claude_m3" || m5.SessionID != "c0ffee00-1111" {
+		t.Errorf("expected the reply to an empty message to move up, got %+v", m5)
+	}
+
+	legacy := folders["claude_c0ffee00-2222"]
+	if legacy == nil || legacy.Name != "Claude - Hello" || legacy.Nodes["claude_o2"].ParentID != "claude_o1" {
+		t.Errorf("expected an unlinked export to form a thread, got %+v", legacy)
+	}
+}
+
+func TestReadImport(t *testing.T) {
+	conversations, err := os.ReadFile("testdata/exports/claude/conversations.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, data := range map[string][]byte{
+		"data-2025-10-05/users.json":         []byte(`[]`),
+		"data-2025-10-05/conversations.json": conversations,
+	} {
+		file, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write(data)
+	}
+	archive.Close()
+
+	folders, format, err := readImport(buf.Bytes())
+	if err != nil || format != "claude" || len(folders) != 2 {
+		t.Errorf("expected the zipped export to be read, got %d %s (%v)", len(folders), format, err)
+	}
+
+	folders, format, err = readImport([]byte(`{"mine":{"name":"Mine","nodes":{"a":{"type":"user","content":"hi"}}}}`))
+	if err != nil || format != "oc-message-explorer" || folders["mine"].Nodes["a"].Content != "hi" {
+		t.Errorf("expected the app's own export to be read, got %+v %s (%v)", folders, format, err)
+	}
+
+	for _, data := range []string{`[{"messages":[]}]`, `[]`, `not json`} {
+		if _, _, err := readImport([]byte(data)); err == nil {
+			t.Errorf("expected %s to be rejected", data)
+		}
+	}
+	if _, _, err := readImportPath(t.TempDir()); err == nil {
+		t.Error("expected a directory without conversations.json to be rejected")
+	}
+
+	// ?path= reads only from within IMPORT_DIR.
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.Mkdir(filepath.Join(root, "export"), 0755)
+	os.Symlink(outside, filepath.Join(root, "link"))
+	if _, err := resolveImportPath("", filepath.Join(root, "export")); err == nil {
+		t.Error("expected paths to be refused without IMPORT_DIR")
+	}
+	if path, err := resolveImportPath(root, "export"); err != nil || filepath.Base(path) != "export" {
+		t.Errorf("expected a path within IMPORT_DIR to be read, got %s (%v)", path, err)
+	}
+	for _, path := range []string{outside, filepath.Join(root, ".."), "../" + filepath.Base(outside), filepath.Join(root, "link")} {
+		if _, err := resolveImportPath(root, path); err == nil {
+			t.Errorf("expected %s to be refused", path)
+		}
+	}
+
+	// Imported conversations keep their trees once stored.
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	folders, _ = readTestExport(t, "testdata/exports/chatgpt")
+	if err := store.ImportFolders(folders); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := store.db.GetNodesForFolder("chatgpt_68dcf0a0-1111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 4 || nodes["chatgpt_t1"].ParentID != "chatgpt_a2" || len(nodes["chatgpt_u1"].Children) != 2 {
+		t.Errorf("unexpected stored conversation %+v", nodes)
+	}
+}
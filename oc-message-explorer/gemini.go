@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// geminiImporter reads Gemini CLI session logs, usually found under
+// ~/.gemini/tmp/<project>/chats/session-*.json. A log is either one
+// {"sessionId", "messages": [...]} object or JSON lines, each a message,
+// optionally after a {"sessionId", "startTime"} header line. Messages are
+// {"id", "timestamp", "type": "user" | "gemini", "content", "thoughts",
+// "toolCalls"}.
+type geminiImporter struct{}
+
+type geminiMessage struct {
+	SessionID string          `json:"sessionId"`
+	Timestamp string          `json:"timestamp"`
+	Type      string          `json:"type"`
+	Content   json.RawMessage `json:"content"`
+	Thoughts  []struct {
+		Subject     string `json:"subject"`
+		Description string `json:"description"`
+	} `json:"thoughts"`
+	ToolCalls []struct {
+		ID            string          `json:"id"`
+		Name          string          `json:"name"`
+		Args          json.RawMessage `json:"args"`
+		Result        json.RawMessage `json:"result"`
+		ResultDisplay json.RawMessage `json:"resultDisplay"`
+		Status        string          `json:"status"`
+		Timestamp     string          `json:"timestamp"`
+	} `json:"toolCalls"`
+}
+
+type geminiSession struct {
+	SessionID   string          `json:"sessionId"`
+	ProjectHash string          `json:"projectHash"`
+	StartTime   string          `json:"startTime"`
+	Messages    []geminiMessage `json:"messages"`
+}
+
+func (geminiImporter) Name() string { return "gemini" }
+
+func (geminiImporter) Match(path string) bool {
+	ext := filepath.Ext(path)
+	return (ext == ".json" || ext == ".jsonl") && strings.HasPrefix(filepath.Base(path), "session-")
+}
+
+func (geminiImporter) Detect(path string, head []byte) bool {
+	raw, ok := firstJSONObject(head)
+	if !ok {
+		// A whole-file session may be larger than head; go by its start.
+		trimmed := bytes.TrimSpace(head)
+		return bytes.HasPrefix(trimmed, []byte("{")) && bytes.Contains(trimmed, []byte(`"sessionId"`)) &&
+			bytes.Contains(trimmed, []byte(`"messages"`))
+	}
+	if _, ok := raw["sessionId"].(string); ok {
+		return true
+	}
+	kind, _ := raw["type"].(string)
+	_, hasContent := raw["content"]
+	return (kind == "user" || kind == "gemini") && hasContent
+}
+
+func (geminiImporter) Parse(r io.Reader, emit func(HistoryEntry)) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	var session geminiSession
+	if err := json.Unmarshal(data, &session); err == nil && session.Messages != nil {
+		for _, message := range session.Messages {
+			emitGeminiMessage(session.SessionID, message, emit)
+		}
+		return nil
+	}
+
+	sessionID := ""
+	return scanLines(bytes.NewReader(data), func(line string) {
+		var message geminiMessage
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			return
+		}
+		if message.Type == "" {
+			// A header line.
+			if message.SessionID != "" {
+				sessionID = message.SessionID
+			}
+			return
+		}
+		if message.SessionID == "" {
+			message.SessionID = sessionID
+		}
+		emitGeminiMessage(message.SessionID, message, emit)
+	})
+}
+
+func (geminiImporter) Node(source HistorySource, entry HistoryEntry) *MessageNode {
+	return sessionNode(source, entry)
+}
+
+func emitGeminiMessage(sessionID string, message geminiMessage, emit func(HistoryEntry)) {
+	if message.SessionID != "" {
+		sessionID = message.SessionID
+	}
+	when, _ := parseHistoryTime(message.Timestamp)
+	entry := HistoryEntry{SessionID: sessionID, Time: when}
+
+	switch message.Type {
+	case "user":
+		entry.Role = "user"
+		entry.Input = geminiText(message.Content)
+		if entry.Input == "" {
+			return
+		}
+	case "gemini":
+		entry.Role = "assistant"
+		for _, thought := range message.Thoughts {
+			text := strings.TrimSpace(thought.Subject + "\n" + thought.Description)
+			if text != "" {
+				entry.Parts = append(entry.Parts, &MessagePart{Type: "reasoning", Text: text})
+			}
+		}
+		for _, call := range message.ToolCalls {
+			part := &MessagePart{
+				Type:   "tool",
+				Tool:   call.Name,
+				CallID: call.ID,
+				Input:  compactJSON(call.Args),
+				Output: geminiToolOutput(call.Result, call.ResultDisplay),
+				Status: "completed",
+			}
+			if call.Status != "" && call.Status != "success" {
+				part.Status = "error"
+				part.Error, part.Output = part.Output, ""
+			}
+			if t, ok := parseHistoryTime(call.Timestamp); ok {
+				part.StartedAt = t.Format(time.RFC3339)
+			}
+			entry.Parts = append(entry.Parts, part)
+		}
+		if text := geminiText(message.Content); text != "" {
+			entry.Parts = append(entry.Parts, &MessagePart{Type: "text", Text: text})
+		}
+		if len(entry.Parts) == 0 {
+			return
+		}
+	default:
+		// info, warning and error messages come from the CLI itself.
+		return
+	}
+	emit(entry)
+}
+
+// geminiText reads message content, a string in most versions and a list
+// of {"text"} parts in others.
+func geminiText(content json.RawMessage) string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text
+	}
+	var parts []struct {
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(content, &parts) != nil {
+		return ""
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// geminiToolOutput prefers the output the model saw, found in
+// result[].functionResponse.response.output, over what the CLI displayed.
+func geminiToolOutput(result, display json.RawMessage) string {
+	var responses []struct {
+		FunctionResponse struct {
+			Response map[string]interface{} `json:"response"`
+		} `json:"functionResponse"`
+	}
+	if json.Unmarshal(result, &responses) == nil {
+		var outputs []string
+		for _, response := range responses {
+			switch output := response.FunctionResponse.Response["output"].(type) {
+			case string:
+				outputs = append(outputs, output)
+			case nil:
+			default:
+				outputs = append(outputs, fmt.Sprint(output))
+			}
+		}
+		if len(outputs) > 0 {
+			return strings.Join(outputs, "\n")
+		}
+	}
+
+	var text string
+	if json.Unmarshal(display, &text) == nil {
+		return text
+	}
+	return compactJSON(display)
+}
+
+func compactJSON(data json.RawMessage) string {
+	if len(data) == 0 || string(data) == "null" {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return string(data)
+	}
+	return buf.String()
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type hydrationRequest struct {
+	messageID string
+	done      chan error
+}
+
+// partsSignature summarises a message's part directory (file count, total
+// size and newest modification time) so changed parts can be detected
+// without reading every file again.
+func partsSignature(partPath, messageID string) (string, error) {
+	entries, err := os.ReadDir(filepath.Join(partPath, messageID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "missing", nil
+		}
+		return "", err
+	}
+
+	count := 0
+	var size, newest int64
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		count++
+		size += info.Size()
+		if mtime := info.ModTime().UnixNano(); mtime > newest {
+			newest = mtime
+		}
+	}
+
+	return fmt.Sprintf("%d:%d:%d", count, size, newest), nil
+}
+
+// hydrateMessage reads a message's parts from disk and persists them along
+// with the derived text content. It returns the content and the boundary
+// the parts mark so callers can update the in-memory copy of the node.
+func hydrateMessage(db *Database, partPath, messageID string) (string, string, error) {
+	signature, err := partsSignature(partPath, messageID)
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := []*MessagePart{}
+	if signature != "missing" {
+		parts, err = readMessageParts(partPath, messageID)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	content := partsContent(parts)
+	if db != nil {
+		if err := db.StoreHydration(messageID, parts, content, signature); err != nil {
+			return "", "", err
+		}
+	}
+
+	return content, partsBoundary(parts), nil
+}
+
+func (sm *SyncManager) hydrate(messageID string) error {
+	content, boundary, err := hydrateMessage(sm.db, sm.partPath, messageID)
+	if err != nil {
+		return err
+	}
+	if sm.store != nil {
+		sm.store.applyHydration(messageID, content, boundary)
+	}
+	return nil
+}
+
+// HydrateNow loads a single message immediately. While the hydration
+// queue is being worked through the request is handed to that loop so it
+// is served ahead of the rest instead of racing it.
+func (sm *SyncManager) HydrateNow(messageID string) error {
+	sm.mu.RLock()
+	if sm.hydrating {
+		req := hydrationRequest{messageID: messageID, done: make(chan error, 1)}
+		select {
+		case sm.priorityChan <- req:
+			sm.mu.RUnlock()
+			return <-req.done
+		default:
+		}
+	}
+	sm.mu.RUnlock()
+
+	return sm.hydrate(messageID)
+}
+
+// queueHydration adds messages to the hydration queue and starts the loop
+// that works through it unless one is running. Hydration runs apart from
+// the sync, so a sync can start while content is still loading. It
+// reports whether anything was queued.
+func (sm *SyncManager) queueHydration(ids []string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	queued := false
+	for _, id := range ids {
+		if !sm.queued[id] {
+			sm.queued[id] = true
+			sm.hydrationQueue = append(sm.hydrationQueue, id)
+			queued = true
+		}
+	}
+	if queued {
+		sm.hydrationCancelled = false
+		if !sm.hydrating {
+			sm.hydrating = true
+			sm.hydrationLoops.Add(1)
+			go sm.drainHydration()
+		}
+	}
+	return queued
+}
+
+// hydrateSynced queues the messages whose content was never loaded,
+// newest first, then those of the sessions a sync found changed whose
+// part directories differ from when they were last loaded. Sessions the
+// sync skipped are not looked at, since OpenCode rewrites a message's file
+// as its parts are written.
+func (sm *SyncManager) hydrateSynced(touched []string) bool {
+	ids, err := sm.db.UnloadedNodeIDs("openchat")
+	if err != nil {
+		log.Printf("Failed to list messages to load: %v", err)
+	}
+	signatures, err := sm.db.HydrationSignatures(touched)
+	if err != nil {
+		log.Printf("Failed to read part signatures, reloading every changed session: %v", err)
+	}
+	for _, id := range touched {
+		if signature, err := partsSignature(sm.partPath, id); err != nil || signature != signatures[id] {
+			ids = append(ids, id)
+		}
+	}
+	return sm.queueHydration(ids)
+}
+
+// nextHydration takes the next message off the queue along with how many
+// are left. Once it is empty the loop is done and hydrating is cleared,
+// under the same lock HydrateNow checks it with.
+func (sm *SyncManager) nextHydration() (string, int, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if len(sm.hydrationQueue) == 0 {
+		sm.hydrating = false
+		return "", 0, false
+	}
+	id := sm.hydrationQueue[0]
+	sm.hydrationQueue = sm.hydrationQueue[1:]
+	delete(sm.queued, id)
+	return id, len(sm.hydrationQueue), true
+}
+
+// drainHydration reads parts for queued messages until the queue is
+// empty, serving HydrateNow requests first. Estimated history times are
+// resolved afterwards, since they are matched on message text.
+func (sm *SyncManager) drainHydration() {
+	defer sm.hydrationLoops.Done()
+	defer func() {
+		for {
+			select {
+			case req := <-sm.priorityChan:
+				req.done <- sm.hydrate(req.messageID)
+			default:
+				return
+			}
+		}
+	}()
+
+	sm.reportProgress(SyncProgress{Phase: "hydrating", Message: "Loading message content..."})
+
+	hydrated, checked := 0, 0
+	for {
+		select {
+		case req := <-sm.priorityChan:
+			req.done <- sm.hydrate(req.messageID)
+			continue
+		default:
+		}
+
+		id, left, ok := sm.nextHydration()
+		if !ok {
+			break
+		}
+		checked++
+
+		if err := sm.hydrate(id); err != nil {
+			log.Printf("Failed to hydrate message %s: %v", id, err)
+			continue
+		}
+
+		hydrated++
+		if hydrated%100 == 0 || hydrated == 1 {
+			sm.reportProgress(SyncProgress{
+				Phase:         "hydrating",
+				Message:       fmt.Sprintf("Loaded content for %d messages (%d left)...", hydrated, left),
+				Processed:     checked,
+				TotalMessages: checked + left,
+			})
+		}
+	}
+
+	sm.mu.Lock()
+	cancelled := sm.hydrationCancelled
+	sm.mu.Unlock()
+	if cancelled {
+		sm.reportProgress(SyncProgress{Phase: "cancelled", Message: "Content loading cancelled by user"})
+		return
+	}
+
+	sm.reportProgress(SyncProgress{
+		Phase:         "hydrated",
+		Message:       fmt.Sprintf("Content loaded for %d messages", hydrated),
+		Processed:     checked,
+		TotalMessages: checked,
+	})
+	sm.resolveHistoryTimes()
+}
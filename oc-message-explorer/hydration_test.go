@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// syncAndHydrate runs a sync and waits for the content it queued to load.
+func syncAndHydrate(sm *SyncManager) {
+	sm.performSync()
+	sm.hydrationLoops.Wait()
+}
+
+func TestHydrationKeepsLockedContent(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	sm := NewSyncManager(store.db, store, t.TempDir(), nil)
+	sm.historySources = nil
+	store.syncManager = sm
+
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(sm.msgPath, "ses_l", "msg_1.json"), `{"id":"msg_1","sessionID":"ses_l","role":"user","time":{"created":1759309200000}}`)
+	write(filepath.Join(sm.partPath, "msg_1", "prt_1.json"), `{"id":"prt_1","type":"text","text":"Draft prompt"}`)
+	syncAndHydrate(sm)
+	if err := sm.HydrateNow("msg_1"); err != nil {
+		t.Fatal(err)
+	}
+
+	edited := *store.loadMessageContent("msg_1")
+	edited.Content = "Curated prompt"
+	if err := store.UpdateNode(&edited); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetNodeLocked("msg_1", true); err != nil {
+		t.Fatal(err)
+	}
+	// A message locked before its content was ever loaded still gets it.
+	if err := store.db.InsertNode("openchat", &MessageNode{ID: "msg_2", Type: "prompt", SessionID: "ses_l", Timestamp: "2025-10-01T09:00:00Z", Locked: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"msg_1", "msg_2"} {
+		write(filepath.Join(sm.partPath, id, "prt_"+id+".json"), `{"id":"prt_`+id+`","type":"text","text":"Rewritten upstream"}`)
+		if err := sm.HydrateNow(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for id, want := range map[string]string{"msg_1": "Curated prompt", "msg_2": "Rewritten upstream"} {
+		stored, err := store.db.GetNode(id)
+		if err != nil || stored == nil {
+			t.Fatalf("expected %s in the database: %v", id, err)
+		}
+		if stored.Content != want || !stored.HasLoaded {
+			t.Errorf("%s: got %q (loaded %v) in the database, want %q", id, stored.Content, stored.HasLoaded, want)
+		}
+	}
+	if node := store.Folders["openchat"].Nodes["msg_1"]; node.Content != "Curated prompt" {
+		t.Errorf("got %q in memory, want the curated prompt kept", node.Content)
+	}
+
+	// The parts are still refreshed, and searchable content follows the
+	// kept text.
+	parts, err := store.db.GetParts("msg_1")
+	if err != nil || len(parts) != 2 {
+		t.Errorf("expected both parts of msg_1, got %d (%v)", len(parts), err)
+	}
+	response, err := store.db.SearchNodes(SearchRequest{Query: "curated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 1 || response.Results[0].Node.ID != "msg_1" {
+		t.Errorf("expected the kept content to stay searchable, got %+v", response.Results)
+	}
+}
+
+func TestHydrationRunsAfterSync(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+
+	var mu sync.Mutex
+	var phases []string
+	release := make(chan struct{})
+	sm := NewSyncManager(store.db, store, t.TempDir(), func(progress SyncProgress) {
+		mu.Lock()
+		first := progress.Phase == "hydrating" && !slices.Contains(phases, "hydrating")
+		phases = append(phases, progress.Phase)
+		mu.Unlock()
+		if first {
+			<-release
+		}
+	})
+	sm.historySources = nil
+
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	message := func(id string, created int) {
+		write(filepath.Join(sm.msgPath, "ses_h", id+".json"), fmt.Sprintf(`{"id":%q,"sessionID":"ses_h","role":"user","time":{"created":%d}}`, id, created))
+		write(filepath.Join(sm.partPath, id, "prt_"+id+".json"), fmt.Sprintf(`{"id":"prt_%s","type":"text","text":"Prompt %s"}`, id, id))
+	}
+	message("msg_1", 1759309200000)
+	message("msg_2", 1759309300000)
+
+	// The sync is done while its content is still loading, so another
+	// can run, and what it writes joins the queue.
+	sm.performSync()
+	if sm.IsRunning() {
+		t.Fatal("expected the sync to be done while content loads")
+	}
+	message("msg_3", 1759309400000)
+	sm.performSync()
+	sm.mu.RLock()
+	queue := append([]string(nil), sm.hydrationQueue...)
+	sm.mu.RUnlock()
+	if want := []string{"msg_2", "msg_1", "msg_3"}; !slices.Equal(queue, want) {
+		t.Errorf("got queue %v, want %v", queue, want)
+	}
+
+	// A message opened meanwhile is loaded ahead of the queue.
+	opened := make(chan error, 1)
+	go func() { opened <- sm.HydrateNow("msg_3") }()
+	close(release)
+	if err := <-opened; err != nil {
+		t.Fatal(err)
+	}
+	sm.hydrationLoops.Wait()
+
+	for _, id := range []string{"msg_1", "msg_2", "msg_3"} {
+		node, err := store.db.GetNode(id)
+		if err != nil || node == nil || node.Content != "Prompt "+id {
+			t.Errorf("expected %s to be loaded, got %+v (%v)", id, node, err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if i := slices.Index(phases, "hydrated"); i < slices.Index(phases, "complete") || slices.Contains(phases[i+1:], "hydrated") {
+		t.Errorf("expected content to load once, after the syncs, got phases %v", phases)
+	}
+}
+
+func TestHydrationChecksChangedSessions(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+
+	var mu sync.Mutex
+	loaded := -1
+	sm := NewSyncManager(store.db, store, t.TempDir(), func(progress SyncProgress) {
+		if progress.Phase == "hydrated" {
+			mu.Lock()
+			loaded = progress.Processed
+			mu.Unlock()
+		}
+	})
+	sm.historySources = nil
+	lastLoaded := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		n := loaded
+		loaded = -1
+		return n
+	}
+
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	message := func(session, id, agent string) {
+		write(filepath.Join(sm.msgPath, session, id+".json"), fmt.Sprintf(`{"id":%q,"sessionID":%q,"role":"user","agent":%q,"time":{"created":1759309200000}}`, id, session, agent))
+	}
+	part := func(id, partID, text string) {
+		write(filepath.Join(sm.partPath, id, partID+".json"), fmt.Sprintf(`{"id":%q,"type":"text","text":%q}`, partID, text))
+	}
+	for _, m := range []struct{ session, id string }{{"ses_a", "msg_1"}, {"ses_a", "msg_2"}, {"ses_b", "msg_3"}} {
+		message(m.session, m.id, "build")
+		part(m.id, "prt_"+m.id, "Prompt "+m.id)
+	}
+	syncAndHydrate(sm)
+	if n := lastLoaded(); n != 3 {
+		t.Fatalf("expected every message to load on the first sync, got %d", n)
+	}
+
+	// Nothing changed, so nothing is read.
+	syncAndHydrate(sm)
+	if n := lastLoaded(); n != -1 {
+		t.Errorf("expected no content to load when nothing changed, got %d", n)
+	}
+
+	// msg_1 got a part and msg_2 a new file with the same parts, in the
+	// same session; msg_3's parts changed in a session left as it was.
+	part("msg_1", "prt_msg_1b", "Follow-up")
+	message("ses_a", "msg_2", "plan")
+	part("msg_3", "prt_msg_3b", "Unseen")
+	syncAndHydrate(sm)
+	if n := lastLoaded(); n != 1 {
+		t.Errorf("expected only msg_1 to load, got %d", n)
+	}
+	for id, want := range map[string]string{"msg_1": "Prompt msg_1\nFollow-up", "msg_2": "Prompt msg_2", "msg_3": "Prompt msg_3"} {
+		if node, err := store.db.GetNode(id); err != nil || node == nil || node.Content != want {
+			t.Errorf("%s: got %+v (%v), want content %q", id, node, err, want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Importer reads one history file format. Importers are listed in
+// importers and chosen by a source's format, or by detection.
+type Importer interface {
+	// Name is the format name used in config.json and /api/sources.
+	Name() string
+	// Detect reports whether a file is in this format, given its path and
+	// the first detectHeadSize bytes.
+	Detect(path string, head []byte) bool
+	// Parse reads a history file's entries in file order.
+	Parse(r io.Reader, emit func(HistoryEntry)) error
+	// Node maps an entry to a message node. The caller assigns its ID, and
+	// its timestamp when the entry has no time.
+	Node(source HistorySource, entry HistoryEntry) *MessageNode
+}
+
+// HistoryEntry is one prompt read from a history file, or one message
+// read from a session log.
+type HistoryEntry struct {
+	Input       string
+	Attachments []string  // file names; non-nil when the entry had parts, even if none were files
+	Mode        string    // how the prompt was sent, e.g. "normal" or "shell"
+	Time        time.Time // zero when the entry does not record one
+
+	// Set by session log importers.
+	SessionID string
+	Directory string         // the session's working directory, if logged
+	Role      string         // "user" or "assistant"
+	Parts     []*MessagePart // an assistant message's text, reasoning and tool calls
+}
+
+// importers is the registry, in detection order. The last one accepts any
+// file, so detection always finds an importer.
+var importers = []Importer{
+	openCodeImporter{},
+	ampImporter{},
+	codexImporter{},
+	geminiImporter{},
+	linesImporter{},
+}
+
+// detectHeadSize is how much of a file Detect is shown.
+const detectHeadSize = 64 * 1024
+
+// maxHistoryLine bounds one line of a line-based history file.
+const maxHistoryLine = 16 * 1024 * 1024
+
+func importerByName(name string) (Importer, bool) {
+	for _, importer := range importers {
+		if importer.Name() == name {
+			return importer, true
+		}
+	}
+	return nil, false
+}
+
+// detectImporter returns the first importer that recognises the file. A
+// directory is taken to hold session logs and is recognised by the first
+// one it contains.
+func detectImporter(path string) (Importer, error) {
+	if isDir(path) {
+		return detectSessionDir(path)
+	}
+
+	head, err := readHead(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, importer := range importers {
+		if importer.Detect(path, head) {
+			return importer, nil
+		}
+	}
+	return nil, fmt.Errorf("no importer recognises %s", path)
+}
+
+// readHead returns the first detectHeadSize bytes of a file.
+func readHead(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head := make([]byte, detectHeadSize)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// sourceImporter returns the importer for a source's configured format,
+// detecting it when none is set.
+func sourceImporter(source HistorySource) (Importer, error) {
+	if source.Format == "" || source.Format == "auto" {
+		return detectImporter(source.Path)
+	}
+	importer, ok := importerByName(source.Format)
+	if !ok {
+		return nil, fmt.Errorf("unknown history format %q", source.Format)
+	}
+	return importer, nil
+}
+
+// promptNode is how every prompt history importer maps an entry. The
+// content it builds is what the entry's ID is derived from, so it must
+// not change.
+func promptNode(source HistorySource, entry HistoryEntry) *MessageNode {
+	summary := entry.Input
+	if len(summary) > 100 {
+		summary = summary[:97] + "..."
+	}
+
+	content := entry.Input
+	if entry.Attachments != nil {
+		content += "\n\nAttachments:\n"
+		for _, filename := range entry.Attachments {
+			content += fmt.Sprintf("- %s\n", filename)
+		}
+	}
+
+	tags := []string{source.FolderID}
+	if entry.Mode != "" {
+		tags = append(tags, entry.Mode)
+	}
+
+	return &MessageNode{
+		Type:      "prompt",
+		Content:   content,
+		Summary:   summary,
+		Tags:      tags,
+		HasLoaded: true,
+	}
+}
+
+// firstJSONObject decodes the first value in head if it is a JSON object.
+func firstJSONObject(head []byte) (map[string]interface{}, bool) {
+	var raw map[string]interface{}
+	if err := json.NewDecoder(bytes.NewReader(head)).Decode(&raw); err != nil {
+		return nil, false
+	}
+	return raw, true
+}
+
+// scanLines calls fn with every non-blank line of r, trimmed.
+func scanLines(r io.Reader, fn func(line string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxHistoryLine)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			fn(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading history: %w", err)
+	}
+	return nil
+}
+
+// scanJSONLines calls fn with every line of r that is a JSON object,
+// logging and skipping the rest.
+func scanJSONLines(r io.Reader, fn func(raw map[string]interface{})) error {
+	return scanLines(r, func(line string) {
+		var raw map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+			log.Printf("Failed to parse history line: %v", err)
+			return
+		}
+		fn(raw)
+	})
+}
+
+// openCodeImporter reads OpenCode's prompt-history.jsonl, one
+// {"input", "parts", "mode"} object per line.
+type openCodeImporter struct{}
+
+func (openCodeImporter) Name() string { return "opencode" }
+
+func (openCodeImporter) Detect(path string, head []byte) bool {
+	raw, ok := firstJSONObject(head)
+	if !ok {
+		return false
+	}
+	_, ok = raw["input"].(string)
+	return ok
+}
+
+func (openCodeImporter) Parse(r io.Reader, emit func(HistoryEntry)) error {
+	return scanJSONLines(r, func(raw map[string]interface{}) {
+		input, _ := raw["input"].(string)
+		if input == "" {
+			return
+		}
+
+		entry := HistoryEntry{Input: input}
+		entry.Mode, _ = raw["mode"].(string)
+		entry.Time, _ = historyEntryTime(raw)
+
+		parts, _ := raw["parts"].([]interface{})
+		if len(parts) > 0 {
+			entry.Attachments = []string{}
+		}
+		for _, p := range parts {
+			part, _ := p.(map[string]interface{})
+			if part["type"] != "file" {
+				continue
+			}
+			filename, _ := part["filename"].(string)
+			entry.Attachments = append(entry.Attachments, filename)
+		}
+
+		emit(entry)
+	})
+}
+
+func (openCodeImporter) Node(source HistorySource, entry HistoryEntry) *MessageNode {
+	return promptNode(source, entry)
+}
+
+// ampImporter reads AMP's history.jsonl, one {"text"} object per line.
+type ampImporter struct{}
+
+func (ampImporter) Name() string { return "amp" }
+
+func (ampImporter) Detect(path string, head []byte) bool {
+	raw, ok := firstJSONObject(head)
+	if !ok {
+		return false
+	}
+	_, ok = raw["text"].(string)
+	return ok
+}
+
+func (ampImporter) Parse(r io.Reader, emit func(HistoryEntry)) error {
+	return scanJSONLines(r, func(raw map[string]interface{}) {
+		text, _ := raw["text"].(string)
+		if text == "" {
+			return
+		}
+
+		entry := HistoryEntry{Input: text, Mode: "normal"}
+		if mode, ok := raw["mode"].(string); ok {
+			entry.Mode = mode
+		}
+		entry.Time, _ = historyEntryTime(raw)
+		emit(entry)
+	})
+}
+
+func (ampImporter) Node(source HistorySource, entry HistoryEntry) *MessageNode {
+	return promptNode(source, entry)
+}
+
+// linesImporter reads one prompt per line, either as plain text or as a
+// JSON string. It accepts any file.
+type linesImporter struct{}
+
+func (linesImporter) Name() string { return "lines" }
+
+func (linesImporter) Detect(path string, head []byte) bool { return true }
+
+func (linesImporter) Parse(r io.Reader, emit func(HistoryEntry)) error {
+	return scanLines(r, func(line string) {
+		var text string
+		if err := json.Unmarshal([]byte(line), &text); err != nil {
+			text = line
+		}
+		if text != "" {
+			emit(HistoryEntry{Input: text, Mode: "normal"})
+		}
+	})
+}
+
+func (linesImporter) Node(source HistorySource, entry HistoryEntry) *MessageNode {
+	return promptNode(source, entry)
+}
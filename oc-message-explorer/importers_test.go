@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectImporter(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"prompt-history.jsonl", `{"input":"fix the build","parts":[],"mode":"normal"}` + "\n", "opencode"},
+		{"history.jsonl", `{"text":"fix the build","cwd":"/src"}` + "\n", "amp"},
+		{"prompts.txt", "fix the build\nrun the tests\n", "lines"},
+		{"empty.jsonl", "", "lines"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		importer, err := detectImporter(path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if importer.Name() != tt.want {
+			t.Errorf("%s: detected %s, want %s", tt.name, importer.Name(), tt.want)
+		}
+	}
+}
+
+func TestReadPromptHistoryFormats(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		format  string
+		content string
+		want    map[string]string // content to summary
+	}{
+		{
+			"opencode",
+			`{"input":"explain this","parts":[{"type":"file","filename":"main.go"}],"mode":"normal"}
+{"input":"","mode":"normal"}
+{"input":"!ls","parts":[],"mode":"shell"}
+`,
+			map[string]string{
+				"explain this\n\nAttachments:\n- main.go\n": "explain this",
+				"!ls": "!ls",
+			},
+		},
+		{
+			"amp",
+			`{"text":"fix the build","cwd":"/src"}
+not json
+{"text":"run the tests"}
+`,
+			map[string]string{"fix the build": "fix the build", "run the tests": "run the tests"},
+		},
+		{
+			"lines",
+			"fix the build\n\n\"quoted \\\"prompt\\\"\"\n",
+			map[string]string{"fix the build": "fix the build", `quoted "prompt"`: `quoted "prompt"`},
+		},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.format)
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		source := HistorySource{Path: path, FolderID: "custom", Format: tt.format}
+		nodes, order, err := readPromptHistory(source, nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		if len(order) != len(tt.want) || len(nodes) != len(tt.want) {
+			t.Errorf("%s: read %d entries into %d nodes, want %d", tt.format, len(order), len(nodes), len(tt.want))
+		}
+		for content, summary := range tt.want {
+			node := nodes[historyNodeID("custom", content, 1)]
+			if node == nil {
+				t.Errorf("%s: no node for %q", tt.format, content)
+				continue
+			}
+			if node.Summary != summary || node.Tags[0] != "custom" || !node.TimestampEstimated {
+				t.Errorf("%s: unexpected node %+v", tt.format, node)
+			}
+		}
+	}
+
+	_, _, err := readPromptHistory(HistorySource{Path: filepath.Join(dir, "lines"), FolderID: "custom", Format: "nope"}, nil, nil)
+	if err == nil {
+		t.Error("expected an unknown format to be an error")
+	}
+}
+
+func TestMergeHistorySources(t *testing.T) {
+	defaults := defaultHistorySources(filepath.Join(t.TempDir(), "share", "opencode"))
+	sources := mergeHistorySources(defaults, []HistorySource{
+		{FolderID: "amp", Disabled: true},
+		{FolderID: "webui", Path: "/elsewhere/prompt-history.jsonl"},
+		{FolderID: "codex", Path: "~/.codex/history.jsonl", Format: "lines"},
+		{FolderID: "missing-path"},
+		{FolderID: "openchat", Path: "/tmp/history.jsonl"},
+	})
+
+	if len(sources) != 3 {
+		t.Fatalf("expected 3 sources, got %+v", sources)
+	}
+	if sources[0].Path != "/elsewhere/prompt-history.jsonl" || sources[0].Name != "Web UI History" || sources[0].Disabled {
+		t.Errorf("expected webui to be pointed elsewhere, got %+v", sources[0])
+	}
+	if !sources[1].Disabled || sources[1].Path != defaults[1].Path {
+		t.Errorf("expected amp to be disabled in place, got %+v", sources[1])
+	}
+	home, _ := os.UserHomeDir()
+	codex := sources[2]
+	if codex.Path != filepath.Join(home, ".codex", "history.jsonl") || codex.Name != "codex" || codex.Color != defaultSourceColor {
+		t.Errorf("expected codex to be added with defaults, got %+v", codex)
+	}
+}
+
+func TestSourceStatuses(t *testing.T) {
+	dataPath := filepath.Join(t.TempDir(), "share", "opencode")
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	store.dataPath = dataPath
+
+	custom := filepath.Join(t.TempDir(), "prompts.txt")
+	if err := os.WriteFile(custom, []byte("fix the build\nrun the tests\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := configManager
+	configManager = &ConfigManager{HistorySources: []HistorySource{
+		{FolderID: "amp", Disabled: true},
+		{FolderID: "notes", Name: "Notes", Path: custom},
+	}}
+	t.Cleanup(func() { configManager = previous })
+
+	sm := NewSyncManager(store.db, store, dataPath, nil)
+	if err := os.MkdirAll(sm.msgPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	syncAndHydrate(sm)
+
+	statuses, err := store.SourceStatuses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("expected 3 sources, got %+v", statuses)
+	}
+
+	webui, amp, notes := statuses[0], statuses[1], statuses[2]
+	if !webui.Builtin || !webui.Enabled || webui.Exists || webui.LastSyncAt == "" {
+		t.Errorf("unexpected webui status %+v", webui)
+	}
+	if amp.Enabled || amp.LastSyncAt != "" {
+		t.Errorf("expected amp to be disabled and never synced, got %+v", amp)
+	}
+	if notes.Builtin || !notes.Exists || notes.Importer != "lines" || notes.Messages != 2 || notes.LastError != "" {
+		t.Errorf("unexpected notes status %+v", notes)
+	}
+}
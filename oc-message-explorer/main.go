@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -15,6 +16,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -23,6 +25,8 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
+
+	apperrors "oc-message-explorer/internal/errors"
 )
 
 var upgrader = websocket.Upgrader{
@@ -40,6 +44,9 @@ const (
 	MessageTypeProgress MessageType = "progress"
 	MessageTypeUpdate   MessageType = "update"
 	MessageTypeError    MessageType = "error"
+	MessageTypeNodes    MessageType = "nodes"
+	MessageTypeSessions MessageType = "sessions"
+	MessageTypeProjects MessageType = "projects"
 )
 
 type WSMessage struct {
@@ -61,6 +68,68 @@ type MessageNode struct {
 	SessionID string   `json:"sessionId,omitempty"`
 	HasLoaded bool     `json:"hasLoaded"`
 	Locked    bool     `json:"locked"`
+	SortIndex int      `json:"sortIndex,omitempty"` // manual rank among siblings, 0 when unranked
+
+	// DeletedUpstreamAt is set when the file a node was synced from has
+	// disappeared from the OpenCode data dir.
+	DeletedUpstreamAt string `json:"deletedUpstreamAt,omitempty"`
+
+	// TimestampEstimated is set when a history entry had no time of its own
+	// and none could be inferred, so Timestamp is only a guess.
+	TimestampEstimated bool `json:"timestampEstimated,omitempty"`
+
+	// Boundary is set on the messages where a session was compacted:
+	// "compaction" on the message asking for it and "summary" on the
+	// summary that replaced the context before it.
+	Boundary string `json:"boundary,omitempty"`
+
+	MessageUsage
+}
+
+// MessageUsage is what OpenCode records about the model and agent behind
+// a message, and what an assistant message cost and how it ended.
+type MessageUsage struct {
+	ModelID     string        `json:"modelId,omitempty"`
+	ProviderID  string        `json:"providerId,omitempty"`
+	Agent       string        `json:"agent,omitempty"`
+	Tokens      *TokenUsage   `json:"tokens,omitempty"`
+	Cost        float64       `json:"cost,omitempty"`
+	CompletedAt string        `json:"completedAt,omitempty"`
+	LatencyMs   int64         `json:"latencyMs,omitempty"` // created to completed, 0 until completed
+	Error       *MessageError `json:"error,omitempty"`
+}
+
+// MessageError is how an assistant turn failed. Kind is one of "aborted",
+// "output-length", "auth", "api" or "unknown".
+type MessageError struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Message string `json:"message,omitempty"`
+}
+
+type TokenUsage struct {
+	Input      int64 `json:"input"`
+	Output     int64 `json:"output"`
+	Reasoning  int64 `json:"reasoning"`
+	CacheRead  int64 `json:"cacheRead"`
+	CacheWrite int64 `json:"cacheWrite"`
+}
+
+// tokenCounts returns the token counts, zero when none were recorded.
+func (u MessageUsage) tokenCounts() TokenUsage {
+	if u.Tokens == nil {
+		return TokenUsage{}
+	}
+	return *u.Tokens
+}
+
+// errorFields returns the error's kind, name and message, empty when the
+// turn did not fail.
+func (u MessageUsage) errorFields() (string, string, string) {
+	if u.Error == nil {
+		return "", "", ""
+	}
+	return u.Error.Kind, u.Error.Name, u.Error.Message
 }
 
 type Folder struct {
@@ -82,6 +151,7 @@ type Store struct {
 	promptHistoryPath string
 	db                *Database
 	syncManager       *SyncManager
+	sourceSyncs       map[string]sourceSync // by folder ID
 }
 
 type OpenCodeMessage struct {
@@ -90,17 +160,37 @@ type OpenCodeMessage struct {
 	Role      string `json:"role"`
 	ParentID  string `json:"parentId,omitempty"`
 	Time      struct {
-		Created int64 `json:"created"`
+		Created   int64 `json:"created"`
+		Completed int64 `json:"completed"`
 	}
 	Summary any    `json:"summary"`
 	Agent   string `json:"agent"`
-}
-
-type OpenCodePart struct {
-	ID        string `json:"id"`
-	MessageID string `json:"messageID"`
-	Type      string `json:"type"`
-	Text      string `json:"text"`
+	Mode    string `json:"mode"` // the agent, before OpenCode renamed it
+
+	// Assistant messages name their model at the top level, user messages
+	// the model they were sent to.
+	ModelID    string `json:"modelID"`
+	ProviderID string `json:"providerID"`
+	Model      struct {
+		ModelID    string `json:"modelID"`
+		ProviderID string `json:"providerID"`
+	} `json:"model"`
+	Error *struct {
+		Name string `json:"name"`
+		Data struct {
+			Message string `json:"message"`
+		} `json:"data"`
+	} `json:"error"`
+	Cost   float64 `json:"cost"`
+	Tokens struct {
+		Input     int64 `json:"input"`
+		Output    int64 `json:"output"`
+		Reasoning int64 `json:"reasoning"`
+		Cache     struct {
+			Read  int64 `json:"read"`
+			Write int64 `json:"write"`
+		} `json:"cache"`
+	} `json:"tokens"`
 }
 
 type TodoItem struct {
@@ -122,6 +212,7 @@ type EnvConfig struct {
 	AnthropicAPIKey    string `json:"anthropicAPIKey"`
 	AIProvider         string `json:"aiProvider"`
 	ThemeID            string `json:"themeId"`
+	ImportDir          string `json:"importDir"` // only read from the environment; see resolveImportPath
 }
 
 type ConfigManager struct {
@@ -130,6 +221,9 @@ type ConfigManager struct {
 	todos     []TodoItem
 	envPath   string
 	storePath string
+
+	// HistorySources are kept in config.json; see mergeHistorySources.
+	HistorySources []HistorySource `json:"historySources,omitempty"`
 }
 
 var configManager *ConfigManager
@@ -173,6 +267,7 @@ func (cm *ConfigManager) loadEnv() {
 	cm.config.AnthropicAPIKey = getEnvWithDefault("ANTHROPIC_API_KEY", "")
 	cm.config.AIProvider = getEnvWithDefault("AI_PROVIDER", "auto")
 	cm.config.ThemeID = getEnvWithDefault("THEME_ID", "github-dark")
+	cm.config.ImportDir = getEnvWithDefault("IMPORT_DIR", "")
 	cm.mu.Unlock()
 
 	log.Printf("[CONFIG] Loaded: OpenAI key=%t, Anthropic key=%t, Provider=%s, Model=%s",
@@ -278,6 +373,9 @@ func (cm *ConfigManager) saveEnvFile() error {
 	if cm.config.ThemeID != "" && cm.config.ThemeID != "github-dark" {
 		lines = append(lines, fmt.Sprintf(`THEME_ID=%s`, cm.config.ThemeID))
 	}
+	if cm.config.ImportDir != "" {
+		lines = append(lines, fmt.Sprintf(`IMPORT_DIR="%s"`, cm.config.ImportDir))
+	}
 
 	return os.WriteFile(cm.envPath, []byte(strings.Join(lines, "\n")), 0644)
 }
@@ -513,6 +611,7 @@ func NewStore() *Store {
 						log.Printf("Loaded data from database after initial sync")
 						store.broadcast(WSMessage{Type: MessageTypeInit, Data: store.toJSON()})
 					}
+					store.watchStorage()
 				}
 				store.broadcast(WSMessage{Type: MessageTypeProgress, Data: progress})
 			})
@@ -541,6 +640,7 @@ func NewStore() *Store {
 									log.Printf("Reloaded data from database after sync")
 									store.broadcast(WSMessage{Type: MessageTypeUpdate, Data: store.toJSON()})
 								}
+								store.watchStorage()
 							}
 							store.broadcast(WSMessage{Type: MessageTypeProgress, Data: progress})
 						})
@@ -559,6 +659,14 @@ func NewStore() *Store {
 	return store
 }
 
+// watchStorage starts live ingestion once the first sync has finished, so
+// the watcher never races the initial bulk write.
+func (s *Store) watchStorage() {
+	if err := s.syncManager.Watch(); err != nil {
+		log.Printf("Failed to watch OpenCode storage, changes will appear after the next sync: %v", err)
+	}
+}
+
 func (s *Store) loadOpenCodeMetadata() {
 	s.broadcast(WSMessage{Type: MessageTypeProgress, Data: map[string]any{"status": "loading", "message": "Reading OpenCode messages..."}})
 
@@ -728,94 +836,176 @@ func (s *Store) loadFromDatabase() error {
 
 func (s *Store) loadMessageContent(nodeID string) *MessageNode {
 	s.mu.RLock()
+	var found *MessageNode
+	needsParts := false
 	for _, folder := range s.Folders {
 		if node, exists := folder.Nodes[nodeID]; exists {
-			if node.HasLoaded {
+			if node.HasLoaded || folder.ID != "openchat" {
 				s.mu.RUnlock()
 				return node
 			}
+			found = node
+			needsParts = true
 		}
 	}
+	syncManager := s.syncManager
 	s.mu.RUnlock()
 
+	if !needsParts {
+		return found
+	}
+
+	var err error
+	if syncManager != nil {
+		err = syncManager.HydrateNow(nodeID)
+	} else {
+		var content, boundary string
+		content, boundary, err = hydrateMessage(s.db, s.partPath, nodeID)
+		if err == nil {
+			s.applyHydration(nodeID, content, boundary)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to load content for %s: %v", nodeID, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if folder, exists := s.Folders["openchat"]; exists {
+		if node, exists := folder.Nodes[nodeID]; exists {
+			return node
+		}
+	}
+	return found
+}
+
+// applyHydration copies freshly read content, and the boundary its parts
+// mark if any, onto the in-memory OpenCode node so the tree reflects it
+// without a full reload. Like the database, it keeps the loaded content
+// of a locked node.
+func (s *Store) applyHydration(nodeID, content, boundary string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for _, folder := range s.Folders {
+	if folder, exists := s.Folders["openchat"]; exists {
 		if node, exists := folder.Nodes[nodeID]; exists {
-			if node.HasLoaded || folder.ID != "openchat" {
-				return node
+			if !node.Locked || !node.HasLoaded {
+				node.Content = content
+				node.HasLoaded = true
 			}
+			if boundary != "" {
+				node.Boundary = boundary
+			}
+		}
+	}
+}
 
-			if folder.ID == "openchat" {
-				msgPartPath := filepath.Join(s.partPath, nodeID)
-				partFiles, err := os.ReadDir(msgPartPath)
-				if err == nil {
-					var partContents []string
-					for _, partFile := range partFiles {
-						if !strings.HasSuffix(partFile.Name(), ".json") {
-							continue
-						}
-
-						partFilePath := filepath.Join(msgPartPath, partFile.Name())
-						partData, err := os.ReadFile(partFilePath)
-						if err != nil {
-							continue
-						}
-
-						var ocPart OpenCodePart
-						if err := json.Unmarshal(partData, &ocPart); err != nil {
-							continue
-						}
-
-						if ocPart.Type == "text" && ocPart.Text != "" {
-							partContents = append(partContents, ocPart.Text)
-						}
-					}
+// applyNodes replaces or adds nodes that changed on disk and pushes just
+// those nodes to clients.
+func (s *Store) applyNodes(folderID string, nodes []*MessageNode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-					if len(partContents) > 0 {
-						node.Content = strings.Join(partContents, "\n")
-						node.HasLoaded = true
-					}
+	folder, exists := s.Folders[folderID]
+	if !exists {
+		if s.db == nil {
+			return fmt.Errorf("folder %s not found", folderID)
+		}
+		dbFolder, err := s.db.GetFolder(folderID)
+		if err != nil {
+			return err
+		}
+		if dbFolder == nil {
+			return fmt.Errorf("folder %s not found", folderID)
+		}
+		dbFolder.Nodes = make(map[string]*MessageNode)
+		s.Folders[folderID] = dbFolder
+		folder = dbFolder
+	}
 
-					s.db.UpdateNode("openchat", node)
+	for _, node := range nodes {
+		folder.Nodes[node.ID] = node
+		if parent, exists := folder.Nodes[node.ParentID]; exists && node.ParentID != "" {
+			found := false
+			for _, childID := range parent.Children {
+				if childID == node.ID {
+					found = true
+					break
 				}
-
-				return node
+			}
+			if !found {
+				parent.Children = append(parent.Children, node.ID)
 			}
 		}
 	}
 
+	s.broadcast(WSMessage{Type: MessageTypeNodes, Data: NodesUpdate{FolderID: folderID, Nodes: nodes}})
 	return nil
 }
 
-type SearchResult struct {
-	Node    *MessageNode `json:"node"`
-	Score   float64      `json:"score"`
-	Matched []string     `json:"matched"`
+// MessageDetail is returned by GET /api/messages/{id}?parts=true so a
+// whole turn (prose, tool calls, reasoning, patches) can be shown at once.
+type MessageDetail struct {
+	*MessageNode
+	Parts []*MessagePart `json:"parts"`
 }
 
-func (s *Store) searchMessages(query string, searchRaw bool) map[string]*MessageNode {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *Store) getMessageParts(nodeID string) ([]*MessagePart, error) {
+	if s.db != nil {
+		parts, err := s.db.GetParts(nodeID)
+		if err != nil || len(parts) > 0 {
+			return parts, err
+		}
+	}
+
+	if s.partPath == "" {
+		return []*MessagePart{}, nil
+	}
 
-	if query == "" {
-		return nil
+	parts, err := readMessageParts(s.partPath, nodeID)
+	if os.IsNotExist(err) {
+		return []*MessagePart{}, nil
 	}
+	return parts, err
+}
+
+// searchMessages is the search used without a database: plain words
+// fuzzy-matched against the nodes in memory.
+func (s *Store) searchMessages(req SearchRequest) (*SearchResponse, error) {
+	req.normalize()
 
-	results := make(map[string]*MessageNode)
-	queryLower := strings.ToLower(query)
+	parsed, err := ParseSearchQuery(req.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &SearchResponse{Query: req.Query, Offset: req.Offset, Limit: req.Limit, Results: []*SearchResult{}}
+	if req.Query == "" {
+		return response, nil
+	}
+	// Without the database only the fuzzy matcher is left, which knows
+	// nothing of filters, phrases, negation or OR.
+	if !parsed.IsPlainText() {
+		return nil, apperrors.NewValidationError("filters, quoted phrases, negation and OR need the database; search for plain words instead", nil)
+	}
 
+	s.mu.RLock()
+	var nodes []*MessageNode
 	for _, folder := range s.Folders {
 		for _, node := range folder.Nodes {
-			score, _ := calculateMatchScore(queryLower, node, searchRaw)
-			if score > 0 {
-				results[node.ID] = node
+			if node.DeletedUpstreamAt == "" || req.IncludeDeleted {
+				nodes = append(nodes, node)
 			}
 		}
 	}
+	s.mu.RUnlock()
 
-	return results
+	matches := fuzzyResults(strings.ToLower(req.Query), nodes, req.SearchRaw)
+	response.Total = len(matches)
+	response.Results = pageResults(matches, req.Offset, req.Limit)
+	annotateResults(response.Results, parsed, req.SearchRaw)
+
+	return response, nil
 }
 
 func calculateMatchScore(queryLower string, node *MessageNode, searchRaw bool) (float64, []string) {
@@ -926,110 +1116,483 @@ func fuzzyTagsMatch(query string, tags []string) bool {
 	return false
 }
 
-func (s *Store) AddFolder(folder *Folder) {
+// userFolderID is where messages created outside any folder are filed.
+const userFolderID = "user"
+
+// The mutators below write to the database first and only touch the
+// in-memory copy and broadcast once the write has committed.
+
+func (s *Store) AddFolder(folder *Folder) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+
+	if s.db != nil {
+		if err := s.db.InsertFolder(folder); err != nil {
+			return apperrors.NewDatabaseError("failed to save folder", err)
+		}
+	}
+
 	s.Folders[folder.ID] = folder
 	s.broadcast(WSMessage{Type: MessageTypeUpdate, Data: s.toJSON()})
+	return nil
 }
 
-func (s *Store) UpdateFolder(folder *Folder) {
+// UpdateFolder changes a folder's name and color; its nodes are kept.
+func (s *Store) UpdateFolder(folder *Folder) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.Folders[folder.ID] = folder
+
+	existing, exists := s.Folders[folder.ID]
+	if !exists {
+		return apperrors.NewNotFoundError("Folder not found", nil)
+	}
+
+	updated := *existing
+	updated.Name = folder.Name
+	updated.Color = folder.Color
+
+	if s.db != nil {
+		if err := s.db.InsertFolder(&updated); err != nil {
+			return apperrors.NewDatabaseError("failed to save folder", err)
+		}
+	}
+
+	s.Folders[folder.ID] = &updated
+	*folder = updated
 	s.broadcast(WSMessage{Type: MessageTypeUpdate, Data: s.toJSON()})
+	return nil
 }
 
-func (s *Store) DeleteFolder(id string) {
+func (s *Store) DeleteFolder(id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+
+	if _, exists := s.Folders[id]; !exists {
+		return apperrors.NewNotFoundError("Folder not found", nil)
+	}
+
+	if s.db != nil {
+		if err := s.db.DeleteFolder(id); err != nil {
+			return apperrors.NewDatabaseError("failed to delete folder", err)
+		}
+	}
+
 	delete(s.Folders, id)
 	s.broadcast(WSMessage{Type: MessageTypeUpdate, Data: s.toJSON()})
+	return nil
+}
+
+// findNode returns the folder holding a node. Callers must hold s.mu.
+func (s *Store) findNode(nodeID string) (*Folder, *MessageNode) {
+	for _, folder := range s.Folders {
+		if node, exists := folder.Nodes[nodeID]; exists {
+			return folder, node
+		}
+	}
+	return nil, nil
+}
+
+// AddNode files a node under folderID. Without a folder it joins its
+// parent's folder, or the user folder, which is created on first use.
+func (s *Store) AddNode(folderID string, node *MessageNode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var folder *Folder
+	createFolder := false
+	if folderID != "" && folderID != "all" {
+		existing, exists := s.Folders[folderID]
+		if !exists {
+			return apperrors.NewNotFoundError("Folder not found", nil)
+		}
+		folder = existing
+	} else if parentFolder, _ := s.findNode(node.ParentID); node.ParentID != "" && parentFolder != nil {
+		folder = parentFolder
+	} else if existing, exists := s.Folders[userFolderID]; exists {
+		folder = existing
+	} else {
+		folder = &Folder{
+			ID:        userFolderID,
+			Name:      "My Messages",
+			Color:     "#58a6ff",
+			CreatedAt: time.Now().Format(time.RFC3339),
+			Nodes:     make(map[string]*MessageNode),
+		}
+		createFolder = true
+	}
+
+	if s.db != nil {
+		var err error
+		if createFolder {
+			err = s.db.InsertNodeWithFolder(folder, node)
+		} else {
+			err = s.db.InsertNode(folder.ID, node)
+		}
+		if err != nil {
+			return apperrors.NewDatabaseError("failed to save message", err)
+		}
+	}
+
+	if createFolder {
+		s.Folders[folder.ID] = folder
+	}
+	folder.Nodes[node.ID] = node
+	if node.ParentID != "" {
+		if parent, exists := folder.Nodes[node.ParentID]; exists {
+			parent.Children = append(parent.Children, node.ID)
+		}
+	}
+	s.broadcast(WSMessage{Type: MessageTypeUpdate, Data: s.toJSON()})
+	return nil
 }
 
-func (s *Store) AddNode(folderID string, node *MessageNode) {
+// UpdateNode replaces a node's fields. Clients usually hold nodes whose
+// content was never loaded, so an empty unloaded body keeps the stored
+// content rather than wiping it.
+func (s *Store) UpdateNode(node *MessageNode) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if folderID == "" || folderID == "all" {
-		for _, folder := range s.Folders {
-			folder.Nodes[node.ID] = node
-			if node.ParentID != "" {
-				if parent, exists := folder.Nodes[node.ParentID]; exists {
-					parent.Children = append(parent.Children, node.ID)
-				}
-			}
+
+	folder, existing := s.findNode(node.ID)
+	if existing == nil {
+		return apperrors.NewNotFoundError("Node not found", nil)
+	}
+
+	if node.Content == "" && !node.HasLoaded {
+		node.Content = existing.Content
+		node.HasLoaded = existing.HasLoaded
+	}
+	if node.Children == nil {
+		node.Children = existing.Children
+	}
+	// Ranks only change through MoveNode; tombstones, estimates,
+	// boundaries and usage only through sync.
+	node.SortIndex = existing.SortIndex
+	node.DeletedUpstreamAt = existing.DeletedUpstreamAt
+	node.TimestampEstimated = existing.TimestampEstimated
+	node.Boundary = existing.Boundary
+	node.MessageUsage = existing.MessageUsage
+
+	if s.db != nil {
+		if err := s.db.UpdateNode(folder.ID, node); err != nil {
+			return apperrors.NewDatabaseError("failed to save message", err)
 		}
-	} else if folder, exists := s.Folders[folderID]; exists {
-		folder.Nodes[node.ID] = node
-		if node.ParentID != "" {
-			if parent, exists := folder.Nodes[node.ParentID]; exists {
-				parent.Children = append(parent.Children, node.ID)
-			}
+	}
+
+	for _, f := range s.Folders {
+		if _, exists := f.Nodes[node.ID]; exists {
+			f.Nodes[node.ID] = node
 		}
 	}
 	s.broadcast(WSMessage{Type: MessageTypeUpdate, Data: s.toJSON()})
+	return nil
 }
 
-func (s *Store) UpdateNode(folderID string, node *MessageNode) {
+func (s *Store) DeleteNode(nodeID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if folderID == "" || folderID == "all" {
-		for _, folder := range s.Folders {
-			if _, exists := folder.Nodes[node.ID]; exists {
-				folder.Nodes[node.ID] = node
-			}
+
+	if _, existing := s.findNode(nodeID); existing == nil {
+		return apperrors.NewNotFoundError("Node not found", nil)
+	}
+
+	if s.db != nil {
+		if err := s.db.DeleteNode(nodeID); err != nil {
+			return apperrors.NewDatabaseError("failed to delete message", err)
 		}
-	} else if folder, exists := s.Folders[folderID]; exists {
-		if _, exists := folder.Nodes[node.ID]; exists {
-			folder.Nodes[node.ID] = node
+	}
+
+	for _, folder := range s.Folders {
+		delete(folder.Nodes, nodeID)
+		for _, n := range folder.Nodes {
+			n.Children = removeChild(n.Children, nodeID)
 		}
 	}
 	s.broadcast(WSMessage{Type: MessageTypeUpdate, Data: s.toJSON()})
+	return nil
 }
 
-func (s *Store) DeleteNode(folderID, nodeID string) {
+// PurgeDeletedUpstream removes nodes whose source files were deleted
+// upstream. Locked nodes are kept. It returns how many nodes were removed.
+func (s *Store) PurgeDeletedUpstream() (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if folderID == "" || folderID == "all" {
+
+	var purged []string
+	if s.db != nil {
+		var err error
+		purged, err = s.db.PurgeDeletedUpstream()
+		if err != nil {
+			return 0, apperrors.NewDatabaseError("failed to purge deleted messages", err)
+		}
+	} else {
 		for _, folder := range s.Folders {
-			delete(folder.Nodes, nodeID)
-			for _, n := range folder.Nodes {
-				newChildren := []string{}
-				for _, childID := range n.Children {
-					if childID != nodeID {
-						newChildren = append(newChildren, childID)
-					}
+			for id, node := range folder.Nodes {
+				if node.DeletedUpstreamAt != "" && !node.Locked {
+					purged = append(purged, id)
 				}
-				n.Children = newChildren
 			}
 		}
-	} else if folder, exists := s.Folders[folderID]; exists {
-		delete(folder.Nodes, nodeID)
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	removed := make(map[string]bool, len(purged))
+	for _, nodeID := range purged {
+		removed[nodeID] = true
+		for _, folder := range s.Folders {
+			delete(folder.Nodes, nodeID)
+		}
+	}
+	for _, folder := range s.Folders {
 		for _, n := range folder.Nodes {
-			newChildren := []string{}
+			children := n.Children[:0:0]
 			for _, childID := range n.Children {
-				if childID != nodeID {
-					newChildren = append(newChildren, childID)
+				if !removed[childID] {
+					children = append(children, childID)
 				}
 			}
-			n.Children = newChildren
+			n.Children = children
+		}
+	}
+	s.broadcast(WSMessage{Type: MessageTypeUpdate, Data: s.toJSON()})
+	return len(purged), nil
+}
+
+func removeChild(children []string, nodeID string) []string {
+	newChildren := []string{}
+	for _, childID := range children {
+		if childID != nodeID {
+			newChildren = append(newChildren, childID)
+		}
+	}
+	return newChildren
+}
+
+// MoveNode re-parents a node and ranks it among its new siblings, after
+// the preceding ones when preceding is not nil and otherwise at newIndex,
+// as Database.ReorderNode describes. An empty newParentID moves it to the
+// root level of its folder; a parent in another folder is rejected.
+func (s *Store) MoveNode(nodeID, newParentID string, newIndex int, preceding []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	folder, node := s.findNode(nodeID)
+	if node == nil {
+		return apperrors.NewNotFoundError("Node not found", nil)
+	}
+	var newParent *MessageNode
+	if newParentID != "" {
+		var parentFolder *Folder
+		if parentFolder, newParent = s.findNode(newParentID); newParent == nil {
+			return apperrors.NewNotFoundError("Parent not found", nil)
+		}
+		if parentFolder != folder {
+			return apperrors.NewValidationError("a message can only be moved within its folder", nil)
+		}
+	}
+	for id := newParentID; id != ""; {
+		if id == nodeID {
+			return apperrors.NewValidationError("a message cannot be moved under itself", nil)
+		}
+		_, ancestor := s.findNode(id)
+		if ancestor == nil {
+			break
+		}
+		id = ancestor.ParentID
+	}
+
+	var ranks map[string]int
+	if s.db != nil {
+		var err error
+		ranks, err = s.db.ReorderNode(nodeID, newParentID, newIndex, preceding)
+		if err != nil {
+			return apperrors.NewDatabaseError("failed to move message", err)
+		}
+	} else {
+		var siblings []rankedSibling
+		for _, id := range s.siblingIDs(folder, newParentID, nodeID) {
+			_, sibling := s.findNode(id)
+			siblings = append(siblings, rankedSibling{id: id, rank: sibling.SortIndex})
+		}
+		ranks = placeRanks(siblings, nodeID, newIndex, preceding)
+	}
+
+	for id, rank := range ranks {
+		if _, ranked := s.findNode(id); ranked != nil {
+			ranked.SortIndex = rank
+		}
+	}
+	if node.ParentID != "" {
+		if _, oldParent := s.findNode(node.ParentID); oldParent != nil {
+			oldParent.Children = removeChild(oldParent.Children, nodeID)
+		}
+	}
+	node.ParentID = newParentID
+	if newParent != nil {
+		newParent.Children = s.siblingIDs(folder, newParentID, "")
+	}
+	s.broadcast(WSMessage{Type: MessageTypeUpdate, Data: s.toJSON()})
+	return nil
+}
+
+// siblingIDs lists the nodes of a folder under parentID, its root nodes
+// when empty, in display order without excludeID. It goes by ParentID, so
+// a node just moved is listed under its new parent. Callers must hold s.mu.
+func (s *Store) siblingIDs(folder *Folder, parentID, excludeID string) []string {
+	var siblings []*MessageNode
+	for id, n := range folder.Nodes {
+		if n.ParentID == parentID && id != excludeID {
+			siblings = append(siblings, n)
+		}
+	}
+
+	sortSiblings(siblings)
+	ids := make([]string, len(siblings))
+	for i, n := range siblings {
+		ids[i] = n.ID
+	}
+	return ids
+}
+
+// sortSiblings mirrors nodeSiblingOrder: ranked nodes first, then oldest
+// first.
+func sortSiblings(nodes []*MessageNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		if (a.SortIndex == 0) != (b.SortIndex == 0) {
+			return a.SortIndex != 0
+		}
+		if a.SortIndex != b.SortIndex {
+			return a.SortIndex < b.SortIndex
+		}
+		ta, errA := time.Parse(time.RFC3339, a.Timestamp)
+		tb, errB := time.Parse(time.RFC3339, b.Timestamp)
+		if errA == nil && errB == nil && !ta.Equal(tb) {
+			return ta.Before(tb)
+		}
+		return a.ID < b.ID
+	})
+}
+
+// SetNodeLocked protects a node from being replaced by later syncs.
+func (s *Store) SetNodeLocked(nodeID string, locked bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, existing := s.findNode(nodeID); existing == nil {
+		return apperrors.NewNotFoundError("Node not found", nil)
+	}
+
+	if s.db != nil {
+		if err := s.db.UpdateNodeLock(nodeID, locked); err != nil {
+			return apperrors.NewDatabaseError("failed to save lock state", err)
+		}
+	}
+
+	for _, folder := range s.Folders {
+		if node, exists := folder.Nodes[nodeID]; exists {
+			node.Locked = locked
+		}
+	}
+	s.broadcast(WSMessage{Type: MessageTypeUpdate, Data: s.toJSON()})
+	return nil
+}
+
+// ImportFolders merges exported folders into the store, as the database
+// does: a folder that exists takes the imported name and color and gains
+// the imported messages, which replace any with the same ID wherever they
+// were, and keeps its others. Nothing is kept if the database write fails.
+func (s *Store) ImportFolders(folders map[string]*Folder) error {
+	for id, folder := range folders {
+		if folder == nil {
+			return apperrors.NewValidationError(fmt.Sprintf("folder %s is empty", id), nil)
+		}
+		folder.ID = id
+		if folder.Nodes == nil {
+			folder.Nodes = make(map[string]*MessageNode)
+		}
+		if folder.CreatedAt == "" {
+			folder.CreatedAt = time.Now().Format(time.RFC3339)
+		}
+		for nodeID, node := range folder.Nodes {
+			if node == nil {
+				return apperrors.NewValidationError(fmt.Sprintf("message %s in folder %s is empty", nodeID, id), nil)
+			}
+			node.ID = nodeID
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db != nil {
+		if err := s.db.ImportFolders(folders); err != nil {
+			return apperrors.NewDatabaseError("failed to import folders", err)
+		}
+	}
+
+	imported := make(map[string]string)
+	for id, folder := range folders {
+		for nodeID := range folder.Nodes {
+			imported[nodeID] = id
+		}
+	}
+	for _, folder := range s.Folders {
+		for nodeID := range folder.Nodes {
+			if id, ok := imported[nodeID]; ok && id != folder.ID {
+				delete(folder.Nodes, nodeID)
+			}
+		}
+	}
+	for id, folder := range folders {
+		existing := s.Folders[id]
+		if existing == nil {
+			s.Folders[id] = folder
+			continue
+		}
+		existing.Name, existing.Color, existing.CreatedAt = folder.Name, folder.Color, folder.CreatedAt
+		for nodeID, node := range folder.Nodes {
+			existing.Nodes[nodeID] = node
 		}
 	}
 	s.broadcast(WSMessage{Type: MessageTypeUpdate, Data: s.toJSON()})
+	return nil
 }
 
-func (s *Store) GetFolders() map[string]*Folder {
+// GetFolders returns every folder. Nodes deleted upstream are left out
+// unless includeDeleted is set.
+func (s *Store) GetFolders(includeDeleted bool) map[string]*Folder {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.Folders
+	if includeDeleted {
+		return s.Folders
+	}
+
+	result := make(map[string]*Folder, len(s.Folders))
+	for id, folder := range s.Folders {
+		visible := *folder
+		visible.Nodes = make(map[string]*MessageNode, len(folder.Nodes))
+		for nodeID, node := range folder.Nodes {
+			if node.DeletedUpstreamAt == "" {
+				visible.Nodes[nodeID] = node
+			}
+		}
+		result[id] = &visible
+	}
+	return result
 }
 
-func (s *Store) getAllNodes() map[string]*MessageNode {
+func (s *Store) getAllNodes(includeDeleted bool) map[string]*MessageNode {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	result := make(map[string]*MessageNode)
 	for _, folder := range s.Folders {
 		for id, node := range folder.Nodes {
+			if node.DeletedUpstreamAt != "" && !includeDeleted {
+				continue
+			}
 			if _, exists := result[id]; !exists {
 				result[id] = node
 			}
@@ -1347,10 +1910,21 @@ func handleAIStream(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	var noBrowser bool
+	var noBrowser, showSchemaVersion bool
 	flag.BoolVar(&noBrowser, "no-browser", false, "Disable automatic browser opening")
+	flag.BoolVar(&showSchemaVersion, "schema-version", false, "Print the database schema version and exit")
 	flag.Parse()
 
+	if showSchemaVersion {
+		dbPath := getDatabasePath()
+		version, err := SchemaVersion(dbPath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Failed to read schema version of %s: %v", dbPath, err)
+		}
+		fmt.Printf("%s: schema version %d (latest %d)\n", dbPath, version, latestSchemaVersion())
+		return
+	}
+
 	configManager = NewConfigManager()
 	store := NewStore()
 
@@ -1384,7 +1958,7 @@ func main() {
 
 	router.HandleFunc("/api/folders", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" {
-			respondJSON(w, store.GetFolders())
+			respondJSON(w, store.GetFolders(r.URL.Query().Get("includeDeleted") == "true"))
 		} else if r.Method == "POST" {
 			var folder Folder
 			if err := json.NewDecoder(r.Body).Decode(&folder); err != nil {
@@ -1400,7 +1974,10 @@ func main() {
 			if folder.CreatedAt == "" {
 				folder.CreatedAt = time.Now().Format(time.RFC3339)
 			}
-			store.AddFolder(&folder)
+			if err := store.AddFolder(&folder); err != nil {
+				respondAppError(w, err)
+				return
+			}
 			respondJSON(w, folder)
 		}
 	})
@@ -1414,30 +1991,63 @@ func main() {
 				respondError(w, http.StatusBadRequest, err.Error())
 				return
 			}
-			store.UpdateFolder(&folder)
+			folder.ID = id
+			if err := store.UpdateFolder(&folder); err != nil {
+				respondAppError(w, err)
+				return
+			}
 			respondJSON(w, folder)
 		} else if r.Method == "DELETE" {
-			store.DeleteFolder(id)
+			if err := store.DeleteFolder(id); err != nil {
+				respondAppError(w, err)
+				return
+			}
 			respondJSON(w, map[string]string{"id": id})
 		}
 	})
 
 	router.HandleFunc("/api/messages", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" {
-			respondJSON(w, store.getAllNodes())
+			nodes := store.getAllNodes(r.URL.Query().Get("includeDeleted") == "true")
+			if project := r.URL.Query().Get("project"); project != "" {
+				sessions, err := store.ProjectSessionIDs(project)
+				if err != nil {
+					respondAppError(w, err)
+					return
+				}
+				for id, node := range nodes {
+					if !sessions[node.SessionID] {
+						delete(nodes, id)
+					}
+				}
+			}
+			respondJSON(w, nodes)
 		} else if r.Method == "POST" {
-			var node MessageNode
-			if err := json.NewDecoder(r.Body).Decode(&node); err != nil {
+			var data struct {
+				MessageNode
+				FolderID  string   `json:"folderId"`
+				FolderIDs []string `json:"folderIds"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 				respondError(w, http.StatusBadRequest, err.Error())
 				return
 			}
+			node := data.MessageNode
 			if node.ID == "" {
 				node.ID = generateID()
 			}
 			if node.Timestamp == "" {
 				node.Timestamp = time.Now().Format(time.RFC3339)
 			}
-			store.AddNode("", &node)
+			node.HasLoaded = true
+			folderID := data.FolderID
+			if folderID == "" && len(data.FolderIDs) > 0 {
+				folderID = data.FolderIDs[0]
+			}
+			if err := store.AddNode(folderID, &node); err != nil {
+				respondAppError(w, err)
+				return
+			}
 			respondJSON(w, node)
 		}
 	})
@@ -1451,14 +2061,31 @@ func main() {
 				respondError(w, http.StatusBadRequest, err.Error())
 				return
 			}
-			store.UpdateNode("", &node)
+			node.ID = nodeID
+			if err := store.UpdateNode(&node); err != nil {
+				respondAppError(w, err)
+				return
+			}
 			respondJSON(w, node)
 		} else if r.Method == "DELETE" {
-			store.DeleteNode("", nodeID)
+			if err := store.DeleteNode(nodeID); err != nil {
+				respondAppError(w, err)
+				return
+			}
 			respondJSON(w, map[string]string{"id": nodeID})
 		} else if r.Method == "GET" {
 			if node := store.loadMessageContent(nodeID); node != nil {
-				respondJSON(w, node)
+				if r.URL.Query().Get("parts") != "true" {
+					respondJSON(w, node)
+					return
+				}
+
+				parts, err := store.getMessageParts(nodeID)
+				if err != nil {
+					respondError(w, http.StatusInternalServerError, err.Error())
+					return
+				}
+				respondJSON(w, MessageDetail{MessageNode: node, Parts: parts})
 			} else {
 				respondError(w, http.StatusNotFound, "Node not found")
 			}
@@ -1472,23 +2099,11 @@ func main() {
 			}
 
 			if data.Locked != nil {
-				store.mu.Lock()
-				if folder, exists := store.Folders["openchat"]; exists {
-					if node, exists := folder.Nodes[nodeID]; exists {
-						node.Locked = *data.Locked
-
-						if store.db != nil {
-							store.db.UpdateNodeLock(nodeID, *data.Locked)
-						}
-
-						store.broadcast(WSMessage{Type: MessageTypeUpdate, Data: store.toJSON()})
-						store.mu.Unlock()
-						respondJSON(w, map[string]bool{"locked": *data.Locked})
-						return
-					}
+				if err := store.SetNodeLocked(nodeID, *data.Locked); err != nil {
+					respondAppError(w, err)
+					return
 				}
-				store.mu.Unlock()
-				respondError(w, http.StatusNotFound, "Node not found")
+				respondJSON(w, map[string]bool{"locked": *data.Locked})
 			} else {
 				respondError(w, http.StatusBadRequest, "No fields to update")
 			}
@@ -1497,26 +2112,24 @@ func main() {
 
 	router.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "POST" {
-			var data struct {
-				Query     string `json:"query"`
-				SearchRaw bool   `json:"searchRaw"`
-			}
+			var data SearchRequest
 			if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 				respondError(w, http.StatusBadRequest, err.Error())
 				return
 			}
 
+			var results *SearchResponse
+			var err error
 			if store.db != nil {
-				results, err := store.db.SearchNodes(data.Query, data.SearchRaw)
-				if err != nil {
-					respondError(w, http.StatusInternalServerError, err.Error())
-					return
-				}
-				respondJSON(w, results)
+				results, err = store.db.SearchNodes(data)
 			} else {
-				results := store.searchMessages(data.Query, data.SearchRaw)
-				respondJSON(w, results)
+				results, err = store.searchMessages(data)
 			}
+			if err != nil {
+				respondAppError(w, err)
+				return
+			}
+			respondJSON(w, results)
 		}
 	})
 
@@ -1532,10 +2145,8 @@ func main() {
 				return
 			}
 
-			store.syncManager = NewSyncManager(store.db, store, store.dataPath, func(progress SyncProgress) {
-				store.broadcast(WSMessage{Type: MessageTypeProgress, Data: progress})
-			})
-
+			// The manager is kept, along with the content it may still be
+			// loading from the last sync.
 			if err := store.syncManager.StartSync(); err != nil {
 				respondError(w, http.StatusInternalServerError, err.Error())
 				return
@@ -1557,75 +2168,148 @@ func main() {
 		}
 	})
 
+	router.HandleFunc("/api/sources", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			statuses, err := store.SourceStatuses()
+			if err != nil {
+				respondAppError(w, apperrors.NewDatabaseError("failed to list history sources", err))
+				return
+			}
+			respondJSON(w, statuses)
+		}
+	})
+
+	router.HandleFunc("/api/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			query := r.URL.Query()
+			sessions, err := store.ListSessions(SessionFilter{
+				ParentID:  query.Get("parentId"),
+				ProjectID: query.Get("projectId"),
+				Sort:      query.Get("sort"),
+			})
+			if err != nil {
+				respondAppError(w, err)
+				return
+			}
+			respondJSON(w, sessions)
+		}
+	})
+
+	router.HandleFunc("/api/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			session, err := store.GetSession(mux.Vars(r)["id"])
+			if err != nil {
+				respondAppError(w, err)
+				return
+			}
+			respondJSON(w, session)
+		}
+	})
+
+	router.HandleFunc("/api/sessions/{id}/tree", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			tree, err := store.GetSessionTree(mux.Vars(r)["id"])
+			if err != nil {
+				respondAppError(w, err)
+				return
+			}
+			respondJSON(w, tree)
+		}
+	})
+
+	router.HandleFunc("/api/sessions/{id}/transcript", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			opts, err := parseTranscriptOptions(r.URL.Query())
+			if err != nil {
+				respondAppError(w, err)
+				return
+			}
+			transcript, err := store.Transcript(mux.Vars(r)["id"], opts)
+			if err != nil {
+				respondAppError(w, err)
+				return
+			}
+			respondJSON(w, transcript)
+		}
+	})
+
+	router.HandleFunc("/api/sessions/{id}/branches", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			branches, err := store.SessionBranches(mux.Vars(r)["id"])
+			if err != nil {
+				respondAppError(w, err)
+				return
+			}
+			respondJSON(w, branches)
+		}
+	})
+
+	router.HandleFunc("/api/projects", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			projects, err := store.ListProjects()
+			if err != nil {
+				respondAppError(w, err)
+				return
+			}
+			respondJSON(w, projects)
+		}
+	})
+
+	router.HandleFunc("/api/projects/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			project, err := store.GetProject(mux.Vars(r)["id"])
+			if err != nil {
+				respondAppError(w, err)
+				return
+			}
+			respondJSON(w, project)
+		}
+	})
+
+	router.HandleFunc("/api/usage", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			groupBy := r.URL.Query().Get("groupBy")
+			if groupBy == "" {
+				groupBy = "model"
+			}
+			report, err := store.Usage(groupBy, r.URL.Query().Get("q"))
+			if err != nil {
+				respondAppError(w, err)
+				return
+			}
+			respondJSON(w, report)
+		}
+	})
+
+	router.HandleFunc("/api/sync/purge-deleted", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			purged, err := store.PurgeDeletedUpstream()
+			if err != nil {
+				respondAppError(w, err)
+				return
+			}
+			respondJSON(w, map[string]int{"purged": purged})
+		}
+	})
+
 	router.HandleFunc("/api/reorder", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "POST" {
 			var data struct {
-				FolderID    string `json:"folderId"`
 				NodeID      string `json:"nodeId"`
 				NewParentID string `json:"newParentId"`
 				NewIndex    int    `json:"newIndex"`
+				// The siblings shown above the drop point, top to
+				// bottom; when present they place the node, not NewIndex.
+				PrecedingIDs []string `json:"precedingIds"`
 			}
 			if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 				respondError(w, http.StatusBadRequest, err.Error())
 				return
 			}
-			store.mu.Lock()
-			if data.FolderID == "" || data.FolderID == "all" {
-				for _, folder := range store.Folders {
-					if node, exists := folder.Nodes[data.NodeID]; exists {
-						if node.ParentID != "" {
-							if parent, exists := folder.Nodes[node.ParentID]; exists {
-								newChildren := []string{}
-								for _, childID := range parent.Children {
-									if childID != data.NodeID {
-										newChildren = append(newChildren, childID)
-									}
-								}
-								parent.Children = newChildren
-							}
-						}
-						node.ParentID = data.NewParentID
-						if data.NewParentID != "" {
-							if newParent, exists := folder.Nodes[data.NewParentID]; exists {
-								if data.NewIndex >= 0 && data.NewIndex <= len(newParent.Children) {
-									newParent.Children = append(newParent.Children[:data.NewIndex],
-										append([]string{data.NodeID}, newParent.Children[data.NewIndex:]...)...)
-								} else {
-									newParent.Children = append(newParent.Children, data.NodeID)
-								}
-							}
-						}
-					}
-				}
-			} else {
-				if folder, exists := store.Folders[data.FolderID]; exists {
-					if node, exists := folder.Nodes[data.NodeID]; exists {
-						if node.ParentID != "" {
-							if parent, exists := folder.Nodes[node.ParentID]; exists {
-								newChildren := []string{}
-								for _, childID := range parent.Children {
-									if childID != data.NodeID {
-										newChildren = append(newChildren, childID)
-									}
-								}
-								parent.Children = newChildren
-							}
-						}
-						node.ParentID = data.NewParentID
-						if data.NewParentID != "" {
-							if newParent, exists := folder.Nodes[data.NewParentID]; exists {
-								if data.NewIndex >= 0 && data.NewIndex <= len(newParent.Children) {
-									newParent.Children = append(newParent.Children[:data.NewIndex],
-										append([]string{data.NodeID}, newParent.Children[data.NewIndex:]...)...)
-								} else {
-									newParent.Children = append(newParent.Children, data.NodeID)
-								}
-							}
-						}
-					}
-				}
+			if err := store.MoveNode(data.NodeID, data.NewParentID, data.NewIndex, data.PrecedingIDs); err != nil {
+				respondAppError(w, err)
+				return
 			}
-			store.mu.Unlock()
 			respondJSON(w, map[string]string{"status": "ok"})
 		}
 	})
@@ -1644,7 +2328,7 @@ func main() {
 
 			combined := ""
 			store.mu.RLock()
-			allNodes := store.getAllNodes()
+			allNodes := store.getAllNodes(true)
 			for _, selected := range data.SelectedNodes {
 				if node, exists := allNodes[selected.NodeID]; exists {
 					combined += node.Content + "\n\n"
@@ -1666,20 +2350,37 @@ func main() {
 
 	router.HandleFunc("/api/import", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "POST" {
+			// The body is an export or a zip of one; ?path= reads one from
+			// disk instead, such as an extracted export's directory. Paths
+			// are only read from within IMPORT_DIR, and not at all without
+			// it, which can't be changed through /api/config.
 			var importedData map[string]*Folder
-			if err := json.NewDecoder(r.Body).Decode(&importedData); err != nil {
+			var format string
+			var err error
+			if path := r.URL.Query().Get("path"); path != "" {
+				configManager.mu.RLock()
+				importDir := configManager.config.ImportDir
+				configManager.mu.RUnlock()
+				if path, err = resolveImportPath(importDir, path); err == nil {
+					importedData, format, err = readImportPath(path)
+				}
+			} else {
+				var data []byte
+				data, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxExportSize))
+				if err == nil {
+					importedData, format, err = readImport(data)
+				}
+			}
+			if err != nil {
 				respondError(w, http.StatusBadRequest, err.Error())
 				return
 			}
 
-			store.mu.Lock()
-			for id, folder := range importedData {
-				store.Folders[id] = folder
+			if err := store.ImportFolders(importedData); err != nil {
+				respondAppError(w, err)
+				return
 			}
-			store.mu.Unlock()
-
-			store.broadcast(WSMessage{Type: MessageTypeUpdate, Data: store.toJSON()})
-			respondJSON(w, map[string]string{"status": "imported", "count": fmt.Sprintf("%d", len(importedData))})
+			respondJSON(w, map[string]string{"status": "imported", "format": format, "count": fmt.Sprintf("%d", len(importedData))})
 		}
 	})
 
@@ -1840,6 +2541,18 @@ func main() {
 		log.Printf("Server shutdown error: %v", err)
 	}
 
+	// Stop ingesting before the database is closed, so a pending debounced
+	// write cannot land on a closed connection.
+	if store.syncManager != nil {
+		store.syncManager.StopWatching()
+		store.syncManager.CancelSync()
+	}
+	if store.db != nil {
+		if err := store.db.Close(); err != nil {
+			log.Printf("Database close error: %v", err)
+		}
+	}
+
 	fmt.Println("\nServer stopped gracefully")
 }
 
@@ -1854,6 +2567,22 @@ func respondError(w http.ResponseWriter, code int, message string) {
 	json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
 
+// respondAppError maps internal/errors types onto HTTP status codes.
+func respondAppError(w http.ResponseWriter, err error) {
+	var appErr *apperrors.AppError
+	if errors.As(err, &appErr) {
+		switch appErr.Type {
+		case apperrors.ErrorTypeValidation:
+			respondError(w, http.StatusBadRequest, appErr.Message)
+			return
+		case apperrors.ErrorTypeNotFound:
+			respondError(w, http.StatusNotFound, appErr.Message)
+			return
+		}
+	}
+	respondError(w, http.StatusInternalServerError, err.Error())
+}
+
 func openBrowser(url string) {
 	var cmd string
 	var args []string
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// migration is one numbered schema step. Steps run in order, each in its
+// own transaction together with its schema_migrations row, and are never
+// edited once released: schema changes get a new step at the end.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// Databases created before versioning already have some of these tables,
+// so early steps use IF NOT EXISTS and check for columns before adding them.
+var migrations = []migration{
+	{1, "baseline", func(tx *sql.Tx) error {
+		return execAll(tx, `
+			CREATE TABLE IF NOT EXISTS folders (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				color TEXT NOT NULL,
+				created_at TEXT NOT NULL
+			)`, `
+			CREATE TABLE IF NOT EXISTS nodes (
+				id TEXT PRIMARY KEY,
+				folder_id TEXT NOT NULL,
+				type TEXT NOT NULL,
+				content TEXT,
+				summary TEXT,
+				timestamp TEXT NOT NULL,
+				parent_id TEXT,
+				expanded INTEGER NOT NULL DEFAULT 0,
+				selected INTEGER NOT NULL DEFAULT 0,
+				session_id TEXT,
+				has_loaded INTEGER NOT NULL DEFAULT 0,
+				locked INTEGER NOT NULL DEFAULT 0,
+				FOREIGN KEY (folder_id) REFERENCES folders(id) ON DELETE CASCADE
+			)`, `
+			CREATE TABLE IF NOT EXISTS tags (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				node_id TEXT NOT NULL,
+				tag TEXT NOT NULL,
+				FOREIGN KEY (node_id) REFERENCES nodes(id) ON DELETE CASCADE
+			)`,
+			"CREATE INDEX IF NOT EXISTS idx_nodes_folder_id ON nodes(folder_id)",
+			"CREATE INDEX IF NOT EXISTS idx_nodes_parent_id ON nodes(parent_id)",
+			"CREATE INDEX IF NOT EXISTS idx_nodes_type ON nodes(type)",
+			"CREATE INDEX IF NOT EXISTS idx_nodes_timestamp ON nodes(timestamp)",
+			"CREATE INDEX IF NOT EXISTS idx_tags_node_id ON tags(node_id)",
+		)
+	}},
+	{2, "message parts", func(tx *sql.Tx) error {
+		return execAll(tx, `
+			CREATE TABLE IF NOT EXISTS parts (
+				id TEXT PRIMARY KEY,
+				message_id TEXT NOT NULL,
+				session_id TEXT,
+				position INTEGER NOT NULL DEFAULT 0,
+				type TEXT NOT NULL,
+				text TEXT,
+				tool TEXT,
+				call_id TEXT,
+				status TEXT,
+				title TEXT,
+				input TEXT,
+				output TEXT,
+				error TEXT,
+				file_path TEXT,
+				mime TEXT,
+				patch TEXT,
+				files TEXT,
+				started_at TEXT,
+				ended_at TEXT,
+				FOREIGN KEY (message_id) REFERENCES nodes(id) ON DELETE CASCADE
+			)`,
+			"CREATE INDEX IF NOT EXISTS idx_parts_message_id ON parts(message_id, position)",
+		)
+	}},
+	{3, "hydration state", func(tx *sql.Tx) error {
+		return execAll(tx, `
+			CREATE TABLE IF NOT EXISTS hydration_state (
+				message_id TEXT PRIMARY KEY,
+				signature TEXT NOT NULL,
+				hydrated_at TEXT NOT NULL
+			)`,
+		)
+	}},
+	{4, "full-text search", func(tx *sql.Tx) error {
+		// Populated by ensureSearchIndex, which also repairs drift.
+		return execAll(tx, `
+			CREATE VIRTUAL TABLE IF NOT EXISTS nodes_fts USING fts5(
+				content,
+				summary,
+				tags,
+				type,
+				tokenize = 'unicode61 remove_diacritics 2'
+			)`,
+		)
+	}},
+	{5, "sibling order", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "nodes", "sort_index", "INTEGER"); err != nil {
+			return err
+		}
+		return execAll(tx, "CREATE INDEX IF NOT EXISTS idx_nodes_folder_sort ON nodes(folder_id, sort_index)")
+	}},
+	{6, "sync checkpoints", func(tx *sql.Tx) error {
+		return execAll(tx, `
+			CREATE TABLE IF NOT EXISTS sync_checkpoints (
+				path TEXT PRIMARY KEY,
+				size INTEGER NOT NULL,
+				mtime INTEGER NOT NULL,
+				hash TEXT NOT NULL
+			)`,
+		)
+	}},
+	{7, "upstream deletions", func(tx *sql.Tx) error {
+		return addColumn(tx, "nodes", "deleted_upstream_at", "TEXT")
+	}},
+	{8, "content-addressed history IDs", func(tx *sql.Tx) error {
+		// Line-numbered history nodes are renamed by the next sync, which
+		// has the history file to pair each line with its new ID; see
+		// RenameLegacyHistoryNodes. Migration 9 makes sure it reads it.
+		return nil
+	}},
+	{9, "estimated timestamps", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "nodes", "timestamp_estimated", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		// Every history entry so far got a made-up time. Flag them, and
+		// forget the history files' checkpoints so the next sync reads
+		// them again and replaces what it can with real or inferred times.
+		for _, folderID := range legacyHistoryFolders {
+			if _, err := tx.Exec("UPDATE nodes SET timestamp_estimated = 1 WHERE id LIKE ? ESCAPE '\\'", escapeLike(folderID+"_")+"%"); err != nil {
+				return err
+			}
+		}
+		return execAll(tx, "DELETE FROM sync_checkpoints WHERE path LIKE '%.jsonl'")
+	}},
+	{10, "sessions", func(tx *sql.Tx) error {
+		return execAll(tx, `
+			CREATE TABLE IF NOT EXISTS sessions (
+				id TEXT PRIMARY KEY,
+				title TEXT NOT NULL DEFAULT '',
+				directory TEXT NOT NULL DEFAULT '',
+				project_id TEXT NOT NULL DEFAULT '',
+				parent_id TEXT NOT NULL DEFAULT '',
+				version TEXT NOT NULL DEFAULT '',
+				source TEXT NOT NULL DEFAULT '',
+				created_at TEXT NOT NULL DEFAULT '',
+				updated_at TEXT NOT NULL DEFAULT ''
+			)`,
+			"CREATE INDEX IF NOT EXISTS idx_sessions_parent_id ON sessions(parent_id)",
+			"CREATE INDEX IF NOT EXISTS idx_nodes_session_id ON nodes(session_id)",
+		)
+	}},
+	{11, "projects", func(tx *sql.Tx) error {
+		return execAll(tx, `
+			CREATE TABLE IF NOT EXISTS projects (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL DEFAULT '',
+				worktree TEXT NOT NULL DEFAULT '',
+				vcs TEXT NOT NULL DEFAULT '',
+				source TEXT NOT NULL DEFAULT '',
+				created_at TEXT NOT NULL DEFAULT ''
+			)`,
+			"CREATE INDEX IF NOT EXISTS idx_sessions_project_id ON sessions(project_id)",
+		)
+	}},
+	{12, "message usage", func(tx *sql.Tx) error {
+		for _, column := range []struct{ name, definition string }{
+			{"model_id", "TEXT NOT NULL DEFAULT ''"},
+			{"provider_id", "TEXT NOT NULL DEFAULT ''"},
+			{"agent", "TEXT NOT NULL DEFAULT ''"},
+			{"tokens_input", "INTEGER NOT NULL DEFAULT 0"},
+			{"tokens_output", "INTEGER NOT NULL DEFAULT 0"},
+			{"tokens_reasoning", "INTEGER NOT NULL DEFAULT 0"},
+			{"tokens_cache_read", "INTEGER NOT NULL DEFAULT 0"},
+			{"tokens_cache_write", "INTEGER NOT NULL DEFAULT 0"},
+			{"cost", "REAL NOT NULL DEFAULT 0"},
+			{"completed_at", "TEXT NOT NULL DEFAULT ''"},
+		} {
+			if err := addColumn(tx, "nodes", column.name, column.definition); err != nil {
+				return err
+			}
+		}
+		// Forget the message checkpoints so the next sync reads every
+		// message file again and fills the new columns in.
+		if err := execAll(tx,
+			"CREATE INDEX IF NOT EXISTS idx_nodes_model_id ON nodes(model_id)",
+			"CREATE INDEX IF NOT EXISTS idx_nodes_provider_id ON nodes(provider_id)",
+		); err != nil {
+			return err
+		}
+		return forgetMessageCheckpoints(tx)
+	}},
+	{13, "message errors", func(tx *sql.Tx) error {
+		for _, column := range []struct{ name, definition string }{
+			{"latency_ms", "INTEGER NOT NULL DEFAULT 0"},
+			{"error_kind", "TEXT NOT NULL DEFAULT ''"},
+			{"error_name", "TEXT NOT NULL DEFAULT ''"},
+			{"error_message", "TEXT NOT NULL DEFAULT ''"},
+		} {
+			if err := addColumn(tx, "nodes", column.name, column.definition); err != nil {
+				return err
+			}
+		}
+		// As for usage, the next sync reads every message file again.
+		if err := execAll(tx, "CREATE INDEX IF NOT EXISTS idx_nodes_error_kind ON nodes(error_kind)"); err != nil {
+			return err
+		}
+		return forgetMessageCheckpoints(tx)
+	}},
+	{14, "subagent sessions", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "parts", "child_session_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		// Hydrate the messages holding task calls again so the session
+		// each one spawned is read from its metadata.
+		return execAll(tx,
+			"CREATE INDEX IF NOT EXISTS idx_parts_child_session_id ON parts(child_session_id)",
+			`DELETE FROM hydration_state WHERE message_id IN (SELECT message_id FROM parts WHERE tool = 'task')`,
+		)
+	}},
+	{15, "compaction boundaries", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "nodes", "boundary", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		// Compaction parts are already stored; summaries are flagged in
+		// the message files, which the next sync reads again.
+		if err := execAll(tx,
+			"CREATE INDEX IF NOT EXISTS idx_nodes_boundary ON nodes(boundary)",
+			`UPDATE nodes SET boundary = 'compaction' WHERE id IN (SELECT message_id FROM parts WHERE type = 'compaction')`,
+		); err != nil {
+			return err
+		}
+		return forgetMessageCheckpoints(tx)
+	}},
+	{16, "part hashes and snapshots", func(tx *sql.Tx) error {
+		for _, column := range []string{"hash", "snapshot", "reason"} {
+			if err := addColumn(tx, "parts", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+		}
+		// Patch parts kept their hash in patch, and step and snapshot
+		// parts their snapshot there and their finish reason in status.
+		return execAll(tx,
+			`UPDATE parts SET hash = COALESCE(patch, '') WHERE type = 'patch'`,
+			`UPDATE parts SET snapshot = COALESCE(patch, ''), reason = COALESCE(status, ''), status = ''
+			 WHERE type IN ('step-start', 'step-finish', 'snapshot')`,
+			"ALTER TABLE parts DROP COLUMN patch",
+		)
+	}},
+	{17, "checkpoint folders", func(tx *sql.Tx) error {
+		// Session logs checkpointed without their folder are imported
+		// once more, which records it.
+		return addColumn(tx, "sync_checkpoints", "folder_id", "TEXT NOT NULL DEFAULT ''")
+	}},
+}
+
+// legacyHistoryFolders are the history sources whose entries were numbered
+// by line, as "<folder>_<n>", before IDs were derived from content.
+var legacyHistoryFolders = []string{"webui", "amp"}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// forgetMessageCheckpoints has the next sync read every OpenCode message
+// file again. Where those are is configured, so a migration cannot tell
+// their checkpoints apart; it only leaves the message_resync table as a
+// request, which ClearRequestedCheckpoints carries out by prefix.
+func forgetMessageCheckpoints(tx *sql.Tx) error {
+	return execAll(tx,
+		"CREATE TABLE IF NOT EXISTS message_resync (requested_at TEXT NOT NULL)",
+		"INSERT INTO message_resync (requested_at) VALUES (datetime('now'))",
+	)
+}
+
+func execAll(tx *sql.Tx, statements ...string) error {
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn adds a column unless a database from before versioning
+// already has it.
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return err
+	}
+	exists := false
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			exists = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// schemaVersion returns the highest applied migration, 0 for a database
+// that predates versioning or is new.
+func schemaVersion(db *sql.DB) (int, error) {
+	var exists int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&exists)
+	if err != nil || exists == 0 {
+		return 0, err
+	}
+
+	var version int
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// migrate brings the schema up to date. Existing databases are copied
+// aside first so a failed or unwanted upgrade can be rolled back by hand.
+func (d *Database) migrate() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current, err := schemaVersion(d.db)
+	if err != nil {
+		return err
+	}
+	if current >= latestSchemaVersion() {
+		return nil
+	}
+
+	var tables int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables); err != nil {
+		return err
+	}
+	if tables > 0 {
+		backupPath, err := d.backup(current)
+		if err != nil {
+			return fmt.Errorf("failed to back up database before migrating: %w", err)
+		}
+		log.Printf("Backed up database to %s before migrating from schema version %d", backupPath, current)
+	}
+
+	_, err = d.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := d.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		log.Printf("Applied schema migration %d: %s", m.version, m.name)
+	}
+
+	return nil
+}
+
+func (d *Database) applyMigration(m migration) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.version, m.name, time.Now().Format(time.RFC3339),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// backup writes a consistent copy of the database next to it.
+func (d *Database) backup(version int) (string, error) {
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", d.dbPath, version, time.Now().Format("20060102-150405"))
+	if _, err := os.Stat(backupPath); err == nil {
+		return "", fmt.Errorf("backup %s already exists", backupPath)
+	}
+
+	_, err := d.db.Exec("VACUUM INTO '" + strings.ReplaceAll(backupPath, "'", "''") + "'")
+	return backupPath, err
+}
+
+// SchemaVersion reports the schema version of the database at dbPath
+// without migrating it.
+func SchemaVersion(dbPath string) (int, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return 0, err
+	}
+
+	db, err := sql.Open("sqlite", dbPath+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	return schemaVersion(db)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// legacySchema is the unversioned schema shipped before migrations existed.
+var legacySchema = []string{
+	"CREATE TABLE folders (id TEXT PRIMARY KEY, name TEXT NOT NULL, color TEXT NOT NULL, created_at TEXT NOT NULL)",
+	`CREATE TABLE nodes (id TEXT PRIMARY KEY, folder_id TEXT NOT NULL, type TEXT NOT NULL, content TEXT, summary TEXT,
+		timestamp TEXT NOT NULL, parent_id TEXT, expanded INTEGER NOT NULL DEFAULT 0, selected INTEGER NOT NULL DEFAULT 0,
+		session_id TEXT, has_loaded INTEGER NOT NULL DEFAULT 0, locked INTEGER NOT NULL DEFAULT 0)`,
+	"CREATE TABLE tags (id INTEGER PRIMARY KEY AUTOINCREMENT, node_id TEXT NOT NULL, tag TEXT NOT NULL)",
+}
+
+func createLegacyDatabase(t *testing.T, dbPath string, statements ...string) {
+	t.Helper()
+	legacy, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer legacy.Close()
+
+	for _, statement := range append(legacySchema, statements...) {
+		if _, err := legacy.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "legacy.db")
+
+	createLegacyDatabase(t, dbPath,
+		"INSERT INTO folders VALUES ('f1', 'Old', '#fff', '2025-01-01T00:00:00Z')",
+		"INSERT INTO nodes VALUES ('n1', 'f1', 'prompt', 'kept across upgrades', '', '2025-01-01T00:00:00Z', '', 0, 0, '', 1, 0)",
+	)
+
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	version, err := schemaVersion(db.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latestSchemaVersion() {
+		t.Errorf("schema version %d, want %d", version, latestSchemaVersion())
+	}
+
+	node, err := db.GetNode("n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node == nil || node.Content != "kept across upgrades" {
+		t.Fatalf("unexpected node after migration: %+v", node)
+	}
+
+	response, err := db.SearchNodes(SearchRequest{Query: "upgrades"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 1 {
+		t.Errorf("expected the migrated node to be searchable, got %d results", response.Total)
+	}
+
+	backups, err := filepath.Glob(dbPath + ".v0-*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Errorf("expected one backup, got %v", backups)
+	}
+}
+
+func TestMigrateNewDatabaseSkipsBackup(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "new.db")
+
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	backups, _ := filepath.Glob(dbPath + ".*.bak")
+	if len(backups) != 0 {
+		t.Errorf("expected no backup for a new database, got %v", backups)
+	}
+
+	version, err := SchemaVersion(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latestSchemaVersion() {
+		t.Errorf("schema version %d, want %d", version, latestSchemaVersion())
+	}
+
+	// Reopening an up-to-date database applies nothing.
+	db, err = NewDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if backups, _ := filepath.Glob(dbPath + ".*.bak"); len(backups) != 0 {
+		t.Errorf("unexpected backup %v", backups)
+	}
+}
+
+func TestMigrateHistoryIDs(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "legacy.db")
+
+	// The first entry was edited after it was imported, and the last was
+	// moved to another folder.
+	createLegacyDatabase(t, dbPath,
+		"INSERT INTO folders VALUES ('webui', 'Web UI History', '#6b8afd', '2025-01-01T00:00:00Z')",
+		"INSERT INTO folders VALUES ('f1', 'Curated', '#fff', '2025-01-01T00:00:00Z')",
+		"INSERT INTO nodes VALUES ('webui_1', 'webui', 'prompt', 'run the tests with -race', '', '2025-01-01T00:03:00Z', '', 0, 0, '', 1, 0)",
+		"INSERT INTO nodes VALUES ('webui_2', 'webui', 'prompt', 'fix the build', '', '2025-01-01T00:02:00Z', '', 0, 0, '', 1, 1)",
+		"INSERT INTO nodes VALUES ('webui_3', 'f1', 'prompt', 'run the tests', '', '2025-01-01T00:01:00Z', '', 0, 0, '', 1, 0)",
+		"INSERT INTO nodes VALUES ('note', 'f1', 'prompt', 'about the build', '', '2025-01-01T00:04:00Z', 'webui_2', 0, 0, '', 1, 0)",
+		"INSERT INTO tags (node_id, tag) VALUES ('webui_2', 'important')",
+	)
+
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if node, err := db.GetNode("webui_2"); err != nil || node == nil {
+		t.Fatalf("expected line-numbered nodes to wait for the history file, got %v", err)
+	}
+
+	// The history file the nodes were numbered from, read by the next sync.
+	historyPath := filepath.Join(dir, "prompt-history.jsonl")
+	history := `{"input":"run the tests","mode":"normal"}
+{"input":"fix the build","mode":"normal"}
+{"input":"run the tests","mode":"normal"}
+`
+	if err := os.WriteFile(historyPath, []byte(history), 0644); err != nil {
+		t.Fatal(err)
+	}
+	source := HistorySource{Path: historyPath, FolderID: "webui", Name: "Web UI History", Color: "#6b8afd"}
+	sm := NewSyncManager(db, nil, t.TempDir(), nil)
+	sm.historySources = []HistorySource{source}
+	if err := sm.syncHistorySource(source); err != nil {
+		t.Fatal(err)
+	}
+
+	firstID := historyNodeID("webui", "run the tests", 1)
+	fixID := historyNodeID("webui", "fix the build", 1)
+	repeatID := historyNodeID("webui", "run the tests", 2)
+	for id, want := range map[string]string{firstID: "run the tests with -race", fixID: "fix the build", repeatID: "run the tests"} {
+		if node, err := db.GetNode(id); err != nil || node == nil || node.Content != want {
+			t.Errorf("expected %s to hold %q, got %+v (%v)", id, want, node, err)
+		}
+	}
+	var count int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM nodes").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("expected no entry to be imported twice, got %d nodes", count)
+	}
+
+	fix, _ := db.GetNode(fixID)
+	if fix == nil || !fix.Locked || len(fix.Tags) != 1 || fix.Tags[0] != "important" {
+		t.Errorf("expected lock and tags to follow the rename, got %+v", fix)
+	}
+	if curated, err := db.GetNodesForFolder("f1"); err != nil || curated[repeatID] == nil {
+		t.Errorf("expected the moved node to be renamed in place, got %v (%v)", curated, err)
+	}
+	if note, _ := db.GetNode("note"); note == nil || note.ParentID != fixID {
+		t.Errorf("expected child to follow its parent's rename, got %+v", note)
+	}
+	if old, _ := db.GetNode("webui_2"); old != nil {
+		t.Error("expected the line-numbered ID to be gone")
+	}
+	if !fix.TimestampEstimated {
+		t.Error("expected legacy history timestamps to be flagged as estimated")
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// OpenCodePart mirrors a single file under storage/part/<messageID>.
+// OpenCode writes one file per part and the part type decides which
+// of the optional fields are populated.
+type OpenCodePart struct {
+	ID        string             `json:"id"`
+	SessionID string             `json:"sessionID"`
+	MessageID string             `json:"messageID"`
+	Type      string             `json:"type"`
+	Text      string             `json:"text"`
+	Synthetic bool               `json:"synthetic,omitempty"`
+	CallID    string             `json:"callID,omitempty"`
+	Tool      string             `json:"tool,omitempty"`
+	State     *OpenCodeToolState `json:"state,omitempty"`
+	Mime      string             `json:"mime,omitempty"`
+	Filename  string             `json:"filename,omitempty"`
+	URL       string             `json:"url,omitempty"`
+	Source    *struct {
+		Path string `json:"path"`
+	} `json:"source,omitempty"`
+	Hash     string   `json:"hash,omitempty"`
+	Files    []string `json:"files,omitempty"`
+	Snapshot string   `json:"snapshot,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	Time     *struct {
+		Start int64 `json:"start"`
+		End   int64 `json:"end"`
+	} `json:"time,omitempty"`
+}
+
+type OpenCodeToolState struct {
+	Status string          `json:"status"`
+	Input  json.RawMessage `json:"input,omitempty"`
+	Output string          `json:"output,omitempty"`
+	Title  string          `json:"title,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Time   *struct {
+		Start int64 `json:"start"`
+		End   int64 `json:"end"`
+	} `json:"time,omitempty"`
+	// Metadata is tool specific; a task call records the subagent
+	// session it spawned.
+	Metadata *struct {
+		SessionID string `json:"sessionId"`
+	} `json:"metadata,omitempty"`
+}
+
+// MessagePart is the stored, typed form of an OpenCode part. Position
+// keeps the order the parts were produced in within their message.
+type MessagePart struct {
+	ID        string   `json:"id"`
+	MessageID string   `json:"messageId"`
+	SessionID string   `json:"sessionId,omitempty"`
+	Position  int      `json:"position"`
+	Type      string   `json:"type"`
+	Text      string   `json:"text,omitempty"`
+	Tool      string   `json:"tool,omitempty"`
+	CallID    string   `json:"callId,omitempty"`
+	Status    string   `json:"status,omitempty"`
+	Title     string   `json:"title,omitempty"`
+	Input     string   `json:"input,omitempty"`
+	Output    string   `json:"output,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	FilePath  string   `json:"filePath,omitempty"`
+	Mime      string   `json:"mime,omitempty"`
+	Hash      string   `json:"hash,omitempty"`     // a patch part's snapshot hash
+	Files     []string `json:"files,omitempty"`    // the files a patch part touched
+	Snapshot  string   `json:"snapshot,omitempty"` // the snapshot a step or snapshot part was taken at
+	Reason    string   `json:"reason,omitempty"`   // why a step finished
+	StartedAt string   `json:"startedAt,omitempty"`
+	EndedAt   string   `json:"endedAt,omitempty"`
+
+	// ChildSessionID is the subagent session a task call spawned.
+	ChildSessionID string `json:"childSessionId,omitempty"`
+}
+
+func newMessagePart(ocPart *OpenCodePart, position int) *MessagePart {
+	part := &MessagePart{
+		ID:        ocPart.ID,
+		MessageID: ocPart.MessageID,
+		SessionID: ocPart.SessionID,
+		Position:  position,
+		Type:      ocPart.Type,
+		Text:      ocPart.Text,
+		Tool:      ocPart.Tool,
+		CallID:    ocPart.CallID,
+		Mime:      ocPart.Mime,
+	}
+
+	switch ocPart.Type {
+	case "file":
+		part.FilePath = ocPart.Filename
+		if ocPart.Source != nil && ocPart.Source.Path != "" {
+			part.FilePath = ocPart.Source.Path
+		} else if part.FilePath == "" {
+			part.FilePath = ocPart.URL
+		}
+	case "patch":
+		part.Hash = ocPart.Hash
+		part.Files = ocPart.Files
+	case "step-start", "step-finish", "snapshot":
+		part.Snapshot = ocPart.Snapshot
+		part.Reason = ocPart.Reason
+	}
+
+	if ocPart.Time != nil {
+		if ocPart.Time.Start > 0 {
+			part.StartedAt = formatTimestamp(ocPart.Time.Start)
+		}
+		if ocPart.Time.End > 0 {
+			part.EndedAt = formatTimestamp(ocPart.Time.End)
+		}
+	}
+
+	if state := ocPart.State; state != nil {
+		part.Status = state.Status
+		part.Title = state.Title
+		part.Output = state.Output
+		part.Error = state.Error
+		if state.Metadata != nil {
+			part.ChildSessionID = state.Metadata.SessionID
+		}
+		if len(state.Input) > 0 && string(state.Input) != "null" {
+			part.Input = string(state.Input)
+		}
+		if state.Time != nil {
+			if state.Time.Start > 0 {
+				part.StartedAt = formatTimestamp(state.Time.Start)
+			}
+			if state.Time.End > 0 {
+				part.EndedAt = formatTimestamp(state.Time.End)
+			}
+		}
+	}
+
+	return part
+}
+
+// readMessageParts loads every part of a message from storage/part in
+// the order OpenCode created them. Part IDs are time-ordered, so sorting
+// by file name is enough.
+func readMessageParts(partPath, messageID string) ([]*MessagePart, error) {
+	msgPartPath := filepath.Join(partPath, messageID)
+	partFiles, err := os.ReadDir(msgPartPath)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(partFiles))
+	for _, partFile := range partFiles {
+		if strings.HasSuffix(partFile.Name(), ".json") {
+			names = append(names, partFile.Name())
+		}
+	}
+	sort.Strings(names)
+
+	parts := make([]*MessagePart, 0, len(names))
+	for _, name := range names {
+		partData, err := os.ReadFile(filepath.Join(msgPartPath, name))
+		if err != nil {
+			continue
+		}
+
+		var ocPart OpenCodePart
+		if err := json.Unmarshal(partData, &ocPart); err != nil {
+			continue
+		}
+		if ocPart.ID == "" {
+			ocPart.ID = strings.TrimSuffix(name, ".json")
+		}
+		if ocPart.MessageID == "" {
+			ocPart.MessageID = messageID
+		}
+
+		parts = append(parts, newMessagePart(&ocPart, len(parts)))
+	}
+
+	return parts, nil
+}
+
+// partsContent builds the prose shown for a message: the text parts in
+// order, ignoring tool calls, reasoning and step markers.
+func partsContent(parts []*MessagePart) string {
+	var partContents []string
+	for _, part := range parts {
+		if part.Type == "text" && part.Text != "" {
+			partContents = append(partContents, part.Text)
+		}
+	}
+	return strings.Join(partContents, "\n")
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMessageParts(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	sm := NewSyncManager(store.db, store, t.TempDir(), nil)
+	sm.historySources = nil
+
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(sm.msgPath, "ses_p", "msg_1.json"), `{"id":"msg_1","sessionID":"ses_p","role":"assistant","time":{"created":1759309200000}}`)
+
+	started, ended := formatTimestamp(1759309201000), formatTimestamp(1759309202000)
+	cases := []struct {
+		name string
+		file string
+		want MessagePart
+		keys []string // the fields of the part in GET /api/messages/{id}?parts=true
+	}{
+		{
+			name: "step start",
+			file: `{"id":"prt_01","sessionID":"ses_p","messageID":"msg_1","type":"step-start","snapshot":"4b825dc"}`,
+			want: MessagePart{Type: "step-start", Snapshot: "4b825dc"},
+			keys: []string{"snapshot"},
+		},
+		{
+			name: "reasoning",
+			file: `{"id":"prt_02","sessionID":"ses_p","messageID":"msg_1","type":"reasoning","text":"The lexer moved.","time":{"start":1759309201000,"end":1759309202000}}`,
+			want: MessagePart{Type: "reasoning", Text: "The lexer moved.", StartedAt: started, EndedAt: ended},
+			keys: []string{"text", "startedAt", "endedAt"},
+		},
+		{
+			name: "tool",
+			file: `{"id":"prt_03","sessionID":"ses_p","messageID":"msg_1","type":"tool","tool":"bash","callID":"call_1","state":{"status":"error","input":{"command":"go test"},"title":"go test","error":"exit status 1","time":{"start":1759309201000,"end":1759309202000}}}`,
+			want: MessagePart{Type: "tool", Tool: "bash", CallID: "call_1", Status: "error", Title: "go test", Input: `{"command":"go test"}`, Error: "exit status 1", StartedAt: started, EndedAt: ended},
+			keys: []string{"tool", "callId", "status", "title", "input", "error", "startedAt", "endedAt"},
+		},
+		{
+			name: "tool without input",
+			file: `{"id":"prt_04","sessionID":"ses_p","messageID":"msg_1","type":"tool","tool":"todoread","callID":"call_2","state":{"status":"completed","input":null,"output":"[]"}}`,
+			want: MessagePart{Type: "tool", Tool: "todoread", CallID: "call_2", Status: "completed", Output: "[]"},
+			keys: []string{"tool", "callId", "status", "output"},
+		},
+		{
+			name: "file with a source",
+			file: `{"id":"prt_05","sessionID":"ses_p","messageID":"msg_1","type":"file","mime":"text/plain","filename":"lexer.go","url":"file:///src/lexer.go","source":{"path":"src/lexer.go"}}`,
+			want: MessagePart{Type: "file", Mime: "text/plain", FilePath: "src/lexer.go"},
+			keys: []string{"mime", "filePath"},
+		},
+		{
+			name: "file by url",
+			file: `{"id":"prt_06","sessionID":"ses_p","messageID":"msg_1","type":"file","mime":"image/png","url":"data:image/png;base64,AA=="}`,
+			want: MessagePart{Type: "file", Mime: "image/png", FilePath: "data:image/png;base64,AA=="},
+			keys: []string{"mime", "filePath"},
+		},
+		{
+			name: "patch",
+			file: `{"id":"prt_07","sessionID":"ses_p","messageID":"msg_1","type":"patch","hash":"9f2c1e0","files":["src/lexer.go","src/parser.go"]}`,
+			want: MessagePart{Type: "patch", Hash: "9f2c1e0", Files: []string{"src/lexer.go", "src/parser.go"}},
+			keys: []string{"hash", "files"},
+		},
+		{
+			name: "text",
+			file: `{"id":"prt_08","sessionID":"ses_p","messageID":"msg_1","type":"text","text":"lex was renamed."}`,
+			want: MessagePart{Type: "text", Text: "lex was renamed."},
+			keys: []string{"text"},
+		},
+		{
+			name: "snapshot",
+			file: `{"id":"prt_09","sessionID":"ses_p","messageID":"msg_1","type":"snapshot","snapshot":"a1b2c3d"}`,
+			want: MessagePart{Type: "snapshot", Snapshot: "a1b2c3d"},
+			keys: []string{"snapshot"},
+		},
+		{
+			name: "step finish",
+			file: `{"id":"prt_10","sessionID":"ses_p","messageID":"msg_1","type":"step-finish","snapshot":"a1b2c3d","reason":"tool-calls"}`,
+			want: MessagePart{Type: "step-finish", Snapshot: "a1b2c3d", Reason: "tool-calls"},
+			keys: []string{"snapshot", "reason"},
+		},
+		{
+			name: "without ids",
+			file: `{"type":"text","text":"Named after its file."}`,
+			want: MessagePart{Type: "text", Text: "Named after its file."},
+			keys: []string{"text"},
+		},
+	}
+	for i := range cases {
+		// Part files are read in the order of their names.
+		id := fmt.Sprintf("prt_%02d", i+1)
+		var ids struct {
+			SessionID string `json:"sessionID"`
+		}
+		if err := json.Unmarshal([]byte(cases[i].file), &ids); err != nil {
+			t.Fatal(err)
+		}
+		want := &cases[i].want
+		want.ID, want.MessageID, want.SessionID, want.Position = id, "msg_1", ids.SessionID, i
+		write(filepath.Join(sm.partPath, "msg_1", id+".json"), cases[i].file)
+	}
+	write(filepath.Join(sm.partPath, "msg_1", "notes.txt"), "not a part")
+
+	check := func(source string, parts []*MessagePart) {
+		t.Helper()
+		if len(parts) != len(cases) {
+			t.Fatalf("%s: got %d parts, want %d", source, len(parts), len(cases))
+		}
+		for i, c := range cases {
+			if !reflect.DeepEqual(*parts[i], c.want) {
+				t.Errorf("%s: %s: got %+v, want %+v", source, c.name, *parts[i], c.want)
+			}
+		}
+	}
+
+	parts, err := readMessageParts(sm.partPath, "msg_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("readMessageParts", parts)
+
+	syncAndHydrate(sm)
+	if err := store.db.ReplaceParts("msg_1", parts); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.db.GetParts("msg_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("GetParts", stored)
+
+	// What GET /api/messages/{id}?parts=true responds with.
+	node := store.loadMessageContent("msg_1")
+	if node == nil {
+		t.Fatal("expected msg_1 to load")
+	}
+	if node.Content != "lex was renamed.\nNamed after its file." {
+		t.Errorf("got content %q", node.Content)
+	}
+	served, err := store.getMessageParts("msg_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(MessageDetail{MessageNode: node, Parts: served})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var detail struct {
+		Parts []map[string]any `json:"parts"`
+	}
+	if err := json.Unmarshal(data, &detail); err != nil {
+		t.Fatal(err)
+	}
+	if len(detail.Parts) != len(cases) {
+		t.Fatalf("got %d parts in the response, want %d", len(detail.Parts), len(cases))
+	}
+	for i, c := range cases {
+		want := append([]string{"id", "messageId", "position", "type"}, c.keys...)
+		if c.want.SessionID != "" {
+			want = append(want, "sessionId")
+		}
+		var got []string
+		for key := range detail.Parts[i] {
+			got = append(got, key)
+		}
+		sort.Strings(want)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got fields %v, want %v", c.name, got, want)
+		}
+	}
+}
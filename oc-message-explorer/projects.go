@@ -0,0 +1,364 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	apperrors "oc-message-explorer/internal/errors"
+)
+
+// globalProjectID is the project OpenCode files sessions under when they
+// were started outside a repository. Its worktree is "/".
+const globalProjectID = "global"
+
+// Project groups sessions by the repository or directory they ran in:
+// an OpenCode project, or one made up for the working directory of an
+// imported session log.
+type Project struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Worktree  string `json:"worktree"`
+	VCS       string `json:"vcs,omitempty"`
+	Source    string `json:"source"` // "opencode", or the importer that found the directory
+	CreatedAt string `json:"createdAt,omitempty"`
+
+	// Filled in when listed.
+	SessionCount   int                 `json:"sessionCount"`
+	MessageCount   int                 `json:"messageCount"`
+	LastActivityAt string              `json:"lastActivityAt,omitempty"`
+	Directories    []*ProjectDirectory `json:"directories"`
+}
+
+// ProjectDirectory is a working directory sessions of a project ran in,
+// such as a package inside the repository.
+type ProjectDirectory struct {
+	Directory    string `json:"directory"`
+	SessionCount int    `json:"sessionCount"`
+}
+
+// ProjectDetail is returned by GET /api/projects/{id}.
+type ProjectDetail struct {
+	*Project
+	Sessions []*Session `json:"sessions"`
+}
+
+// OpenCodeProject is a storage/project/<project>.json file.
+type OpenCodeProject struct {
+	ID       string `json:"id"`
+	Worktree string `json:"worktree"`
+	VCS      string `json:"vcs"`
+	Time     struct {
+		Created int64 `json:"created"`
+	} `json:"time"`
+}
+
+func parseOpenCodeProject(data []byte) (*Project, error) {
+	var oc OpenCodeProject
+	if err := json.Unmarshal(data, &oc); err != nil {
+		return nil, err
+	}
+	if oc.ID == "" {
+		return nil, fmt.Errorf("project has no id")
+	}
+
+	project := &Project{
+		ID:       oc.ID,
+		Name:     projectName(oc.ID, oc.Worktree),
+		Worktree: oc.Worktree,
+		VCS:      oc.VCS,
+		Source:   "opencode",
+	}
+	if oc.Time.Created > 0 {
+		project.CreatedAt = formatTimestamp(oc.Time.Created)
+	}
+	return project, nil
+}
+
+// projectName names a project after the last element of its worktree.
+func projectName(id, worktree string) string {
+	if id == globalProjectID || worktree == "" || worktree == "/" {
+		return "Global"
+	}
+	return filepath.Base(worktree)
+}
+
+// directoryProjectID identifies the project made up for a working
+// directory no OpenCode project covers.
+func directoryProjectID(directory string) string {
+	return "dir_" + hashBytes([]byte(directory))[:12]
+}
+
+func upsertProjectTx(tx *sql.Tx, project *Project) error {
+	_, err := tx.Exec(`
+		INSERT INTO projects (id, name, worktree, vcs, source, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			name = excluded.name, worktree = excluded.worktree, vcs = excluded.vcs,
+			source = excluded.source, created_at = excluded.created_at`,
+		project.ID, project.Name, project.Worktree, project.VCS, project.Source, project.CreatedAt,
+	)
+	return err
+}
+
+// resolveProjectTx returns the project a session that ran in directory
+// belongs to: the OpenCode project with the deepest worktree holding it,
+// or else one made up for the directory itself.
+func resolveProjectTx(tx *sql.Tx, directory, source string) (string, error) {
+	if directory == "" {
+		return "", nil
+	}
+
+	rows, err := tx.Query("SELECT id, worktree FROM projects WHERE source = 'opencode' AND worktree NOT IN ('', '/')")
+	if err != nil {
+		return "", err
+	}
+	best, bestLen := "", 0
+	for rows.Next() {
+		var id, worktree string
+		if err := rows.Scan(&id, &worktree); err != nil {
+			rows.Close()
+			return "", err
+		}
+		if within(directory, worktree) && len(worktree) > bestLen {
+			best, bestLen = id, len(worktree)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if best != "" {
+		return best, nil
+	}
+
+	id := directoryProjectID(directory)
+	_, err = tx.Exec(
+		"INSERT OR IGNORE INTO projects (id, name, worktree, source) VALUES (?, ?, ?, ?)",
+		id, filepath.Base(directory), directory, source,
+	)
+	return id, err
+}
+
+// within reports whether path is dir or lies under it.
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// WriteProjects stores OpenCode projects along with the checkpoints of the
+// files they were read from.
+func (d *Database) WriteProjects(projects []*Project, checkpoints []SyncCheckpoint) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		for _, project := range projects {
+			if err := upsertProjectTx(tx, project); err != nil {
+				return fmt.Errorf("project %s: %w", project.ID, err)
+			}
+		}
+		return saveCheckpointsTx(tx, checkpoints)
+	})
+}
+
+// projectListQuery lists projects with counts taken from their sessions
+// and messages.
+const projectListQuery = `
+	SELECT p.id, p.name, p.worktree, p.vcs, p.source, p.created_at,
+	       COUNT(DISTINCT s.id), COUNT(n.id), COALESCE(MAX(n.timestamp), '')
+	FROM projects p
+	LEFT JOIN sessions s ON s.project_id = p.id
+	LEFT JOIN nodes n ON n.session_id = s.id
+	%s
+	GROUP BY p.id
+	ORDER BY MAX(n.timestamp) IS NULL, MAX(n.timestamp) DESC, p.name`
+
+// ListProjects lists projects, most recently active first, with the
+// working directories their sessions ran in.
+func (d *Database) ListProjects() ([]*Project, error) {
+	return d.listProjects("")
+}
+
+// GetProject returns a project and its sessions, or nil if it is unknown.
+func (d *Database) GetProject(id string) (*ProjectDetail, error) {
+	projects, err := d.listProjects(id)
+	if err != nil || len(projects) == 0 {
+		return nil, err
+	}
+	sessions, err := d.ListSessions(SessionFilter{ProjectID: id})
+	if err != nil {
+		return nil, err
+	}
+	return &ProjectDetail{Project: projects[0], Sessions: sessions}, nil
+}
+
+func (d *Database) listProjects(id string) ([]*Project, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	where, args := "", []any{}
+	if id != "" {
+		where, args = "WHERE p.id = ?", []any{id}
+	}
+	rows, err := d.db.Query(fmt.Sprintf(projectListQuery, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	projects := []*Project{}
+	byID := make(map[string]*Project)
+	for rows.Next() {
+		project := &Project{Directories: []*ProjectDirectory{}}
+		if err := rows.Scan(&project.ID, &project.Name, &project.Worktree, &project.VCS, &project.Source, &project.CreatedAt,
+			&project.SessionCount, &project.MessageCount, &project.LastActivityAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		projects = append(projects, project)
+		byID[project.ID] = project
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	where = ""
+	if id != "" {
+		where = "AND project_id = ?"
+	}
+	rows, err = d.db.Query(`
+		SELECT project_id, directory, COUNT(*) FROM sessions
+		WHERE directory != '' `+where+`
+		GROUP BY project_id, directory
+		ORDER BY COUNT(*) DESC, directory`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var projectID string
+		dir := &ProjectDirectory{}
+		if err := rows.Scan(&projectID, &dir.Directory, &dir.SessionCount); err != nil {
+			return nil, err
+		}
+		if project := byID[projectID]; project != nil {
+			project.Directories = append(project.Directories, dir)
+		}
+	}
+	return projects, rows.Err()
+}
+
+// ProjectSessionIDs returns the IDs of the sessions a project holds.
+func (d *Database) ProjectSessionIDs(projectID string) (map[string]bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	rows, err := d.db.Query("SELECT id FROM sessions WHERE project_id = ?", projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// projectFiles lists the project files in storage/project.
+func projectFiles(projectPath string) ([]string, error) {
+	entries, err := os.ReadDir(projectPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			files = append(files, filepath.Join(projectPath, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// syncProjectInfo reads the project files that changed since their
+// checkpoints into the projects table.
+func (sm *SyncManager) syncProjectInfo() error {
+	files, err := projectFiles(sm.projectPath)
+	if err != nil {
+		return err
+	}
+	checkpoints, err := sm.db.GetSyncCheckpoints(sm.projectPath)
+	if err != nil {
+		return err
+	}
+
+	projects, changed := readProjectFiles(files, checkpoints)
+	if err := sm.db.WriteProjects(projects, changed); err != nil {
+		return err
+	}
+	log.Printf("Read %d changed projects; %d unchanged", len(projects), len(files)-len(projects))
+	return nil
+}
+
+// readProjectFiles parses the project files that differ from their
+// checkpoints, returning them with the checkpoints to save once written.
+func readProjectFiles(files []string, previous map[string]SyncCheckpoint) ([]*Project, []SyncCheckpoint) {
+	var projects []*Project
+	checkpoints := readChangedFiles(files, previous, "project", func(data []byte) error {
+		project, err := parseOpenCodeProject(data)
+		if err == nil {
+			projects = append(projects, project)
+		}
+		return err
+	})
+	return projects, checkpoints
+}
+
+// ListProjects lists projects for /api/projects. Without a database there
+// are no projects to list.
+func (s *Store) ListProjects() ([]*Project, error) {
+	if s.db == nil {
+		return []*Project{}, nil
+	}
+	projects, err := s.db.ListProjects()
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("failed to list projects", err)
+	}
+	return projects, nil
+}
+
+// GetProject returns a project for /api/projects/{id}.
+func (s *Store) GetProject(id string) (*ProjectDetail, error) {
+	if s.db == nil {
+		return nil, apperrors.NewNotFoundError("Project not found", nil)
+	}
+	project, err := s.db.GetProject(id)
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("failed to load project", err)
+	}
+	if project == nil {
+		return nil, apperrors.NewNotFoundError("Project not found", nil)
+	}
+	return project, nil
+}
+
+// ProjectSessionIDs returns the sessions of a project, for filtering
+// listings by it.
+func (s *Store) ProjectSessionIDs(projectID string) (map[string]bool, error) {
+	if s.db == nil {
+		return map[string]bool{}, nil
+	}
+	ids, err := s.db.ProjectSessionIDs(projectID)
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("failed to load project sessions", err)
+	}
+	return ids, nil
+}
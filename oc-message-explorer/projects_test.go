@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProjects(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	db := store.db
+	sm := NewSyncManager(db, store, t.TempDir(), nil)
+
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(sm.projectPath, "prj_src.json"), `{"id":"prj_src","worktree":"/src","vcs":"git","time":{"created":1759309200000}}`)
+	write(filepath.Join(sm.projectPath, "global.json"), `{"id":"global","worktree":"/","time":{"created":1759309200000}}`)
+	write(filepath.Join(sm.sessionPath, "prj_src", "ses_a.json"), `{"id":"ses_a","projectID":"prj_src","directory":"/src","title":"Tidy up","time":{"created":1759309200000,"updated":1759309300000}}`)
+	write(filepath.Join(sm.sessionPath, "global", "ses_g.json"), `{"id":"ses_g","projectID":"global","directory":"/tmp","title":"Scratch","time":{"created":1759309200000,"updated":1759309300000}}`)
+
+	if err := sm.syncProjectInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.syncSessionInfo(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An imported session joins the OpenCode project holding its
+	// directory; one without a directory stays out of every project.
+	source := HistorySource{Path: copyTestdata(t, "codex"), FolderID: "codex", Name: "Codex", Color: "#10a37f"}
+	if err := sm.syncHistorySource(source); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertNode("openchat", &MessageNode{ID: "msg_g", Type: "user", Content: "hi", Timestamp: "2025-10-01T08:00:00Z", SessionID: "ses_g"}); err != nil {
+		t.Fatal(err)
+	}
+
+	projects, err := db.ListProjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 2 || projects[0].ID != "prj_src" {
+		t.Fatalf("expected prj_src then global, got %+v", projects)
+	}
+	src := projects[0]
+	if src.Name != "src" || src.VCS != "git" || src.SessionCount != 2 || src.MessageCount != 4 || src.LastActivityAt == "" {
+		t.Errorf("unexpected project %+v", src)
+	}
+	if len(src.Directories) != 2 || src.Directories[0].Directory != "/src" || src.Directories[1].Directory != "/src/parser" {
+		t.Errorf("expected both working directories, got %+v", src.Directories)
+	}
+	if global := projects[1]; global.Name != "Global" || global.SessionCount != 1 || global.MessageCount != 1 {
+		t.Errorf("unexpected global project %+v", global)
+	}
+
+	detail, err := store.GetProject("prj_src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(detail.Sessions) != 2 {
+		t.Errorf("expected the project's sessions, got %+v", detail.Sessions)
+	}
+	if _, err := store.GetProject("missing"); err == nil {
+		t.Error("expected an unknown project to be an error")
+	}
+	if ids, err := store.ProjectSessionIDs("prj_src"); err != nil || !ids["ses_a"] || !ids["0199a1b2-c3d4"] || ids["ses_g"] {
+		t.Errorf("unexpected project sessions %v (%v)", ids, err)
+	}
+	if legacy, err := db.GetSession("5f0c1e2d-legacy"); err != nil || legacy.ProjectID != "" {
+		t.Errorf("expected a session without a directory to have no project, got %+v (%v)", legacy, err)
+	}
+}
+
+func TestDirectoryProjects(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	sm := NewSyncManager(store.db, store, t.TempDir(), nil)
+
+	// Without an OpenCode project, the working directory is the project.
+	source := HistorySource{Path: copyTestdata(t, "codex"), FolderID: "codex", Name: "Codex", Color: "#10a37f"}
+	if err := sm.syncHistorySource(source); err != nil {
+		t.Fatal(err)
+	}
+	session, err := store.db.GetSession("0199a1b2-c3d4")
+	if err != nil || session == nil {
+		t.Fatalf("expected the session: %v", err)
+	}
+	if session.ProjectID != directoryProjectID("/src/parser") {
+		t.Errorf("unexpected project %q", session.ProjectID)
+	}
+	project, err := store.db.GetProject(session.ProjectID)
+	if err != nil || project == nil {
+		t.Fatalf("expected the project: %v", err)
+	}
+	if project.Name != "parser" || project.Worktree != "/src/parser" || project.Source != "codex" || project.MessageCount != 4 {
+		t.Errorf("unexpected project %+v", project.Project)
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	apperrors "oc-message-explorer/internal/errors"
+)
+
+// SearchQuery is a parsed /api/search query. Groups are ANDed together;
+// the terms inside a group were joined with OR.
+//
+//	type:response tag:build "exact phrase" -draft before:2026-01-01 foo OR bar
+type SearchQuery struct {
+	Groups [][]queryTerm
+}
+
+type queryTerm struct {
+	Field   string // empty for free text
+	Value   string
+	Phrase  bool
+	Negated bool
+}
+
+type queryFieldCompiler func(value string) (string, []any, error)
+
+// queryFields maps each filter name to the SQL it compiles to. Conditions
+// are written against the nodes table aliased as n.
+var queryFields = map[string]queryFieldCompiler{
+	"type": func(value string) (string, []any, error) {
+		return "n.type = ? COLLATE NOCASE", []any{value}, nil
+	},
+	"tag": func(value string) (string, []any, error) {
+		return "n.id IN (SELECT node_id FROM tags WHERE tag = ? COLLATE NOCASE)", []any{value}, nil
+	},
+	"agent": func(value string) (string, []any, error) {
+		return "(n.agent = ? COLLATE NOCASE OR n.id IN (SELECT node_id FROM tags WHERE tag = ? COLLATE NOCASE))", []any{value, value}, nil
+	},
+	"model": func(value string) (string, []any, error) {
+		// OpenCode writes models as provider/model; the model part
+		// matches by prefix, so dated releases match their family.
+		if provider, model, ok := strings.Cut(value, "/"); ok {
+			return `(n.provider_id = ? COLLATE NOCASE AND n.model_id LIKE ? ESCAPE '\')`,
+				[]any{provider, escapeLike(model) + "%"}, nil
+		}
+		return `n.model_id LIKE ? ESCAPE '\'`, []any{escapeLike(value) + "%"}, nil
+	},
+	"provider": func(value string) (string, []any, error) {
+		return "n.provider_id = ? COLLATE NOCASE", []any{value}, nil
+	},
+	"cost": func(value string) (string, []any, error) {
+		op, amount, err := parseComparison(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("cost %w", err)
+		}
+		return "n.cost " + op + " ?", []any{amount}, nil
+	},
+	"tokens": func(value string) (string, []any, error) {
+		op, count, err := parseComparison(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("tokens %w", err)
+		}
+		return "(n.tokens_input + n.tokens_output + n.tokens_reasoning) " + op + " ?", []any{count}, nil
+	},
+	"session": func(value string) (string, []any, error) {
+		return `(n.session_id LIKE ? ESCAPE '\' OR n.session_id IN (SELECT id FROM sessions WHERE title LIKE ? ESCAPE '\'))`,
+			[]any{escapeLike(value) + "%", "%" + escapeLike(value) + "%"}, nil
+	},
+	"project": func(value string) (string, []any, error) {
+		// A project by ID or name, or sessions that ran in a directory,
+		// given as its path or its last element.
+		return `n.session_id IN (SELECT s.id FROM sessions s LEFT JOIN projects p ON p.id = s.project_id
+			WHERE p.id = ? OR p.name = ? COLLATE NOCASE OR p.worktree = ?
+			   OR s.directory = ? OR s.directory LIKE ? ESCAPE '\')`,
+			[]any{value, value, value, value, "%/" + escapeLike(value)}, nil
+	},
+	"error": func(value string) (string, []any, error) {
+		// true or false, or a kind of error such as aborted or api.
+		if failed, err := strconv.ParseBool(value); err == nil {
+			if failed {
+				return "n.error_kind != ''", nil, nil
+			}
+			return "n.error_kind = ''", nil, nil
+		}
+		return "(n.error_kind = ? COLLATE NOCASE OR n.error_name = ? COLLATE NOCASE)", []any{value, value}, nil
+	},
+	"completed": func(value string) (string, []any, error) {
+		completed, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("completed must be true or false, got %q", value)
+		}
+		if completed {
+			return "n.completed_at != ''", nil, nil
+		}
+		// Responses that never finished: still running, or stalled.
+		return "(n.type = 'response' AND n.completed_at = '' AND n.error_kind = '')", nil, nil
+	},
+	"latency": func(value string) (string, []any, error) {
+		op, ms, err := parseDurationComparison(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("latency %w", err)
+		}
+		return "(n.latency_ms > 0 AND n.latency_ms " + op + " ?)", []any{ms}, nil
+	},
+	"boundary": func(value string) (string, []any, error) {
+		// true or false, or compaction or summary.
+		if marked, err := strconv.ParseBool(value); err == nil {
+			if marked {
+				return "n.boundary != ''", nil, nil
+			}
+			return "n.boundary = ''", nil, nil
+		}
+		return "n.boundary = ? COLLATE NOCASE", []any{value}, nil
+	},
+	"compacted": func(value string) (string, []any, error) {
+		compacted, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("compacted must be true or false, got %q", value)
+		}
+		// Messages sent before their session's context last started
+		// over, which the model no longer saw.
+		condition := "COALESCE(julianday(n.timestamp) < julianday(" + compactionCutoff("n.session_id") + "), 0)"
+		if compacted {
+			return condition, nil, nil
+		}
+		return "NOT (" + condition + ")", nil, nil
+	},
+	"folder": func(value string) (string, []any, error) {
+		return "n.folder_id IN (SELECT id FROM folders WHERE id = ? OR name = ? COLLATE NOCASE)", []any{value, value}, nil
+	},
+	"locked": func(value string) (string, []any, error) {
+		locked, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("locked must be true or false, got %q", value)
+		}
+		if locked {
+			return "n.locked = 1", nil, nil
+		}
+		return "n.locked = 0", nil, nil
+	},
+	"deleted": func(value string) (string, []any, error) {
+		deleted, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("deleted must be true or false, got %q", value)
+		}
+		if deleted {
+			return "n.deleted_upstream_at IS NOT NULL", nil, nil
+		}
+		return "n.deleted_upstream_at IS NULL", nil, nil
+	},
+	"before": func(value string) (string, []any, error) {
+		t, err := parseQueryDate(value)
+		if err != nil {
+			return "", nil, err
+		}
+		return "julianday(n.timestamp) < julianday(?)", []any{t.Format(time.RFC3339)}, nil
+	},
+	"after": func(value string) (string, []any, error) {
+		t, err := parseQueryDate(value)
+		if err != nil {
+			return "", nil, err
+		}
+		return "julianday(n.timestamp) >= julianday(?)", []any{t.Format(time.RFC3339)}, nil
+	},
+}
+
+// parseQueryDate accepts a day, a month or a full RFC3339 timestamp. Days
+// and months are interpreted in local time, like the UI shows them.
+func parseQueryDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q (use YYYY-MM-DD, YYYY-MM or RFC3339)", value)
+}
+
+// splitComparison splits a leading comparison off a filter value, taking
+// a bare value to mean at least that much.
+func splitComparison(value string) (string, string) {
+	for _, prefix := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, prefix) {
+			return prefix, strings.TrimPrefix(value, prefix)
+		}
+	}
+	return ">=", value
+}
+
+// parseComparison reads a number with an optional comparison in front,
+// such as ">0.5" or "<=1000". A bare number means at least that much.
+func parseComparison(value string) (string, float64, error) {
+	op, value := splitComparison(value)
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("must be a number such as >0.5 or <=1000, got %q", value)
+	}
+	return op, n, nil
+}
+
+// parseDurationComparison is parseComparison for durations such as
+// ">30s" or "<=2m", returned in milliseconds. A bare number is seconds.
+func parseDurationComparison(value string) (string, int64, error) {
+	op, value := splitComparison(value)
+	if d, err := time.ParseDuration(value); err == nil {
+		return op, d.Milliseconds(), nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return op, int64(seconds * 1000), nil
+	}
+	return "", 0, fmt.Errorf("must be a duration such as >30s or <=2m, got %q", value)
+}
+
+func escapeLike(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(value)
+}
+
+// ParseSearchQuery parses the search syntax. Unknown "name:" prefixes are
+// treated as plain text so ordinary prose containing colons still works.
+func ParseSearchQuery(input string) (*SearchQuery, error) {
+	tokens, err := tokenizeQuery(input)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &SearchQuery{}
+	joinNext := false
+	for i, tok := range tokens {
+		if tok.text == "OR" && !tok.quoted {
+			if i == 0 || joinNext {
+				return nil, apperrors.NewValidationError("OR must appear between two search terms", nil)
+			}
+			if i == len(tokens)-1 {
+				return nil, apperrors.NewValidationError("OR must be followed by a search term", nil)
+			}
+			joinNext = true
+			continue
+		}
+
+		term, err := parseQueryTerm(tok)
+		if err != nil {
+			return nil, err
+		}
+
+		if joinNext {
+			last := len(query.Groups) - 1
+			query.Groups[last] = append(query.Groups[last], term)
+			joinNext = false
+		} else {
+			query.Groups = append(query.Groups, []queryTerm{term})
+		}
+	}
+
+	return query, nil
+}
+
+type queryToken struct {
+	text     string
+	quoted   bool // the value part was quoted
+	negated  bool
+	fieldSep int // index of ':' in text, -1 when absent
+}
+
+func tokenizeQuery(input string) ([]queryToken, error) {
+	var tokens []queryToken
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		tok := queryToken{fieldSep: -1}
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			tok.negated = true
+			i++
+		}
+
+		var b strings.Builder
+		for i < len(runes) && !unicode.IsSpace(runes[i]) {
+			if runes[i] == '"' {
+				end := i + 1
+				for end < len(runes) && runes[end] != '"' {
+					end++
+				}
+				if end >= len(runes) {
+					return nil, apperrors.NewValidationError("unterminated quote in search query", nil)
+				}
+				b.WriteString(string(runes[i+1 : end]))
+				tok.quoted = true
+				i = end + 1
+				continue
+			}
+			if runes[i] == ':' && tok.fieldSep < 0 && !tok.quoted {
+				tok.fieldSep = len([]rune(b.String()))
+			}
+			b.WriteRune(runes[i])
+			i++
+		}
+
+		tok.text = b.String()
+		if tok.text == "" && !tok.quoted {
+			continue
+		}
+		tokens = append(tokens, tok)
+	}
+
+	return tokens, nil
+}
+
+func parseQueryTerm(tok queryToken) (queryTerm, error) {
+	term := queryTerm{Value: tok.text, Phrase: tok.quoted, Negated: tok.negated}
+
+	if tok.fieldSep > 0 {
+		runes := []rune(tok.text)
+		field := strings.ToLower(string(runes[:tok.fieldSep]))
+		if _, known := queryFields[field]; known {
+			term.Field = field
+			term.Value = string(runes[tok.fieldSep+1:])
+			if term.Value == "" {
+				return term, apperrors.NewValidationError(fmt.Sprintf("%s: needs a value", field), nil)
+			}
+		}
+	}
+
+	if term.Field == "" && strings.TrimSpace(term.Value) == "" {
+		return term, apperrors.NewValidationError("empty phrase in search query", nil)
+	}
+
+	return term, nil
+}
+
+// IsPlainText reports whether the query is just words, which is when the
+// fuzzy matcher may be used as a fallback.
+func (q *SearchQuery) IsPlainText() bool {
+	for _, group := range q.Groups {
+		if len(group) > 1 {
+			return false
+		}
+		for _, term := range group {
+			if term.Field != "" || term.Negated || term.Phrase {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compile returns a WHERE clause over nodes n, its arguments, and an FTS5
+// expression covering every positive text term for bm25 ranking.
+func (q *SearchQuery) compile(searchRaw bool) (string, []any, string, error) {
+	var conditions []string
+	var args []any
+	var rankTerms []string
+
+	for _, group := range q.Groups {
+		var alternatives []string
+		for _, term := range group {
+			condition, termArgs, err := term.compile(searchRaw)
+			if err != nil {
+				return "", nil, "", err
+			}
+			if condition == "" {
+				continue
+			}
+			if term.Negated {
+				condition = "NOT (" + condition + ")"
+			} else if term.Field == "" {
+				rankTerms = append(rankTerms, termArgs[0].(string))
+			}
+			alternatives = append(alternatives, condition)
+			args = append(args, termArgs...)
+		}
+
+		switch len(alternatives) {
+		case 0:
+		case 1:
+			conditions = append(conditions, alternatives[0])
+		default:
+			conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+		}
+	}
+
+	where := "1 = 1"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+
+	return where, args, strings.Join(rankTerms, " OR "), nil
+}
+
+func (t queryTerm) compile(searchRaw bool) (string, []any, error) {
+	if t.Field != "" {
+		condition, args, err := queryFields[t.Field](t.Value)
+		if err != nil {
+			return "", nil, apperrors.NewValidationError(err.Error(), nil)
+		}
+		return condition, args, nil
+	}
+
+	var expr string
+	if t.Phrase {
+		words := ftsWords(t.Value)
+		if len(words) == 0 {
+			return "", nil, nil
+		}
+		expr = `"` + strings.Join(words, " ") + `"`
+	} else {
+		expr = buildFTSQuery(t.Value, false)
+		if expr == "" {
+			return "", nil, nil
+		}
+		expr = "(" + expr + ")"
+	}
+	if searchRaw {
+		expr = "{content tags type} : " + expr
+	}
+
+	return "n.rowid IN (SELECT rowid FROM nodes_fts WHERE nodes_fts MATCH ?)", []any{expr}, nil
+}
+
+// highlightWords lists the words of every positive free-text term, used
+// to locate hits for snippets.
+func (q *SearchQuery) highlightWords() []string {
+	var words []string
+	for _, group := range q.Groups {
+		for _, term := range group {
+			if term.Field == "" && !term.Negated {
+				words = append(words, ftsWords(term.Value)...)
+			}
+		}
+	}
+	return words
+}
+
+// usesField reports whether any term filters on field.
+func (q *SearchQuery) usesField(field string) bool {
+	for _, group := range q.Groups {
+		for _, term := range group {
+			if term.Field == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterFields lists the filters a result satisfied by matching at all.
+func (q *SearchQuery) filterFields() []string {
+	var fields []string
+	for _, group := range q.Groups {
+		if len(group) != 1 {
+			continue
+		}
+		if term := group[0]; term.Field != "" && !term.Negated {
+			fields = append(fields, term.Field)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	apperrors "oc-message-explorer/internal/errors"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		groups int
+		check  func(t *testing.T, q *SearchQuery)
+	}{
+		{"plain words", "fix build", 2, func(t *testing.T, q *SearchQuery) {
+			if !q.IsPlainText() {
+				t.Error("expected plain text query")
+			}
+		}},
+		{"field filter", "type:response", 1, func(t *testing.T, q *SearchQuery) {
+			if term := q.Groups[0][0]; term.Field != "type" || term.Value != "response" {
+				t.Errorf("unexpected term %+v", term)
+			}
+		}},
+		{"quoted field value", `tag:"two words"`, 1, func(t *testing.T, q *SearchQuery) {
+			if term := q.Groups[0][0]; term.Field != "tag" || term.Value != "two words" {
+				t.Errorf("unexpected term %+v", term)
+			}
+		}},
+		{"phrase and negation", `"exact phrase" -draft`, 2, func(t *testing.T, q *SearchQuery) {
+			if !q.Groups[0][0].Phrase || !q.Groups[1][0].Negated {
+				t.Errorf("unexpected groups %+v", q.Groups)
+			}
+		}},
+		{"or joins neighbours", "a b OR c d", 3, func(t *testing.T, q *SearchQuery) {
+			if len(q.Groups[1]) != 2 {
+				t.Errorf("expected b OR c in one group, got %+v", q.Groups)
+			}
+		}},
+		{"unknown prefix is text", "note: remember", 2, func(t *testing.T, q *SearchQuery) {
+			if q.Groups[0][0].Field != "" {
+				t.Errorf("unexpected field %q", q.Groups[0][0].Field)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseSearchQuery(tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(q.Groups) != tt.groups {
+				t.Fatalf("expected %d groups, got %d (%+v)", tt.groups, len(q.Groups), q.Groups)
+			}
+			tt.check(t, q)
+		})
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	inputs := []string{
+		`"unterminated`,
+		"OR foo",
+		"foo OR",
+		"foo OR OR bar",
+		"tag:",
+		"locked:maybe",
+		"deleted:maybe",
+		"before:yesterday",
+		"cost:lots",
+		"tokens:>many",
+		"completed:maybe",
+		"latency:soon",
+		"compacted:maybe",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			q, err := ParseSearchQuery(input)
+			if err == nil {
+				_, _, _, err = q.compile(false)
+			}
+
+			var appErr *apperrors.AppError
+			if !errors.As(err, &appErr) || appErr.Type != apperrors.ErrorTypeValidation {
+				t.Fatalf("expected validation error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSearchNodesWithFilters(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.InsertFolder(&Folder{ID: "openchat", Name: "OpenChat History", Color: "#e94560", CreatedAt: "2026-01-01T00:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := []*MessageNode{
+		{ID: "a", Type: "user", Content: "fix the parser build", Timestamp: "2025-12-01T10:00:00Z", Tags: []string{"build", "user"}, SessionID: "ses_a"},
+		{ID: "b", Type: "response", Content: "the parser build is fixed", Timestamp: "2026-02-01T10:00:00Z", Tags: []string{"build", "assistant"}, Locked: true, SessionID: "ses_a",
+			MessageUsage: MessageUsage{ModelID: "claude-sonnet-4-20250514", ProviderID: "anthropic", Agent: "build", Cost: 0.2, Tokens: &TokenUsage{Input: 900, Output: 200}}},
+		{ID: "c", Type: "response", Content: "unrelated answer", Timestamp: "2026-03-01T10:00:00Z", Tags: []string{"plan", "assistant"}, SessionID: "ses_b",
+			MessageUsage: MessageUsage{ModelID: "gpt-5", ProviderID: "openai", Agent: "plan", Cost: 0.05, Tokens: &TokenUsage{Input: 300}}},
+	}
+	for _, node := range nodes {
+		if err := db.InsertNode("openchat", node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.WriteProjects([]*Project{{ID: "prj_1", Name: "parser", Worktree: "/src/parser", Source: "opencode"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WriteSessions([]*Session{
+		{ID: "ses_a", Title: "Fixing the build", ProjectID: "prj_1", Directory: "/src/parser/lexer", Source: "opencode"},
+		{ID: "ses_b", ProjectID: "global", Directory: "/tmp/scratch", Source: "opencode"},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"parser", []string{"a", "b"}},
+		{"type:response parser", []string{"b"}},
+		{"tag:plan OR locked:true", []string{"b", "c"}},
+		{"build -fixed", []string{"a"}},
+		{`"build is fixed"`, []string{"b"}},
+		{"after:2026-01-01 -tag:plan", []string{"b"}},
+		{"before:2026-01-01", []string{"a"}},
+		{"folder:openchat answer", []string{"c"}},
+		{"session:ses_b", []string{"c"}},
+		{`session:"the build"`, []string{"a", "b"}},
+		{"project:parser", []string{"a", "b"}},
+		{"project:prj_1 type:user", []string{"a"}},
+		{"project:lexer", []string{"a", "b"}},
+		{"project:/tmp/scratch", []string{"c"}},
+		{"-project:parser", []string{"c"}},
+		{"model:claude-sonnet-4", []string{"b"}},
+		{"model:anthropic/claude", []string{"b"}},
+		{"model:openai/claude", nil},
+		{"provider:OpenAI", []string{"c"}},
+		{"agent:build", []string{"a", "b"}},
+		{"cost:>0.1", []string{"b"}},
+		{"cost:<=0.1 type:response", []string{"c"}},
+		{"tokens:1000", []string{"b"}},
+		{"unrelatd", []string{"c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			response, err := db.SearchNodes(SearchRequest{Query: tt.query})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Total != len(tt.want) {
+				t.Errorf("total %d, want %d", response.Total, len(tt.want))
+			}
+			var got []string
+			for _, result := range response.Results {
+				got = append(got, result.Node.ID)
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchNodesRankedPage(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.InsertFolder(&Folder{ID: "openchat", Name: "OpenChat History", Color: "#e94560", CreatedAt: "2026-01-01T00:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := []*MessageNode{
+		{ID: "once", Type: "user", Content: "a long message that mentions the parser only once among many other words", Timestamp: "2026-01-01T10:00:00Z"},
+		{ID: "often", Type: "user", Content: "parser <b>parser</b> parsers", Timestamp: "2026-01-02T10:00:00Z"},
+		{ID: "none", Type: "user", Content: "nothing here", Timestamp: "2026-01-03T10:00:00Z"},
+	}
+	for _, node := range nodes {
+		if err := db.InsertNode("openchat", node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	response, err := db.SearchNodes(SearchRequest{Query: "parser", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 2 || len(response.Results) != 1 {
+		t.Fatalf("expected 1 of 2 results, got %d of %d", len(response.Results), response.Total)
+	}
+
+	top := response.Results[0]
+	if top.Node.ID != "often" {
+		t.Fatalf("expected the denser match first, got %s", top.Node.ID)
+	}
+	if top.Node.Content != "" {
+		t.Error("expected content to be stripped from results")
+	}
+	if len(top.Matches) != 3 || top.Matches[1].Start != 10 || top.Matches[1].End != 16 {
+		t.Errorf("unexpected matches %+v", top.Matches)
+	}
+	want := "<mark>parser</mark> &lt;b&gt;<mark>parser</mark>&lt;/b&gt; <mark>parser</mark>s"
+	if len(top.Snippets) != 1 || top.Snippets[0].Text != want {
+		t.Errorf("unexpected snippets %+v", top.Snippets)
+	}
+
+	response, err = db.SearchNodes(SearchRequest{Query: "parser", Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Results) != 1 || response.Results[0].Node.ID != "once" {
+		t.Fatalf("unexpected second page %+v", response.Results)
+	}
+}
+
+func TestSearchMessagesWithoutDatabase(t *testing.T) {
+	store := &Store{Folders: map[string]*Folder{
+		"openchat": {ID: "openchat", Nodes: map[string]*MessageNode{
+			"a": {ID: "a", Type: "user", Content: "fix the parser", Timestamp: "2026-01-01T10:00:00Z"},
+			"b": {ID: "b", Type: "response", Content: "the parser is fixed", Timestamp: "2026-01-01T11:00:00Z"},
+		}},
+	}}
+
+	response, err := store.searchMessages(SearchRequest{Query: "parser"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 2 {
+		t.Errorf("expected plain words to match both nodes, got %d", response.Total)
+	}
+
+	for _, query := range []string{"type:response parser", `"the parser"`, "parser -fixed", "parser OR lexer"} {
+		_, err := store.searchMessages(SearchRequest{Query: query})
+		var appErr *apperrors.AppError
+		if !errors.As(err, &appErr) || appErr.Type != apperrors.ErrorTypeValidation {
+			t.Errorf("%s: expected a validation error, got %v", query, err)
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// nodes_fts shares its rowid with nodes, so index maintenance is a keyed
+// delete/insert instead of a scan. Column weights for bm25 follow the
+// order content, summary, tags, type; a summary is a message's title, so
+// a hit there counts for most.
+const (
+	ftsRankExpr      = "bm25(nodes_fts, 5.0, 10.0, 3.0, 1.0)"
+	searchChunkSize  = 500
+	searchMaxResults = 1000
+
+	searchDefaultLimit = 50
+	snippetRadius      = 60
+	snippetMaxPerField = 3
+)
+
+func deindexNodeTx(tx *sql.Tx, nodeID string) error {
+	_, err := tx.Exec("DELETE FROM nodes_fts WHERE rowid = (SELECT rowid FROM nodes WHERE id = ?)", nodeID)
+	return err
+}
+
+func indexNodeTx(tx *sql.Tx, nodeID string) error {
+	_, err := tx.Exec(`
+		INSERT INTO nodes_fts (rowid, content, summary, tags, type)
+		SELECT n.rowid, COALESCE(n.content, ''), COALESCE(n.summary, ''),
+		       COALESCE((SELECT group_concat(tag, ' ') FROM tags t WHERE t.node_id = n.id), ''), n.type
+		FROM nodes n
+		WHERE n.id = ?
+	`, nodeID)
+	return err
+}
+
+// ensureSearchIndex rebuilds nodes_fts when it is out of step with nodes,
+// which happens the first time an existing database is opened.
+func (d *Database) ensureSearchIndex() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var nodeCount, indexCount int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM nodes").Scan(&nodeCount); err != nil {
+		return err
+	}
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM nodes_fts").Scan(&indexCount); err != nil {
+		return err
+	}
+	if nodeCount == indexCount {
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM nodes_fts"); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO nodes_fts (rowid, content, summary, tags, type)
+		SELECT n.rowid, COALESCE(n.content, ''), COALESCE(n.summary, ''),
+		       COALESCE((SELECT group_concat(tag, ' ') FROM tags t WHERE t.node_id = n.id), ''), n.type
+		FROM nodes n
+	`)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func ftsWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// buildFTSQuery turns free text into an FTS5 expression: every word must
+// appear, each matched as a prefix. Raw searches skip AI summaries.
+func buildFTSQuery(query string, searchRaw bool) string {
+	words := ftsWords(query)
+	if len(words) == 0 {
+		return ""
+	}
+
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = `"` + word + `"*`
+	}
+
+	expr := strings.Join(terms, " ")
+	if searchRaw {
+		return "{content tags type} : (" + expr + ")"
+	}
+	return expr
+}
+
+// SearchRequest is the /api/search request body.
+type SearchRequest struct {
+	Query     string `json:"query"`
+	SearchRaw bool   `json:"searchRaw"`
+	Offset    int    `json:"offset"`
+	Limit     int    `json:"limit"`
+
+	// IncludeDeleted also returns nodes deleted upstream. A deleted:
+	// filter in the query has the same effect.
+	IncludeDeleted bool `json:"includeDeleted"`
+}
+
+func (r *SearchRequest) normalize() {
+	if r.Offset < 0 {
+		r.Offset = 0
+	}
+	if r.Limit <= 0 {
+		r.Limit = searchDefaultLimit
+	}
+	if r.Limit > searchMaxResults {
+		r.Limit = searchMaxResults
+	}
+}
+
+// SearchResponse is one page of ranked results. Total counts every match,
+// not just the ones on this page.
+type SearchResponse struct {
+	Query   string          `json:"query"`
+	Results []*SearchResult `json:"results"`
+	Total   int             `json:"total"`
+	Offset  int             `json:"offset"`
+	Limit   int             `json:"limit"`
+}
+
+// SearchResult describes why a node matched. The node is returned without
+// its content; snippets carry the relevant excerpts instead.
+type SearchResult struct {
+	Node     *MessageNode    `json:"node"`
+	Score    float64         `json:"score"`
+	Matched  []string        `json:"matched"`
+	Matches  []SearchMatch   `json:"matches,omitempty"`
+	Snippets []SearchSnippet `json:"snippets,omitempty"`
+}
+
+// SearchMatch is a hit inside a field, as rune offsets [Start, End).
+type SearchMatch struct {
+	Field string `json:"field"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// SearchSnippet is an HTML-escaped excerpt with hits wrapped in <mark>.
+type SearchSnippet struct {
+	Field string `json:"field"`
+	Text  string `json:"text"`
+}
+
+func (d *Database) SearchNodes(req SearchRequest) (*SearchResponse, error) {
+	req.normalize()
+
+	parsed, err := ParseSearchQuery(req.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args, rankMatch, err := parsed.compile(req.SearchRaw)
+	if err != nil {
+		return nil, err
+	}
+	if !req.IncludeDeleted && !parsed.usesField("deleted") {
+		where = "n.deleted_upstream_at IS NULL AND (" + where + ")"
+	}
+
+	response := &SearchResponse{Query: req.Query, Offset: req.Offset, Limit: req.Limit, Results: []*SearchResult{}}
+	if len(parsed.Groups) == 0 {
+		return response, nil
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM nodes n WHERE "+where, args...).Scan(&response.Total); err != nil {
+		return nil, err
+	}
+
+	if response.Total > 0 {
+		response.Results, err = d.searchCompiled(where, args, rankMatch, req.Offset, req.Limit)
+		if err != nil {
+			return nil, err
+		}
+	} else if parsed.IsPlainText() {
+		matches, err := d.searchFuzzy(strings.ToLower(req.Query), req.SearchRaw, req.IncludeDeleted)
+		if err != nil {
+			return nil, err
+		}
+		response.Total = len(matches)
+		response.Results = pageResults(matches, req.Offset, req.Limit)
+		if err := d.attachChildren(resultNodes(response.Results)); err != nil {
+			return nil, err
+		}
+	}
+
+	annotateResults(response.Results, parsed, req.SearchRaw)
+	return response, nil
+}
+
+// searchCompiled runs one page of a compiled query. When the query has
+// free text the rows are ordered by bm25 rank, otherwise newest first.
+func (d *Database) searchCompiled(where string, args []any, rankMatch string, offset, limit int) ([]*SearchResult, error) {
+	var sqlQuery string
+	if rankMatch != "" {
+		sqlQuery = fmt.Sprintf(`
+			SELECT %s, COALESCE(r.rank, 0)
+			FROM nodes n
+			LEFT JOIN (
+				SELECT rowid AS fts_rowid, %s AS rank FROM nodes_fts WHERE nodes_fts MATCH ?
+			) r ON r.fts_rowid = n.rowid
+			WHERE %s
+			ORDER BY r.rank IS NULL, r.rank, julianday(n.timestamp) DESC
+			LIMIT ? OFFSET ?
+		`, nodeSelectColumns, ftsRankExpr, where)
+		args = append([]any{rankMatch}, args...)
+	} else {
+		sqlQuery = fmt.Sprintf(`
+			SELECT %s, 0.0
+			FROM nodes n
+			WHERE %s
+			ORDER BY julianday(n.timestamp) DESC
+			LIMIT ? OFFSET ?
+		`, nodeSelectColumns, where)
+	}
+	args = append(args, limit, offset)
+
+	rows, err := d.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*SearchResult{}
+	for rows.Next() {
+		var rank float64
+		node, err := scanNode(rows, &rank)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		// bm25 is negative, lower is better; flip it so higher scores win.
+		results = append(results, &SearchResult{Node: node, Score: -rank, Matched: []string{}})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := d.attachTagsAndChildren(resultNodes(results)); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// searchFuzzy is the fallback when FTS finds nothing, typically for
+// misspellings. It scores every node with the subsequence matcher.
+func (d *Database) searchFuzzy(queryLower string, searchRaw, includeDeleted bool) ([]*SearchResult, error) {
+	if queryLower == "" {
+		return nil, nil
+	}
+
+	sqlQuery := fmt.Sprintf("SELECT %s FROM nodes n", nodeSelectColumns)
+	if !includeDeleted {
+		sqlQuery += " WHERE n.deleted_upstream_at IS NULL"
+	}
+	rows, err := d.db.Query(sqlQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*MessageNode
+	for rows.Next() {
+		node, err := scanNode(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := d.attachTags(nodes); err != nil {
+		return nil, err
+	}
+
+	return fuzzyResults(queryLower, nodes, searchRaw), nil
+}
+
+func fuzzyResults(queryLower string, nodes []*MessageNode, searchRaw bool) []*SearchResult {
+	var results []*SearchResult
+	for _, node := range nodes {
+		if score, matched := calculateMatchScore(queryLower, node, searchRaw); score > 0 {
+			results = append(results, &SearchResult{Node: node, Score: score, Matched: matched})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results
+}
+
+func pageResults(results []*SearchResult, offset, limit int) []*SearchResult {
+	if offset >= len(results) {
+		return []*SearchResult{}
+	}
+	end := offset + limit
+	if end > len(results) {
+		end = len(results)
+	}
+	return results[offset:end]
+}
+
+func resultNodes(results []*SearchResult) []*MessageNode {
+	nodes := make([]*MessageNode, len(results))
+	for i, result := range results {
+		nodes[i] = result.Node
+	}
+	return nodes
+}
+
+// annotateResults fills in match offsets, snippets and matched fields, then
+// drops the full content from the returned nodes.
+func annotateResults(results []*SearchResult, query *SearchQuery, searchRaw bool) {
+	words := query.highlightWords()
+	filters := query.filterFields()
+
+	for _, result := range results {
+		node := *result.Node
+		fields := []struct {
+			name string
+			text string
+		}{
+			{"content", node.Content},
+			{"summary", node.Summary},
+			{"tags", strings.Join(node.Tags, ", ")},
+			{"type", node.Type},
+		}
+
+		for _, field := range fields {
+			if searchRaw && field.name == "summary" {
+				continue
+			}
+			matches := findWordMatches(field.name, field.text, words)
+			if len(matches) == 0 {
+				continue
+			}
+			result.Matches = append(result.Matches, matches...)
+			result.Snippets = append(result.Snippets, buildSnippets(field.name, field.text, matches)...)
+			result.Matched = appendUnique(result.Matched, field.name)
+		}
+		for _, field := range filters {
+			result.Matched = appendUnique(result.Matched, field)
+		}
+
+		node.Content = ""
+		result.Node = &node
+	}
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// findWordMatches finds words that start with one of the query words,
+// mirroring FTS prefix matching, and reports them as rune offsets.
+func findWordMatches(field, text string, words []string) []SearchMatch {
+	if len(words) == 0 || text == "" {
+		return nil
+	}
+
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	var matches []SearchMatch
+	for i := 0; i < len(lower); i++ {
+		if i > 0 && isWordRune(lower[i-1]) {
+			continue
+		}
+		best := 0
+		for _, word := range words {
+			wordRunes := []rune(word)
+			if len(wordRunes) > best && i+len(wordRunes) <= len(lower) && string(lower[i:i+len(wordRunes)]) == word {
+				best = len(wordRunes)
+			}
+		}
+		if best > 0 {
+			matches = append(matches, SearchMatch{Field: field, Start: i, End: i + best})
+			i += best - 1
+		}
+	}
+
+	return matches
+}
+
+// buildSnippets cuts excerpts of snippetRadius runes around each hit,
+// merging hits that are close together, up to snippetMaxPerField.
+func buildSnippets(field, text string, matches []SearchMatch) []SearchSnippet {
+	runes := []rune(text)
+	var snippets []SearchSnippet
+
+	for i := 0; i < len(matches) && len(snippets) < snippetMaxPerField; {
+		start := matches[i].Start - snippetRadius
+		if start < 0 {
+			start = 0
+		}
+		end := matches[i].End + snippetRadius
+
+		j := i
+		for j+1 < len(matches) && matches[j+1].Start <= end {
+			j++
+			end = matches[j].End + snippetRadius
+		}
+		if end > len(runes) {
+			end = len(runes)
+		}
+
+		var b strings.Builder
+		if start > 0 {
+			b.WriteString("…")
+		}
+		pos := start
+		for _, match := range matches[i : j+1] {
+			b.WriteString(html.EscapeString(string(runes[pos:match.Start])))
+			b.WriteString("<mark>")
+			b.WriteString(html.EscapeString(string(runes[match.Start:match.End])))
+			b.WriteString("</mark>")
+			pos = match.End
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:end])))
+		if end < len(runes) {
+			b.WriteString("…")
+		}
+
+		snippets = append(snippets, SearchSnippet{Field: field, Text: b.String()})
+		i = j + 1
+	}
+
+	return snippets
+}
+
+func (d *Database) attachTagsAndChildren(nodes []*MessageNode) error {
+	if err := d.attachTags(nodes); err != nil {
+		return err
+	}
+	return d.attachChildren(nodes)
+}
+
+// attachTags loads tags for many nodes with one query per chunk instead
+// of one query per node.
+func (d *Database) attachTags(nodes []*MessageNode) error {
+	return d.forEachChunk(nodes, "SELECT node_id, tag FROM tags WHERE node_id IN (%s)", func(node *MessageNode, value string) {
+		node.Tags = append(node.Tags, value)
+	})
+}
+
+func (d *Database) attachChildren(nodes []*MessageNode) error {
+	return d.forEachChunk(nodes, "SELECT n.parent_id, n.id FROM nodes n WHERE n.parent_id IN (%s) ORDER BY "+nodeSiblingOrder, func(node *MessageNode, value string) {
+		node.Children = append(node.Children, value)
+	})
+}
+
+func (d *Database) forEachChunk(nodes []*MessageNode, query string, apply func(node *MessageNode, value string)) error {
+	byID := make(map[string]*MessageNode, len(nodes))
+	for _, node := range nodes {
+		byID[node.ID] = node
+	}
+
+	for start := 0; start < len(nodes); start += searchChunkSize {
+		end := start + searchChunkSize
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+
+		args := make([]any, 0, end-start)
+		for _, node := range nodes[start:end] {
+			args = append(args, node.ID)
+		}
+
+		rows, err := d.db.Query(fmt.Sprintf(query, placeholders(len(args))), args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var key, value string
+			if err := rows.Scan(&key, &value); err != nil {
+				rows.Close()
+				return err
+			}
+			if node, exists := byID[key]; exists {
+				apply(node, value)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// searchIDs returns the IDs of the nodes a query finds, best first.
+func searchIDs(t *testing.T, db *Database, query string) []string {
+	t.Helper()
+	response, err := db.SearchNodes(SearchRequest{Query: query})
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	var ids []string
+	for _, result := range response.Results {
+		ids = append(ids, result.Node.ID)
+	}
+	return ids
+}
+
+// indexedMatches counts the rows of the full-text index matching an FTS5
+// query, without the fuzzy fallback SearchNodes has.
+func indexedMatches(t *testing.T, db *Database, match string) int {
+	t.Helper()
+	var n int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM nodes_fts WHERE nodes_fts MATCH ?", match).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSearchIndexFollowsNodes(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.InsertFolder(&Folder{ID: "openchat", Name: "OpenChat History", Color: "#e94560", CreatedAt: "2026-01-01T00:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	expect := func(query string, want ...string) {
+		t.Helper()
+		if n := indexedMatches(t, db, query); n != len(want) {
+			t.Errorf("%s: got %d rows in the index, want %d", query, n, len(want))
+		}
+		got := searchIDs(t, db, query)
+		if len(got) != len(want) {
+			t.Errorf("%s: got %v, want %v", query, got, want)
+			return
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: got %v, want %v", query, got, want)
+				return
+			}
+		}
+	}
+
+	node := &MessageNode{ID: "a", Type: "user", Content: "the lexer panics on tabs", Timestamp: "2026-01-01T10:00:00Z", HasLoaded: true}
+	if err := db.InsertNode("openchat", node); err != nil {
+		t.Fatal(err)
+	}
+	expect("lexer", "a")
+
+	node.Content = "the tokenizer panics on tabs"
+	node.Tags = []string{"regression"}
+	if err := db.UpdateNode("openchat", node); err != nil {
+		t.Fatal(err)
+	}
+	expect("lexer")
+	expect("tokenizer", "a")
+	expect("regression", "a")
+
+	// Hydration fills in the content of a message synced without it.
+	if err := db.InsertNode("openchat", &MessageNode{ID: "b", Type: "assistant", Timestamp: "2026-01-01T11:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	expect("whitespace")
+	parts := []*MessagePart{{ID: "prt_b", MessageID: "b", Type: "text", Text: "Tabs count as whitespace now."}}
+	if err := db.StoreHydration("b", parts, partsContent(parts), "1:30:1"); err != nil {
+		t.Fatal(err)
+	}
+	expect("whitespace", "b")
+
+	if err := db.DeleteNode("a"); err != nil {
+		t.Fatal(err)
+	}
+	expect("tokenizer")
+	expect("tabs", "b")
+}
+
+func TestSearchIndexRepairsDrift(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertFolder(&Folder{ID: "openchat", Name: "OpenChat History", Color: "#e94560", CreatedAt: "2026-01-01T00:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range []*MessageNode{
+		{ID: "a", Type: "user", Content: "rename the lexer", Timestamp: "2026-01-01T10:00:00Z", HasLoaded: true},
+		{ID: "b", Type: "user", Content: "update the lexer docs", Timestamp: "2026-01-01T11:00:00Z", HasLoaded: true},
+	} {
+		if err := db.InsertNode("openchat", node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Lose a row of the index, as a database written by an older
+	// version without it would have.
+	if _, err := db.db.Exec("DELETE FROM nodes_fts WHERE rowid = (SELECT rowid FROM nodes WHERE id = 'b')"); err != nil {
+		t.Fatal(err)
+	}
+	if n := indexedMatches(t, db, "docs"); n != 0 {
+		t.Fatalf("expected the dropped row to be missing from the index, got %d matches", n)
+	}
+	db.Close()
+
+	db, err = NewDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n := indexedMatches(t, db, "docs"); n != 1 {
+		t.Errorf("expected the index to be rebuilt on open, got %d matches", n)
+	}
+	if got := searchIDs(t, db, "docs"); len(got) != 1 || got[0] != "b" {
+		t.Errorf("expected the rebuilt row to be found, got %v", got)
+	}
+	if got := searchIDs(t, db, "lexer"); len(got) != 2 {
+		t.Errorf("expected both nodes after the rebuild, got %v", got)
+	}
+}
+
+func TestSearchRanksSummaryAboveContent(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.InsertFolder(&Folder{ID: "openchat", Name: "OpenChat History", Color: "#e94560", CreatedAt: "2026-01-01T00:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	// The same words, once in the title and once in the text, so only
+	// the column weights tell them apart. The content hit is newer.
+	for _, node := range []*MessageNode{
+		{ID: "title", Type: "user", Summary: "migrate the scheduler", Content: "see the ticket", Timestamp: "2026-01-01T10:00:00Z", HasLoaded: true},
+		{ID: "content", Type: "user", Summary: "see the ticket", Content: "migrate the scheduler", Timestamp: "2026-01-02T10:00:00Z", HasLoaded: true},
+	} {
+		if err := db.InsertNode("openchat", node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := searchIDs(t, db, "scheduler")
+	if len(got) != 2 || got[0] != "title" {
+		t.Errorf("expected the title hit first, got %v", got)
+	}
+	// Raw searches leave summaries out.
+	response, err := db.SearchNodes(SearchRequest{Query: "scheduler", SearchRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 1 || response.Results[0].Node.ID != "content" {
+		t.Errorf("expected only the content hit in a raw search, got %+v", response.Results)
+	}
+}
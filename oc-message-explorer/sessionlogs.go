@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SessionImporter reads the session logs other terminal agents write. Each
+// file holds one session and is imported into a folder of its own, whose
+// ID is the source's folder followed by the session ID. A source's path can
+// be a single log or a directory searched for logs.
+type SessionImporter interface {
+	Importer
+	// Match reports whether a file found under a source directory looks
+	// like one of this importer's logs, going by its path alone.
+	Match(path string) bool
+}
+
+// maxDetectFiles bounds how many files detectSessionDir looks at.
+const maxDetectFiles = 200
+
+// errStopWalk ends a filepath.WalkDir early.
+var errStopWalk = errors.New("stop walking")
+
+// detectSessionDir returns the session importer for the first log found
+// under dir.
+func detectSessionDir(dir string) (Importer, error) {
+	var found Importer
+	seen := 0
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if seen++; seen > maxDetectFiles {
+			return errStopWalk
+		}
+		for _, importer := range importers {
+			session, ok := importer.(SessionImporter)
+			if !ok || !session.Match(path) {
+				continue
+			}
+			head, err := readHead(path)
+			if err == nil && session.Detect(path, head) {
+				found = importer
+				return errStopWalk
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errStopWalk {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no session logs found in %s", dir)
+	}
+	return found, nil
+}
+
+// sessionLogFiles lists the logs a session source covers.
+func sessionLogFiles(root string, importer SessionImporter) ([]string, error) {
+	if !isDir(root) {
+		return []string{root}, nil
+	}
+
+	var files []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Failed to read %s: %v", path, err)
+			return nil
+		}
+		if !entry.IsDir() && importer.Match(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// sessionFolderID is the folder a session from a source is imported into.
+func sessionFolderID(source HistorySource, sessionID string) string {
+	return source.FolderID + "_" + sessionID
+}
+
+// sessionNodeID identifies a message of an imported session by its turn,
+// counted from 1 at each user message, so appending to a log leaves the
+// IDs of earlier messages alone.
+func sessionNodeID(folderID string, turn int, role string) string {
+	return fmt.Sprintf("%s_%d_%s", folderID, turn, role)
+}
+
+// sessionNode is how session log importers map an entry.
+func sessionNode(source HistorySource, entry HistoryEntry) *MessageNode {
+	node := &MessageNode{
+		Type:      "response",
+		Tags:      []string{source.FolderID, entry.Role},
+		SessionID: entry.SessionID,
+		HasLoaded: true,
+	}
+	if entry.Role == "user" {
+		node.Type = "user"
+		node.Content = entry.Input
+	}
+	return node
+}
+
+// importedSession is one session log, ready to be written.
+type importedSession struct {
+	folder  *Folder
+	session *Session
+	nodes   []*MessageNode
+	parts   map[string][]*MessagePart // by node ID
+}
+
+// readSessionLog turns a session log into a folder of messages. Each user
+// message starts a turn, and everything the agent does until the next one
+// (text, reasoning and tool calls) becomes one response whose parent is the
+// user message, the way OpenCode stores a turn.
+func readSessionLog(source HistorySource, importer SessionImporter, path string, data []byte, modTime time.Time) (*importedSession, error) {
+	var entries []HistoryEntry
+	if err := importer.Parse(bytes.NewReader(data), func(entry HistoryEntry) {
+		entries = append(entries, entry)
+	}); err != nil {
+		return nil, err
+	}
+
+	sessionID := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	for _, entry := range entries {
+		if entry.SessionID != "" {
+			sessionID = entry.SessionID
+			break
+		}
+	}
+
+	session := &importedSession{
+		folder: &Folder{
+			ID:    sessionFolderID(source, sessionID),
+			Color: source.Color,
+		},
+		parts: make(map[string][]*MessagePart),
+	}
+
+	var title, directory string
+	var user, response *MessageNode
+	var last time.Time
+	turn := 0
+	for _, entry := range entries {
+		// Entries without a time of their own happened no earlier than the
+		// one before them.
+		when := entry.Time
+		if when.IsZero() {
+			when = last
+		}
+		if when.IsZero() {
+			when = modTime
+		}
+		last = when
+
+		if directory == "" {
+			directory = entry.Directory
+		}
+		entry.SessionID = sessionID
+
+		if entry.Role == "user" {
+			turn++
+			user = importer.Node(source, entry)
+			user.ID = sessionNodeID(session.folder.ID, turn, "user")
+			user.Timestamp = when.Format(time.RFC3339)
+			user.TimestampEstimated = entry.Time.IsZero()
+			user.Summary = truncateSummary(user.Content)
+			if title == "" {
+				title = user.Summary
+			}
+			session.nodes = append(session.nodes, user)
+			response = nil
+			continue
+		}
+
+		if response == nil {
+			response = importer.Node(source, entry)
+			response.ID = sessionNodeID(session.folder.ID, turn, "assistant")
+			response.Timestamp = when.Format(time.RFC3339)
+			response.TimestampEstimated = entry.Time.IsZero()
+			if user != nil {
+				response.ParentID = user.ID
+			}
+			session.nodes = append(session.nodes, response)
+		}
+
+		parts := session.parts[response.ID]
+		for _, part := range entry.Parts {
+			part.ID = fmt.Sprintf("%s_%d", response.ID, len(parts))
+			part.MessageID = response.ID
+			part.SessionID = sessionID
+			part.Position = len(parts)
+			parts = append(parts, part)
+		}
+		session.parts[response.ID] = parts
+	}
+
+	for _, node := range session.nodes {
+		if node.Type == "user" {
+			continue
+		}
+		node.Content = partsContent(session.parts[node.ID])
+		node.Summary = truncateSummary(node.Content)
+		if node.Summary == "" {
+			node.Summary = "AI response"
+		}
+	}
+
+	if title == "" {
+		title = sessionID
+	}
+	session.session = &Session{
+		ID:        sessionID,
+		Title:     title,
+		Directory: directory,
+		Source:    importer.Name(),
+		CreatedAt: modTime.Format(time.RFC3339),
+		UpdatedAt: formatTime(last),
+	}
+	if directory != "" {
+		title = filepath.Base(directory) + ": " + title
+	}
+	session.folder.Name = fmt.Sprintf("%s - %s", source.Name, title)
+	session.folder.CreatedAt = modTime.Format(time.RFC3339)
+	if len(session.nodes) > 0 {
+		session.folder.CreatedAt = session.nodes[0].Timestamp
+		session.session.CreatedAt = session.nodes[0].Timestamp
+	}
+
+	return session, nil
+}
+
+// truncateSummary shortens text to the first line, at most 100 bytes.
+func truncateSummary(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	if len(text) > 100 {
+		text = text[:97] + "..."
+	}
+	return text
+}
+
+// WriteImportedSession files an imported session, its metadata and the
+// checkpoint of the log it came from, placing the session in the project
+// its working directory belongs to. Messages that are already known get
+// their content, summary and parts refreshed, since an agent keeps
+// appending to the last turn, but keep their tags, lock and folder; a
+// locked message keeps its content and summary as well, the way
+// StoreHydration leaves it. It returns the IDs of the messages it wrote.
+func (d *Database) WriteImportedSession(session *importedSession, checkpoint SyncCheckpoint) ([]string, error) {
+	var written []string
+	err := d.withTx(func(tx *sql.Tx) error {
+		written = written[:0]
+		if _, err := tx.Exec(
+			"INSERT OR IGNORE INTO folders (id, name, color, created_at) VALUES (?, ?, ?, ?)",
+			session.folder.ID, session.folder.Name, session.folder.Color, session.folder.CreatedAt,
+		); err != nil {
+			return err
+		}
+		projectID, err := resolveProjectTx(tx, session.session.Directory, session.session.Source)
+		if err != nil {
+			return err
+		}
+		session.session.ProjectID = projectID
+		if err := upsertSessionTx(tx, session.session); err != nil {
+			return err
+		}
+
+		for _, node := range session.nodes {
+			var content, summary string
+			var kept bool
+			err := tx.QueryRow("SELECT COALESCE(content, ''), COALESCE(summary, ''), locked = 1 AND has_loaded = 1 FROM nodes WHERE id = ?", node.ID).
+				Scan(&content, &summary, &kept)
+			if err == sql.ErrNoRows {
+				if err := insertNodeTx(tx, session.folder.ID, node); err != nil {
+					return err
+				}
+				written = append(written, node.ID)
+			} else if err != nil {
+				return err
+			} else if !kept && (content != node.Content || summary != node.Summary) {
+				if err := deindexNodeTx(tx, node.ID); err != nil {
+					return err
+				}
+				if _, err := tx.Exec("UPDATE nodes SET content = ?, summary = ? WHERE id = ?", node.Content, node.Summary, node.ID); err != nil {
+					return err
+				}
+				if err := indexNodeTx(tx, node.ID); err != nil {
+					return err
+				}
+				written = append(written, node.ID)
+			}
+
+			// Tool output can arrive without the text changing.
+			if err := replacePartsTx(tx, node.ID, session.parts[node.ID]); err != nil {
+				return err
+			}
+		}
+
+		checkpoint.FolderID = session.folder.ID
+		return saveCheckpointsTx(tx, []SyncCheckpoint{checkpoint})
+	})
+	return written, err
+}
+
+// syncSessionSource imports the session logs of a source that changed
+// since their checkpoints.
+func (sm *SyncManager) syncSessionSource(source HistorySource, importer SessionImporter) error {
+	files, err := sessionLogFiles(source.Path, importer)
+	if err != nil {
+		return err
+	}
+	checkpoints, err := sm.db.GetSyncCheckpoints(source.Path)
+	if err != nil {
+		return err
+	}
+
+	imported, failed := 0, 0
+	for i, path := range files {
+		previous, known := checkpoints[path]
+		folderID, _, err := sm.importSessionFile(source, importer, path, previous, known)
+		if err != nil {
+			log.Printf("Failed to import %s session %s: %v", source.Name, path, err)
+			failed++
+			continue
+		}
+		if folderID != "" {
+			imported++
+		}
+
+		if (i+1)%20 == 0 {
+			sm.reportProgress(SyncProgress{
+				Phase:         "reading_history",
+				Message:       fmt.Sprintf("Read %d/%d %s sessions...", i+1, len(files), source.Name),
+				Processed:     i + 1,
+				TotalMessages: len(files),
+			})
+		}
+	}
+
+	log.Printf("Imported %d changed %s sessions; %d unchanged", imported, source.Name, len(files)-imported-failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d session logs could not be imported", failed, len(files))
+	}
+	return nil
+}
+
+// importSessionFile imports one session log unless it matches its
+// checkpoint and the folder it was imported into is still there. It
+// returns the session's folder ID, empty when the log was skipped, and the
+// IDs of the messages written.
+func (sm *SyncManager) importSessionFile(source HistorySource, importer SessionImporter, path string, previous SyncCheckpoint, known bool) (string, map[string]bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if known {
+		if folder, err := sm.db.GetFolder(previous.FolderID); previous.FolderID == "" || err != nil || folder == nil {
+			known = false
+		}
+	}
+	checkpoint, data, changed, err := checkFile(path, info, previous, known)
+	if err != nil {
+		return "", nil, err
+	}
+	if !changed {
+		if data != nil {
+			checkpoint.FolderID = previous.FolderID
+			return "", nil, sm.db.SaveSyncCheckpoints([]SyncCheckpoint{checkpoint})
+		}
+		return "", nil, nil
+	}
+
+	session, err := readSessionLog(source, importer, path, data, info.ModTime())
+	if err != nil {
+		return "", nil, err
+	}
+	written, err := sm.db.WriteImportedSession(session, checkpoint)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ids := make(map[string]bool, len(written))
+	for _, id := range written {
+		ids[id] = true
+	}
+	return session.folder.ID, ids, nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// copyTestdata copies a directory under testdata into a temporary one, so
+// a test can append to the logs.
+func copyTestdata(t *testing.T, name string) string {
+	t.Helper()
+	src := filepath.Join("testdata", name)
+	dst := t.TempDir()
+	err := filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if entry.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), data, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dst
+}
+
+func readTestSession(t *testing.T, importer SessionImporter, path string) *importedSession {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := HistorySource{Path: filepath.Dir(path), FolderID: importer.Name(), Name: importer.Name(), Color: "#10a37f"}
+	session, err := readSessionLog(source, importer, path, data, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestDetectSessionLogs(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"testdata/codex", "codex"},
+		{"testdata/codex/2025/10/01/rollout-2025-10-01T09-00-00-0199a1b2-c3d4.jsonl", "codex"},
+		{"testdata/codex/rollout-2025-05-01-legacy.jsonl", "codex"},
+		{"testdata/gemini", "gemini"},
+		{"testdata/gemini/chats/session-2025-10-02T10-00-7c1d.json", "gemini"},
+		{"testdata/gemini/chats/session-2025-10-03T08-00-91ab.jsonl", "gemini"},
+	}
+
+	for _, tt := range tests {
+		importer, err := detectImporter(tt.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		if importer.Name() != tt.want {
+			t.Errorf("%s: detected %s, want %s", tt.path, importer.Name(), tt.want)
+		}
+	}
+
+	if _, err := detectImporter(t.TempDir()); err == nil {
+		t.Error("expected an empty directory to be an error")
+	}
+}
+
+func TestReadCodexSession(t *testing.T) {
+	session := readTestSession(t, codexImporter{}, "testdata/codex/2025/10/01/rollout-2025-10-01T09-00-00-0199a1b2-c3d4.jsonl")
+
+	if session.folder.ID != "codex_0199a1b2-c3d4" || session.folder.Name != "codex - parser: Fix the failing parser test" {
+		t.Errorf("unexpected folder %+v", session.folder)
+	}
+	if len(session.nodes) != 4 {
+		t.Fatalf("expected 4 messages, got %d", len(session.nodes))
+	}
+
+	user, response := session.nodes[0], session.nodes[1]
+	if user.ID != "codex_0199a1b2-c3d4_1_user" || user.Type != "user" || user.Content != "Fix the failing parser test" {
+		t.Errorf("unexpected user message %+v", user)
+	}
+	if user.Timestamp != "2025-10-01T09:00:01Z" || user.TimestampEstimated || user.SessionID != "0199a1b2-c3d4" {
+		t.Errorf("unexpected user message time or session %+v", user)
+	}
+	if response.Type != "response" || response.ParentID != user.ID || response.Content != "Fixed the off-by-one in parse.go." {
+		t.Errorf("unexpected response %+v", response)
+	}
+	if response.Timestamp != "2025-10-01T09:00:03Z" || response.Tags[1] != "assistant" {
+		t.Errorf("unexpected response time or tags %+v", response)
+	}
+	if session.nodes[3].ParentID != session.nodes[2].ID || session.nodes[2].Content != "Now run the linter" {
+		t.Errorf("expected the second turn to be linked, got %+v and %+v", session.nodes[2], session.nodes[3])
+	}
+
+	parts := session.parts[response.ID]
+	if len(parts) != 4 {
+		t.Fatalf("expected 4 parts, got %d", len(parts))
+	}
+	if parts[0].Type != "reasoning" || parts[0].Text != "Running the tests first" {
+		t.Errorf("unexpected reasoning %+v", parts[0])
+	}
+	shell := parts[1]
+	if shell.Tool != "shell" || shell.CallID != "call_1" || shell.Status != "error" || shell.Error != "--- FAIL: TestParse\n" {
+		t.Errorf("unexpected failed shell call %+v", shell)
+	}
+	if shell.StartedAt != "2025-10-01T09:00:04Z" || shell.EndedAt != "2025-10-01T09:00:09Z" {
+		t.Errorf("unexpected shell call times %+v", shell)
+	}
+	patch := parts[2]
+	if patch.Tool != "apply_patch" || patch.Status != "completed" || patch.Output != "Success. Updated parse.go\n" || patch.Input == "" {
+		t.Errorf("unexpected patch call %+v", patch)
+	}
+	if parts[3].Type != "text" || parts[3].ID != response.ID+"_3" || parts[3].Position != 3 {
+		t.Errorf("unexpected text part %+v", parts[3])
+	}
+
+	legacy := readTestSession(t, codexImporter{}, "testdata/codex/rollout-2025-05-01-legacy.jsonl")
+	if len(legacy.nodes) != 2 || legacy.folder.ID != "codex_5f0c1e2d-legacy" {
+		t.Fatalf("unexpected legacy session %+v", legacy.folder)
+	}
+	if legacy.nodes[0].Timestamp != "2025-05-01T08:00:00Z" || legacy.nodes[0].TimestampEstimated {
+		t.Errorf("expected the legacy session time to be used, got %+v", legacy.nodes[0])
+	}
+	ls := legacy.parts[legacy.nodes[1].ID][0]
+	if ls.Tool != "shell" || ls.Output != "main.go\n" || ls.Status != "completed" {
+		t.Errorf("unexpected legacy shell call %+v", ls)
+	}
+}
+
+func TestReadGeminiSession(t *testing.T) {
+	session := readTestSession(t, geminiImporter{}, "testdata/gemini/chats/session-2025-10-02T10-00-7c1d.json")
+
+	if session.folder.ID != "gemini_7c1d5e9a" || session.folder.CreatedAt != "2025-10-02T10:00:00Z" {
+		t.Errorf("unexpected folder %+v", session.folder)
+	}
+	if len(session.nodes) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(session.nodes))
+	}
+
+	user, response, thanks := session.nodes[0], session.nodes[1], session.nodes[2]
+	if user.Content != "What does main.go do?" || thanks.Content != "Thanks" || thanks.ID != "gemini_7c1d5e9a_2_user" {
+		t.Errorf("unexpected user messages %+v and %+v", user, thanks)
+	}
+	if response.ParentID != user.ID || response.Content != "It starts the web server." || response.Timestamp != "2025-10-02T10:00:05Z" {
+		t.Errorf("unexpected response %+v", response)
+	}
+
+	parts := session.parts[response.ID]
+	if len(parts) != 4 {
+		t.Fatalf("expected 4 parts, got %d", len(parts))
+	}
+	if parts[0].Type != "reasoning" || parts[0].Text != "Reading the file\nI need to look at main.go first." {
+		t.Errorf("unexpected reasoning %+v", parts[0])
+	}
+	read := parts[1]
+	if read.Tool != "read_file" || read.Status != "completed" || read.Output != "package main\n" || read.Input != `{"absolute_path":"/src/app/main.go"}` {
+		t.Errorf("unexpected read_file call %+v", read)
+	}
+	if failed := parts[2]; failed.Status != "error" || failed.Error != "exit status 1" || failed.StartedAt != "2025-10-02T10:00:08Z" {
+		t.Errorf("unexpected failed call %+v", failed)
+	}
+
+	lines := readTestSession(t, geminiImporter{}, "testdata/gemini/chats/session-2025-10-03T08-00-91ab.jsonl")
+	if lines.folder.ID != "gemini_91ab0f00" || len(lines.nodes) != 2 || lines.nodes[1].Content != "It explains how to run the explorer." {
+		t.Errorf("unexpected JSON lines session %+v", lines.nodes)
+	}
+}
+
+func TestSyncSessionSource(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	db := store.db
+	dir := copyTestdata(t, "codex")
+	source := HistorySource{Path: dir, FolderID: "codex", Name: "Codex", Color: "#10a37f"}
+
+	sm := NewSyncManager(db, store, t.TempDir(), nil)
+	sm.historySources = []HistorySource{source}
+	if err := sm.syncHistorySource(source); err != nil {
+		t.Fatal(err)
+	}
+
+	for folderID, want := range map[string]int{"codex_0199a1b2-c3d4": 4, "codex_5f0c1e2d-legacy": 2} {
+		nodes, err := db.GetNodesForFolder(folderID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != want {
+			t.Errorf("%s: expected %d messages, got %d", folderID, want, len(nodes))
+		}
+	}
+	if count, err := db.CountSessionFolderNodes("codex"); err != nil || count != 6 {
+		t.Errorf("expected 6 messages across sessions, got %d (%v)", count, err)
+	}
+
+	session, err := db.GetSession("0199a1b2-c3d4")
+	if err != nil || session == nil {
+		t.Fatalf("expected the session to be recorded: %v", err)
+	}
+	if session.Source != "codex" || session.Directory != "/src/parser" || session.Title != "Fix the failing parser test" || session.MessageCount != 4 {
+		t.Errorf("unexpected session %+v", session.Session)
+	}
+
+	parts, err := db.GetParts("codex_0199a1b2-c3d4_1_assistant")
+	if err != nil || len(parts) != 4 || parts[1].Status != "error" {
+		t.Fatalf("expected the tool calls to be stored, got %+v (%v)", parts, err)
+	}
+
+	tagged, err := db.GetNode("codex_0199a1b2-c3d4_2_assistant")
+	if err != nil || tagged == nil {
+		t.Fatalf("expected the second response to be stored: %v", err)
+	}
+	tagged.Tags = []string{"linted"}
+	if err := db.UpdateNode("codex_0199a1b2-c3d4", tagged); err != nil {
+		t.Fatal(err)
+	}
+
+	// The agent keeps working on the last turn, then a new one starts.
+	path := filepath.Join(dir, "2025", "10", "01", "rollout-2025-10-01T09-00-00-0199a1b2-c3d4.jsonl")
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"timestamp":"2025-10-01T09:05:06.000Z","type":"response_item","payload":{"type":"message","role":"assistant","content":[{"type":"output_text","text":"No warnings either."}]}}
+{"timestamp":"2025-10-01T09:10:00.000Z","type":"response_item","payload":{"type":"message","role":"user","content":[{"type":"input_text","text":"Commit it"}]}}
+`)
+	file.Close()
+
+	if err := sm.syncHistorySource(source); err != nil {
+		t.Fatal(err)
+	}
+
+	linted, err := db.GetNode("codex_0199a1b2-c3d4_2_assistant")
+	if err != nil || linted == nil {
+		t.Fatalf("expected the response to keep its ID: %v", err)
+	}
+	if linted.Content != "The linter is clean.\nNo warnings either." || len(linted.Tags) != 1 || linted.Tags[0] != "linted" {
+		t.Errorf("expected the response to be extended and keep its tags, got %+v", linted)
+	}
+	commit, err := db.GetNode("codex_0199a1b2-c3d4_3_user")
+	if err != nil || commit == nil || commit.Content != "Commit it" {
+		t.Errorf("expected the new turn to be imported, got %+v (%v)", commit, err)
+	}
+}
+
+func TestSessionImportKeepsLockedContent(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	db := store.db
+	dir := copyTestdata(t, "codex")
+	source := HistorySource{Path: dir, FolderID: "codex", Name: "Codex", Color: "#10a37f"}
+
+	sm := NewSyncManager(db, store, t.TempDir(), nil)
+	sm.historySources = []HistorySource{source}
+	if err := sm.syncHistorySource(source); err != nil {
+		t.Fatal(err)
+	}
+
+	const id = "codex_0199a1b2-c3d4_2_assistant"
+	curated, err := db.GetNode(id)
+	if err != nil || curated == nil {
+		t.Fatalf("expected the second response to be stored: %v", err)
+	}
+	curated.Content, curated.Summary = "Linter: clean", "Lint result"
+	if err := db.UpdateNode("codex_0199a1b2-c3d4", curated); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateNodeLock(id, true); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "2025", "10", "01", "rollout-2025-10-01T09-00-00-0199a1b2-c3d4.jsonl")
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"timestamp":"2025-10-01T09:05:06.000Z","type":"response_item","payload":{"type":"message","role":"assistant","content":[{"type":"output_text","text":"No warnings either."}]}}
+`)
+	file.Close()
+	if err := sm.syncHistorySource(source); err != nil {
+		t.Fatal(err)
+	}
+
+	kept, err := db.GetNode(id)
+	if err != nil || kept == nil {
+		t.Fatalf("expected the response to stay: %v", err)
+	}
+	if kept.Content != "Linter: clean" || kept.Summary != "Lint result" || !kept.Locked {
+		t.Errorf("expected the locked response to keep its content, got %+v", kept)
+	}
+	parts, err := db.GetParts(id)
+	if err != nil || len(parts) == 0 || parts[len(parts)-1].Text != "No warnings either." {
+		t.Errorf("expected the parts to be refreshed, got %+v (%v)", parts, err)
+	}
+}
+
+func TestSessionImportAfterFolderDeleted(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	db := store.db
+	source := HistorySource{Path: copyTestdata(t, "codex"), FolderID: "codex", Name: "Codex", Color: "#10a37f"}
+
+	sm := NewSyncManager(db, store, t.TempDir(), nil)
+	sm.historySources = []HistorySource{source}
+	if err := sm.syncHistorySource(source); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteFolder("codex_0199a1b2-c3d4"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The log is unchanged, but its folder is gone, so it is read again;
+	// the other session is left alone.
+	if err := sm.syncHistorySource(source); err != nil {
+		t.Fatal(err)
+	}
+	for folderID, want := range map[string]int{"codex_0199a1b2-c3d4": 4, "codex_5f0c1e2d-legacy": 2} {
+		nodes, err := db.GetNodesForFolder(folderID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != want {
+			t.Errorf("%s: expected %d messages, got %d", folderID, want, len(nodes))
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	apperrors "oc-message-explorer/internal/errors"
+)
+
+// Session is what is known about a session beyond the SessionID on its
+// messages: OpenCode's storage/session metadata, or what an imported
+// session log recorded.
+type Session struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Directory string `json:"directory,omitempty"`
+	ProjectID string `json:"projectId,omitempty"`
+	ParentID  string `json:"parentId,omitempty"` // the session a subagent ran for
+	Version   string `json:"version,omitempty"`
+	Source    string `json:"source"` // "opencode", or the importer of a session log
+	CreatedAt string `json:"createdAt,omitempty"`
+	UpdatedAt string `json:"updatedAt,omitempty"`
+
+	// Filled in when listed.
+	MessageCount   int    `json:"messageCount"`
+	FirstMessageAt string `json:"firstMessageAt,omitempty"`
+	LastMessageAt  string `json:"lastMessageAt,omitempty"`
+	ErrorCount     int    `json:"errorCount"`   // responses that failed, not counting aborts
+	AbortedCount   int    `json:"abortedCount"` // responses the user interrupted
+	MaxLatencyMs   int64  `json:"maxLatencyMs,omitempty"`
+	Compactions    int    `json:"compactions"`           // summaries that replaced earlier context
+	CompactedAt    string `json:"compactedAt,omitempty"` // when the context last started over
+}
+
+// SessionFilter narrows and orders /api/sessions. Sort is "updated" (the
+// default), "errors" or "latency".
+type SessionFilter struct {
+	ParentID  string
+	ProjectID string
+	Sort      string
+}
+
+// sessionOrders are the orders a session list can be sorted in; ties
+// fall back to the most recently updated first.
+var sessionOrders = map[string]string{
+	"updated": "",
+	"errors":  "COUNT(NULLIF(n.error_kind, '')) DESC, ",
+	"latency": "MAX(n.latency_ms) DESC, ",
+}
+
+// SessionDetail is returned by GET /api/sessions/{id}.
+type SessionDetail struct {
+	*Session
+	Subsessions []*Session `json:"subsessions"`
+}
+
+// OpenCodeSession is a storage/session/<project>/<session>.json file.
+type OpenCodeSession struct {
+	ID        string `json:"id"`
+	Version   string `json:"version"`
+	ProjectID string `json:"projectID"`
+	Directory string `json:"directory"`
+	ParentID  string `json:"parentID"`
+	Title     string `json:"title"`
+	Time      struct {
+		Created int64 `json:"created"`
+		Updated int64 `json:"updated"`
+	} `json:"time"`
+}
+
+func parseOpenCodeSession(data []byte) (*Session, error) {
+	var oc OpenCodeSession
+	if err := json.Unmarshal(data, &oc); err != nil {
+		return nil, err
+	}
+	if oc.ID == "" {
+		return nil, fmt.Errorf("session has no id")
+	}
+
+	session := &Session{
+		ID:        oc.ID,
+		Title:     oc.Title,
+		Directory: oc.Directory,
+		ProjectID: oc.ProjectID,
+		ParentID:  oc.ParentID,
+		Version:   oc.Version,
+		Source:    "opencode",
+	}
+	if oc.Time.Created > 0 {
+		session.CreatedAt = formatTimestamp(oc.Time.Created)
+	}
+	if oc.Time.Updated > 0 {
+		session.UpdatedAt = formatTimestamp(oc.Time.Updated)
+	}
+	return session, nil
+}
+
+const sessionSelectColumns = `s.id, s.title, s.directory, s.project_id, s.parent_id, s.version,
+		       s.source, s.created_at, s.updated_at`
+
+// sessionListQuery lists sessions with counts taken from their messages.
+// It takes a WHERE clause and the start of the ORDER BY.
+var sessionListQuery = `
+	SELECT ` + sessionSelectColumns + `,
+	       COUNT(n.id), COALESCE(MIN(n.timestamp), ''), COALESCE(MAX(n.timestamp), ''),
+	       COUNT(CASE WHEN n.error_kind NOT IN ('', 'aborted') THEN 1 END),
+	       COUNT(CASE WHEN n.error_kind = 'aborted' THEN 1 END),
+	       COALESCE(MAX(n.latency_ms), 0),
+	       COUNT(CASE WHEN n.boundary = '` + boundarySummary + `' THEN 1 END),
+	       COALESCE(` + compactionCutoff("s.id") + `, '')
+	FROM sessions s
+	LEFT JOIN nodes n ON n.session_id = s.id
+	%s
+	GROUP BY s.id
+	ORDER BY %sCOALESCE(NULLIF(s.updated_at, ''), s.created_at) DESC, s.id`
+
+func scanSessionRow(row rowScanner) (*Session, error) {
+	var session Session
+	err := row.Scan(&session.ID, &session.Title, &session.Directory, &session.ProjectID, &session.ParentID,
+		&session.Version, &session.Source, &session.CreatedAt, &session.UpdatedAt,
+		&session.MessageCount, &session.FirstMessageAt, &session.LastMessageAt,
+		&session.ErrorCount, &session.AbortedCount, &session.MaxLatencyMs,
+		&session.Compactions, &session.CompactedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func upsertSessionTx(tx *sql.Tx, session *Session) error {
+	_, err := tx.Exec(`
+		INSERT INTO sessions (id, title, directory, project_id, parent_id, version, source, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			title = excluded.title, directory = excluded.directory, project_id = excluded.project_id,
+			parent_id = excluded.parent_id, version = excluded.version, source = excluded.source,
+			created_at = excluded.created_at, updated_at = excluded.updated_at`,
+		session.ID, session.Title, session.Directory, session.ProjectID, session.ParentID,
+		session.Version, session.Source, session.CreatedAt, session.UpdatedAt,
+	)
+	return err
+}
+
+// WriteSessions stores session metadata along with the checkpoints of the
+// files it was read from.
+func (d *Database) WriteSessions(sessions []*Session, checkpoints []SyncCheckpoint) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		for _, session := range sessions {
+			if err := upsertSessionTx(tx, session); err != nil {
+				return fmt.Errorf("session %s: %w", session.ID, err)
+			}
+			// A subagent session can be read after the call that
+			// spawned it.
+			if session.ParentID != "" {
+				if err := linkSubagentsTx(tx, "session_id = ?", session.ParentID); err != nil {
+					return fmt.Errorf("session %s: %w", session.ID, err)
+				}
+			}
+		}
+		return saveCheckpointsTx(tx, checkpoints)
+	})
+}
+
+// ListSessions lists sessions, most recently updated first unless the
+// filter sorts them otherwise. A ParentID lists only the subagent sessions
+// run for it, and a ProjectID only the sessions of that project.
+func (d *Database) ListSessions(filter SessionFilter) ([]*Session, error) {
+	order, ok := sessionOrders[filter.Sort]
+	if !ok && filter.Sort != "" {
+		return nil, apperrors.NewValidationError(fmt.Sprintf("cannot sort sessions by %q (use updated, errors or latency)", filter.Sort), nil)
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var conditions []string
+	var args []any
+	if filter.ParentID != "" {
+		conditions = append(conditions, "s.parent_id = ?")
+		args = append(args, filter.ParentID)
+	}
+	if filter.ProjectID != "" {
+		conditions = append(conditions, "s.project_id = ?")
+		args = append(args, filter.ProjectID)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	rows, err := d.db.Query(fmt.Sprintf(sessionListQuery, where, order), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		session, err := scanSessionRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// GetSession returns a session and the subagent sessions run for it, or
+// nil if it is unknown.
+func (d *Database) GetSession(id string) (*SessionDetail, error) {
+	d.mu.RLock()
+	session, err := scanSessionRow(d.db.QueryRow(fmt.Sprintf(sessionListQuery, "WHERE s.id = ?", ""), id))
+	d.mu.RUnlock()
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	subsessions, err := d.ListSessions(SessionFilter{ParentID: id})
+	if err != nil {
+		return nil, err
+	}
+	return &SessionDetail{Session: session, Subsessions: subsessions}, nil
+}
+
+// sessionFiles lists the session files under storage/session, which
+// OpenCode files by project.
+func sessionFiles(sessionPath string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(sessionPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == sessionPath {
+				return fs.SkipAll
+			}
+			return nil
+		}
+		if !entry.IsDir() && strings.HasSuffix(path, ".json") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// syncSessionInfo reads the session files that changed since their
+// checkpoints into the sessions table.
+func (sm *SyncManager) syncSessionInfo() error {
+	files, err := sessionFiles(sm.sessionPath)
+	if err != nil {
+		return err
+	}
+	checkpoints, err := sm.db.GetSyncCheckpoints(sm.sessionPath)
+	if err != nil {
+		return err
+	}
+
+	sessions, changed := readSessionFiles(files, checkpoints)
+	if err := sm.db.WriteSessions(sessions, changed); err != nil {
+		return err
+	}
+	log.Printf("Read %d changed sessions; %d unchanged", len(sessions), len(files)-len(sessions))
+	return nil
+}
+
+// readSessionFiles parses the session files that differ from their
+// checkpoints, returning them with the checkpoints to save once written.
+// Files that cannot be read are logged and skipped.
+func readSessionFiles(files []string, previous map[string]SyncCheckpoint) ([]*Session, []SyncCheckpoint) {
+	var sessions []*Session
+	checkpoints := readChangedFiles(files, previous, "session", func(data []byte) error {
+		session, err := parseOpenCodeSession(data)
+		if err == nil {
+			sessions = append(sessions, session)
+		}
+		return err
+	})
+	return sessions, checkpoints
+}
+
+// ListSessions lists sessions for /api/sessions. Without a database there
+// is no session metadata to list.
+func (s *Store) ListSessions(filter SessionFilter) ([]*Session, error) {
+	if s.db == nil {
+		return []*Session{}, nil
+	}
+	sessions, err := s.db.ListSessions(filter)
+	if err != nil {
+		var appErr *apperrors.AppError
+		if errors.As(err, &appErr) {
+			return nil, err
+		}
+		return nil, apperrors.NewDatabaseError("failed to list sessions", err)
+	}
+	return sessions, nil
+}
+
+// GetSession returns a session for /api/sessions/{id}.
+func (s *Store) GetSession(id string) (*SessionDetail, error) {
+	if s.db == nil {
+		return nil, apperrors.NewNotFoundError("Session not found", nil)
+	}
+	session, err := s.db.GetSession(id)
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("failed to load session", err)
+	}
+	if session == nil {
+		return nil, apperrors.NewNotFoundError("Session not found", nil)
+	}
+	return session, nil
+}
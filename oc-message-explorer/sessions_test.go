@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncSessionInfo(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sm := NewSyncManager(db, nil, t.TempDir(), nil)
+	project := filepath.Join(sm.sessionPath, "proj1")
+	if err := os.MkdirAll(project, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeSession := func(id, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(project, id+".json"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeSession("ses_a", `{"id":"ses_a","version":"0.15.2","projectID":"proj1","directory":"/src/parser","title":"Fix the parser","time":{"created":1759309200000,"updated":1759312800000}}`)
+	writeSession("ses_b", `{"id":"ses_b","version":"0.15.2","projectID":"proj1","directory":"/src/parser","parentID":"ses_a","title":"Explore the tests (@general subagent)","time":{"created":1759309300000,"updated":1759309400000}}`)
+	writeSession("broken", `{"title":"no id"}`)
+
+	if err := db.EnsureFolder(openChatFolder()); err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range []*MessageNode{
+		{ID: "msg_1", Type: "user", Content: "fix it", Timestamp: "2025-10-01T09:00:00Z", SessionID: "ses_a"},
+		{ID: "msg_2", Type: "response", Content: "done", Timestamp: "2025-10-01T09:05:00Z", SessionID: "ses_a"},
+		{ID: "msg_3", Type: "user", Content: "look around", Timestamp: "2025-10-01T09:02:00Z", SessionID: "ses_b"},
+	} {
+		if err := db.InsertNode("openchat", node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := sm.syncSessionInfo(); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := db.ListSessions(SessionFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != "ses_a" {
+		t.Fatalf("expected ses_a then ses_b, got %+v", sessions)
+	}
+	a := sessions[0]
+	if a.Title != "Fix the parser" || a.Directory != "/src/parser" || a.ProjectID != "proj1" || a.Version != "0.15.2" || a.Source != "opencode" {
+		t.Errorf("unexpected session %+v", a)
+	}
+	if a.MessageCount != 2 || a.FirstMessageAt != "2025-10-01T09:00:00Z" || a.LastMessageAt != "2025-10-01T09:05:00Z" {
+		t.Errorf("unexpected message counts %+v", a)
+	}
+
+	detail, err := db.GetSession("ses_a")
+	if err != nil || detail == nil {
+		t.Fatalf("expected ses_a: %v", err)
+	}
+	if len(detail.Subsessions) != 1 || detail.Subsessions[0].ID != "ses_b" || detail.Subsessions[0].MessageCount != 1 {
+		t.Errorf("expected ses_b as a subsession, got %+v", detail.Subsessions)
+	}
+	if missing, err := db.GetSession("ses_missing"); err != nil || missing != nil {
+		t.Errorf("expected no session, got %+v (%v)", missing, err)
+	}
+
+	// OpenCode titles a session after its first message.
+	writeSession("ses_a", `{"id":"ses_a","version":"0.15.2","projectID":"proj1","directory":"/src/parser","title":"Off-by-one in the tokenizer","time":{"created":1759309200000,"updated":1759316400000}}`)
+	if err := sm.syncSessionInfo(); err != nil {
+		t.Fatal(err)
+	}
+	detail, err = db.GetSession("ses_a")
+	if err != nil || detail.Title != "Off-by-one in the tokenizer" {
+		t.Errorf("expected the new title, got %+v (%v)", detail, err)
+	}
+}
+
+func TestSubagentSessions(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	sm := NewSyncManager(store.db, store, t.TempDir(), nil)
+	sm.historySources = nil
+
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session := func(id, content string) {
+		write(filepath.Join(sm.sessionPath, "proj1", id+".json"), content)
+	}
+	session("ses_main", `{"id":"ses_main","projectID":"proj1","title":"Refactor the lexer","time":{"created":1759309200000,"updated":1759309900000}}`)
+	session("ses_explore", `{"id":"ses_explore","projectID":"proj1","parentID":"ses_main","title":"Explore the tests (@general subagent)","time":{"created":1759309260000,"updated":1759309300000}}`)
+	session("ses_deep", `{"id":"ses_deep","projectID":"proj1","parentID":"ses_explore","title":"Read the fixtures (@general subagent)","time":{"created":1759309270000,"updated":1759309280000}}`)
+
+	write(filepath.Join(sm.msgPath, "ses_main", "msg_1.json"), `{"id":"msg_1","sessionID":"ses_main","role":"assistant","time":{"created":1759309250000}}`)
+	// Recent OpenCode versions record the spawned session on the call;
+	// older ones leave it to be matched on the title.
+	write(filepath.Join(sm.partPath, "msg_1", "prt_1.json"), `{"id":"prt_1","sessionID":"ses_main","messageID":"msg_1","type":"tool","tool":"task","callID":"call_1","state":{"status":"completed","input":{"description":"Explore the tests","prompt":"...","subagent_type":"general"},"metadata":{"sessionId":"ses_explore"},"time":{"start":1759309255000,"end":1759309310000}}}`)
+	write(filepath.Join(sm.partPath, "msg_1", "prt_2.json"), `{"id":"prt_2","sessionID":"ses_main","messageID":"msg_1","type":"tool","tool":"task","callID":"call_2","state":{"status":"completed","input":{"description":"Check the docs","prompt":"...","subagent_type":"docs"},"time":{"start":1759309255000,"end":1759309400000}}}`)
+	write(filepath.Join(sm.msgPath, "ses_explore", "msg_2.json"), `{"id":"msg_2","sessionID":"ses_explore","role":"assistant","time":{"created":1759309265000}}`)
+	write(filepath.Join(sm.partPath, "msg_2", "prt_3.json"), `{"id":"prt_3","sessionID":"ses_explore","messageID":"msg_2","type":"tool","tool":"task","callID":"call_3","state":{"status":"running","input":{"description":"Read the fixtures","subagent_type":"general"},"time":{"start":1759309268000}}}`)
+	syncAndHydrate(sm)
+
+	parts, err := store.db.GetParts("msg_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 || parts[0].ChildSessionID != "ses_explore" || parts[1].ChildSessionID != "" {
+		t.Fatalf("expected only the first call to be linked, got %+v", parts)
+	}
+	if parts, err := store.db.GetParts("msg_2"); err != nil || len(parts) != 1 || parts[0].ChildSessionID != "ses_deep" {
+		t.Errorf("expected the running call to be linked by its title, got %+v (%v)", parts, err)
+	}
+
+	// The docs session is read after the call that spawned it.
+	session("ses_docs", `{"id":"ses_docs","projectID":"proj1","parentID":"ses_main","title":"Check the docs (@docs subagent)","time":{"created":1759309320000,"updated":1759309390000}}`)
+	if err := sm.syncSessionInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if parts, err := store.db.GetParts("msg_1"); err != nil || parts[1].ChildSessionID != "ses_docs" {
+		t.Errorf("expected the docs call to be linked once its session was read, got %+v (%v)", parts, err)
+	}
+
+	tree, err := store.GetSessionTree("ses_main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.Children) != 2 || tree.SpawnedBy != nil {
+		t.Fatalf("expected two subagent sessions, got %+v", tree.Children)
+	}
+	docs, explore := tree.Children[0], tree.Children[1]
+	if docs.ID != "ses_docs" || docs.SpawnedBy == nil || docs.SpawnedBy.PartID != "prt_2" || docs.SpawnedBy.Agent != "docs" {
+		t.Errorf("unexpected docs subtree %+v (%+v)", docs.Session, docs.SpawnedBy)
+	}
+	want := SubagentCall{PartID: "prt_1", MessageID: "msg_1", CallID: "call_1", Description: "Explore the tests", Agent: "general", Status: "completed"}
+	if explore.ID != "ses_explore" || explore.SpawnedBy == nil || *explore.SpawnedBy != want {
+		t.Errorf("unexpected explore subtree %+v (%+v)", explore.Session, explore.SpawnedBy)
+	}
+	if len(explore.Children) != 1 || explore.Children[0].ID != "ses_deep" || explore.Children[0].SpawnedBy.PartID != "prt_3" {
+		t.Errorf("expected the nested subagent session, got %+v", explore.Children)
+	}
+	if _, err := store.GetSessionTree("ses_missing"); err == nil {
+		t.Error("expected an unknown session to be an error")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultSourceColor is used for configured sources that set no color.
+const defaultSourceColor = "#a78bfa"
+
+// defaultHistorySources are the history files every install knows about.
+func defaultHistorySources(dataPath string) []HistorySource {
+	localPath := filepath.Dir(filepath.Dir(dataPath))
+
+	return []HistorySource{
+		{
+			Path:     filepath.Join(localPath, "state", "opencode", "prompt-history.jsonl"),
+			FolderID: "webui",
+			Name:     "Web UI History",
+			Color:    "#6b8afd",
+			Format:   "opencode",
+		},
+		{
+			Path:     filepath.Join(localPath, "share", "amp", "history.jsonl"),
+			FolderID: "amp",
+			Name:     "AMP History",
+			Color:    "#4ade80",
+			Format:   "amp",
+		},
+	}
+}
+
+// mergeHistorySources applies configured sources to the defaults. A
+// configured source with a default's folder overrides the fields it sets,
+// which is how a default is disabled or pointed elsewhere; any other is
+// added. Sources without a path or folder are ignored.
+func mergeHistorySources(defaults, configured []HistorySource) []HistorySource {
+	sources := append([]HistorySource(nil), defaults...)
+
+	for _, source := range configured {
+		if source.FolderID == "" || source.FolderID == "openchat" {
+			log.Printf("[CONFIG] Ignoring history source %q: it needs a folder other than openchat", source.Path)
+			continue
+		}
+		source.Path = expandHome(source.Path)
+
+		merged := false
+		for i := range sources {
+			if sources[i].FolderID != source.FolderID {
+				continue
+			}
+			if source.Path != "" {
+				sources[i].Path = source.Path
+			}
+			if source.Name != "" {
+				sources[i].Name = source.Name
+			}
+			if source.Color != "" {
+				sources[i].Color = source.Color
+			}
+			if source.Format != "" {
+				sources[i].Format = source.Format
+			}
+			sources[i].Disabled = source.Disabled
+			merged = true
+			break
+		}
+		if merged {
+			continue
+		}
+
+		if source.Path == "" {
+			log.Printf("[CONFIG] Ignoring history source %q: it has no path", source.FolderID)
+			continue
+		}
+		if source.Name == "" {
+			source.Name = source.FolderID
+		}
+		if source.Color == "" {
+			source.Color = defaultSourceColor
+		}
+		sources = append(sources, source)
+	}
+
+	return sources
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+func (cm *ConfigManager) getHistorySources() []HistorySource {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return append([]HistorySource(nil), cm.HistorySources...)
+}
+
+// SourceStatus is what /api/sources reports for a history source.
+type SourceStatus struct {
+	FolderID   string `json:"folder"`
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	Color      string `json:"color"`
+	Format     string `json:"format,omitempty"`   // as configured
+	Importer   string `json:"importer,omitempty"` // the format in use, configured or detected
+	Enabled    bool   `json:"enabled"`
+	Builtin    bool   `json:"builtin"`
+	Exists     bool   `json:"exists"`
+	Messages   int    `json:"messages"` // nodes in its folder
+	LastSyncAt string `json:"lastSyncAt,omitempty"`
+	LastError  string `json:"lastError,omitempty"`
+}
+
+// sourceSync is the outcome of a source's last sync.
+type sourceSync struct {
+	at  time.Time
+	err error
+}
+
+// recordSourceSync remembers how a source's sync went for /api/sources.
+// It is kept on the Store, keyed by the folder the source syncs into.
+func (sm *SyncManager) recordSourceSync(source HistorySource, err error) {
+	if sm.store == nil {
+		return
+	}
+	sm.store.mu.Lock()
+	defer sm.store.mu.Unlock()
+
+	if sm.store.sourceSyncs == nil {
+		sm.store.sourceSyncs = make(map[string]sourceSync)
+	}
+	sm.store.sourceSyncs[source.FolderID] = sourceSync{at: time.Now(), err: err}
+}
+
+// SourceStatuses lists every history source, disabled ones included.
+func (s *Store) SourceStatuses() ([]SourceStatus, error) {
+	var configured []HistorySource
+	if configManager != nil {
+		configured = configManager.getHistorySources()
+	}
+	defaults := defaultHistorySources(s.dataPath)
+	sources := mergeHistorySources(defaults, configured)
+
+	s.mu.RLock()
+	syncs := make(map[string]sourceSync, len(s.sourceSyncs))
+	for folderID, last := range s.sourceSyncs {
+		syncs[folderID] = last
+	}
+	s.mu.RUnlock()
+
+	statuses := make([]SourceStatus, 0, len(sources))
+	for i, source := range sources {
+		status := SourceStatus{
+			FolderID: source.FolderID,
+			Name:     source.Name,
+			Path:     source.Path,
+			Color:    source.Color,
+			Format:   source.Format,
+			Enabled:  !source.Disabled,
+			Builtin:  i < len(defaults),
+		}
+
+		sessions := false
+		if _, err := os.Stat(source.Path); err == nil {
+			status.Exists = true
+			if importer, err := sourceImporter(source); err == nil {
+				status.Importer = importer.Name()
+				_, sessions = importer.(SessionImporter)
+			} else {
+				status.LastError = err.Error()
+			}
+		}
+
+		if s.db != nil {
+			countNodes := s.db.CountFolderNodes
+			if sessions {
+				countNodes = s.db.CountSessionFolderNodes
+			}
+			count, err := countNodes(source.FolderID)
+			if err != nil {
+				return nil, err
+			}
+			status.Messages = count
+		}
+
+		if last, ok := syncs[source.FolderID]; ok {
+			status.LastSyncAt = last.at.Format(time.RFC3339)
+			if last.err != nil {
+				status.LastError = last.err.Error()
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -10,7 +10,9 @@ let searchQuery = '';
 let searchTimeout = null;
 let lastRenderQuery = '';
 let searchResults = {};
+let searchResultMeta = {};
 let searchAbortController = null;
+const SEARCH_PAGE_LIMIT = 500;
 let lastErrorAlertTime = 0;
 let userOnlyFilter = false;
 const pendingNodeLoads = new Map();
@@ -67,7 +69,17 @@ async function fetchWithRetry(url, options = {}, retryConfig = {}) {
             console.log(`[FETCH] ${context} ← STATUS ${response.status} (${elapsed}ms) ${url}`);
 
             if (!response.ok) {
-                throw new Error(`Server returned ${response.status} ${response.statusText}`);
+                const error = new Error(`Server returned ${response.status} ${response.statusText}`);
+                error.status = response.status;
+                if (response.status >= 400 && response.status < 500) {
+                    // Client errors will not succeed on retry; surface the server's message instead
+                    const body = await response.json().catch(() => null);
+                    if (body && body.error) {
+                        error.message = body.error;
+                    }
+                    error.retryable = false;
+                }
+                throw error;
             }
 
             const totalTime = Date.now() - startTime;
@@ -90,6 +102,10 @@ async function fetchWithRetry(url, options = {}, retryConfig = {}) {
                 console.log(`[FETCH] ${context} ✗ ERROR ${url} on attempt ${attempt + 1}:`, err.message);
             }
 
+            if (err.retryable === false) {
+                throw err;
+            }
+
             if (attempt < maxRetries && !abortSignal?.aborted) {
                 const backoffDelay = Math.min(backoffBase * Math.pow(2, attempt), 30000);
                 console.log(`[FETCH] ${context} ⏳ RETRYING ${url} after ${backoffDelay}ms delay...`);
@@ -121,6 +137,12 @@ let sortAscending = false;
 let searchModeRaw = false;
 let displayModeRaw = true;
 let hideEmptyResponses = true;
+let showDeletedUpstream = false;
+let groupBySession = false;
+let sessionsById = {};
+let currentProjectId = 'all';
+let projects = [];
+let projectSessionIds = null;
 let viewportObserver = null;
 let loadingViewportNodes = new Set();
 const DELETED_FOLDERS_MAP = {};
@@ -167,6 +189,7 @@ function init() {
 
     connectWebSocket();
     console.log('[INIT] WebSocket initialized');
+    loadProjects();
 
     const searchBox = document.getElementById('searchBox');
     if (searchBox) {
@@ -363,6 +386,12 @@ function connectWebSocket() {
             if (message.type === 'init') {
                 hideLoadingScreen();
             }
+        } else if (message.type === 'nodes') {
+            applyNodeUpdates(message.data);
+        } else if (message.type === 'sessions') {
+            mergeSessions(message.data);
+        } else if (message.type === 'projects') {
+            loadProjects();
         } else if (message.type === 'progress') {
             handleProgress(message.data);
         }
@@ -378,6 +407,26 @@ function connectWebSocket() {
     };
 }
 
+// Merges nodes the server picked up from disk without reloading every folder.
+function applyNodeUpdates(update) {
+    if (!update || !update.nodes || update.nodes.length === 0) return;
+
+    if (!folders[update.folderId]) {
+        folders[update.folderId] = { id: update.folderId, name: update.folderId, nodes: {} };
+        updateFolderSelector();
+    }
+    const folder = folders[update.folderId];
+    folder.nodes = folder.nodes || {};
+    update.nodes.forEach(node => {
+        folder.nodes[node.id] = node;
+    });
+
+    updateAllMessages();
+    renderTree();
+    updateGraph();
+    updateTagCloud();
+}
+
 function updateAllMessages() {
     allMessages = {};
     nodeFolderMap.clear();
@@ -412,17 +461,11 @@ function updateAllMessages() {
         }
     }
 
-    // Sort children of each node chronologically (ascending)
+    // Sort children in their saved manual order, then chronologically (ascending)
     for (const nodeId in allMessages) {
         const node = allMessages[nodeId];
         if (node.children && node.children.length > 1) {
-            node.children.sort((aId, bId) => {
-                const a = allMessages[aId];
-                const b = allMessages[bId];
-                const timeA = a?.timestamp ? new Date(a.timestamp).getTime() : 0;
-                const timeB = b?.timestamp ? new Date(b.timestamp).getTime() : 0;
-                return (isNaN(timeA) ? 0 : timeA) - (isNaN(timeB) ? 0 : timeB);
-            });
+            node.children.sort((aId, bId) => compareSiblings(allMessages[aId], allMessages[bId]));
         }
     }
 
@@ -430,6 +473,39 @@ function updateAllMessages() {
     console.log(`[UPDATE] Folder breakdown:`, folderBreakdown);
 }
 
+// compareSiblings mirrors the server's sibling order: manually ranked
+// messages first, by rank, then the rest oldest first.
+function compareSiblings(a, b) {
+    const rankA = a?.sortIndex || 0;
+    const rankB = b?.sortIndex || 0;
+    if ((rankA === 0) !== (rankB === 0)) return rankA === 0 ? 1 : -1;
+    if (rankA !== rankB) return rankA - rankB;
+
+    const timeA = a?.timestamp ? new Date(a.timestamp).getTime() : 0;
+    const timeB = b?.timestamp ? new Date(b.timestamp).getTime() : 0;
+    const diff = (isNaN(timeA) ? 0 : timeA) - (isNaN(timeB) ? 0 : timeB);
+    if (diff !== 0) return diff;
+
+    const idA = a?.id || '';
+    const idB = b?.id || '';
+    return idA < idB ? -1 : idA > idB ? 1 : 0;
+}
+
+// compareRoots orders root messages the way the tree shows them: manually
+// ranked ones first, by rank, then the rest by time, newest first when the
+// "Newest first" toggle is on.
+function compareRoots(a, b) {
+    const rankA = a.sortIndex || 0;
+    const rankB = b.sortIndex || 0;
+    if (rankA !== 0 || rankB !== 0) {
+        if (rankA === 0 || rankB === 0) return rankA === 0 ? 1 : -1;
+        return rankA - rankB;
+    }
+    const dateA = a.timestamp ? new Date(a.timestamp).getTime() : 0;
+    const dateB = b.timestamp ? new Date(b.timestamp).getTime() : 0;
+    return sortAscending ? dateB - dateA : dateA - dateB;
+}
+
 function renderFolders() {
     const list = document.getElementById('folderList');
     if (!list) {
@@ -494,6 +570,70 @@ function updateFolderSelector() {
     }
 }
 
+// Projects come from /api/projects; picking one limits the tree to the
+// messages of its sessions.
+function loadProjects() {
+    return fetch('/api/projects')
+        .then(ensureOk)
+        .then(res => res.json())
+        .then(list => {
+            projects = list || [];
+            updateProjectSelector();
+        })
+        .catch(err => {
+            console.error('Failed to load projects:', err);
+        });
+}
+
+function updateProjectSelector() {
+    const selector = document.getElementById('projectSelector');
+    if (!selector) return;
+
+    selector.innerHTML = '<option value="all">All Projects</option>';
+    projects.forEach(project => {
+        const option = document.createElement('option');
+        option.value = project.id;
+        option.textContent = `${project.name || project.id} (${project.sessionCount})`;
+        option.title = project.worktree || project.id;
+        selector.appendChild(option);
+    });
+
+    if (currentProjectId !== 'all' && !projects.some(p => p.id === currentProjectId)) {
+        currentProjectId = 'all';
+        projectSessionIds = null;
+        renderTree();
+    }
+    selector.value = currentProjectId;
+}
+
+function selectProject(id) {
+    currentProjectId = id;
+    if (id === 'all') {
+        projectSessionIds = null;
+        renderTree();
+        updateGraph();
+        return;
+    }
+    fetch(`/api/projects/${encodeURIComponent(id)}`)
+        .then(ensureOk)
+        .then(res => res.json())
+        .then(project => {
+            if (currentProjectId !== id) return;
+            projectSessionIds = new Set((project.sessions || []).map(s => s.id));
+            mergeSessions(project.sessions);
+            renderTree();
+            updateGraph();
+        })
+        .catch(err => {
+            console.error('Failed to load project:', err);
+            showNotification('Failed to load project', 'error');
+        });
+}
+
+function inSelectedProject(node) {
+    return !projectSessionIds || projectSessionIds.has(node.sessionId);
+}
+
 function updateTagCloud() {
     const container = document.getElementById('tagCloud');
     const tagCounts = {};
@@ -561,6 +701,87 @@ function toggleHideEmptyResponses() {
     updateGraph();
 }
 
+function toggleShowDeletedUpstream() {
+    showDeletedUpstream = document.getElementById('showDeletedUpstream').checked;
+    const toggle = document.getElementById('deletedToggle');
+    toggle.classList.toggle('active', showDeletedUpstream);
+    if (searchQuery) {
+        // Search results come from the server, which hides them too.
+        filterMessages(true);
+    }
+    renderTree();
+    updateGraph();
+}
+
+function toggleGroupBySession() {
+    groupBySession = document.getElementById('groupBySession').checked;
+    const toggle = document.getElementById('groupToggle');
+    toggle.classList.toggle('active', groupBySession);
+    if (groupBySession) {
+        loadSessions();
+    }
+    renderTree();
+}
+
+// Session titles come from /api/sessions; the watcher pushes changes.
+function loadSessions() {
+    return fetch('/api/sessions')
+        .then(ensureOk)
+        .then(res => res.json())
+        .then(sessions => {
+            sessionsById = {};
+            mergeSessions(sessions);
+        })
+        .catch(err => {
+            console.error('Failed to load sessions:', err);
+        });
+}
+
+function mergeSessions(sessions) {
+    (sessions || []).forEach(session => {
+        sessionsById[session.id] = { ...sessionsById[session.id], ...session };
+        // New sessions of the selected project join it as they arrive.
+        if (projectSessionIds && session.projectId === currentProjectId) {
+            projectSessionIds.add(session.id);
+        }
+    });
+    if (groupBySession) {
+        renderTree();
+    }
+}
+
+function sessionGroupTitle(sessionId) {
+    if (!sessionId) return 'No session';
+    const session = sessionsById[sessionId];
+    return (session && session.title) || sessionId;
+}
+
+// Groups sorted root nodes by session, keeping the order in which each
+// session first appears.
+function groupRootsBySession(rootNodes) {
+    const groups = new Map();
+    rootNodes.forEach(node => {
+        const key = node.sessionId || '';
+        if (!groups.has(key)) groups.set(key, []);
+        groups.get(key).push(node);
+    });
+    return groups;
+}
+
+function createSessionGroupHeader(sessionId, count) {
+    const header = document.createElement('div');
+    header.className = 'session-group-header';
+    const session = sessionsById[sessionId];
+    const meta = [`${count} ${count === 1 ? 'thread' : 'threads'}`];
+    if (session && session.directory) meta.push(session.directory);
+    if (session && session.errorCount) meta.push(`${session.errorCount} failed`);
+    if (session && session.abortedCount) meta.push(`${session.abortedCount} aborted`);
+    header.innerHTML = `<span>${escapeHtml(sessionGroupTitle(sessionId))}</span>` +
+        `<span class="session-group-meta">${escapeHtml(meta.join(' · '))}</span>`;
+    if (sessionId) header.title = sessionId;
+    return header;
+}
+
 function toggleSortOrder() {
     sortAscending = document.getElementById('sortAscending').checked;
     renderTree();
@@ -615,6 +836,14 @@ function getMessagesToDisplay() {
             include = false;
         }
 
+        if (include && node.deletedUpstreamAt && !showDeletedUpstream) {
+            include = false;
+        }
+
+        if (include && !inSelectedProject(node)) {
+            include = false;
+        }
+
         if (include && hideEmptyResponses && node.type === 'response') {
             const isEmpty = !node.content || node.content.trim() === '' || node.content.length === 0;
             if (isEmpty) {
@@ -671,6 +900,7 @@ function filterMessages(immediate = false) {
             console.log('[SEARCH] Query too short, clearing results and canceling loads');
             searchQuery = '';
             searchResults = {};
+            searchResultMeta = {};
             cancelAllNodeLoads();
             loadingViewportNodes.clear();
             if (viewportObserver) {
@@ -697,7 +927,7 @@ function filterMessages(immediate = false) {
         fetchWithRetry(searchUrl, {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ query, searchRaw: false })
+            body: JSON.stringify({ query, searchRaw: false, limit: SEARCH_PAGE_LIMIT, includeDeleted: showDeletedUpstream })
         }, {
             maxRetries: 3,
             timeout: 30000,
@@ -708,14 +938,18 @@ function filterMessages(immediate = false) {
                 console.log('[SEARCH] Response status:', res.status);
                 return res.json();
             })
-            .then(results => {
-                console.log('[SEARCH] Got results:', Object.keys(results || {}).length);
-                if (results) {
-                    searchResults = results;
-                    expandSearchResults(results);
-                } else {
-                    searchResults = {};
+            .then(response => {
+                const results = {};
+                const meta = {};
+                for (const result of response?.results || []) {
+                    const id = result.node.id;
+                    results[id] = allMessages[id] || result.node;
+                    meta[id] = result;
                 }
+                console.log(`[SEARCH] Got ${Object.keys(results).length} of ${response?.total || 0} results`);
+                searchResults = results;
+                searchResultMeta = meta;
+                expandSearchResults(results);
                 renderTree();
             })
             .catch(err => {
@@ -725,8 +959,14 @@ function filterMessages(immediate = false) {
                 }
                 console.error('[SEARCH] Search error:', err);
                 searchResults = {};
+                searchResultMeta = {};
                 renderTree();
 
+                if (err.status === 400) {
+                    showNotification(`Invalid search: ${err.message}`, 'error');
+                    return;
+                }
+
                 const now = Date.now();
                 const errorMessage = err.message || 'Search failed. Make sure the server is running.';
 
@@ -842,11 +1082,7 @@ function renderTree() {
     // A node is a root if it has no parentId OR if its parent is not in the currently filtered messages set (orphan)
     let rootNodes = Object.values(validMessages).filter(n => !n.parentId || !validMessages[n.parentId]);
 
-    rootNodes.sort((a, b) => {
-        const dateA = a.timestamp ? new Date(a.timestamp).getTime() : 0;
-        const dateB = b.timestamp ? new Date(b.timestamp).getTime() : 0;
-        return sortAscending ? dateB - dateA : dateA - dateB;
-    });
+    rootNodes.sort(compareRoots);
 
     if (rootNodes.length > 0) {
         console.log(`[RENDER] Sample timestamps (first 3 newest, last 3 oldest):`);
@@ -874,9 +1110,18 @@ function renderTree() {
     }
 
     container.innerHTML = '';
-    rootNodes.forEach(node => {
-        container.appendChild(createNodeElement(node, messages, true));
-    });
+    if (groupBySession) {
+        groupRootsBySession(rootNodes).forEach((nodes, sessionId) => {
+            container.appendChild(createSessionGroupHeader(sessionId, nodes.length));
+            nodes.forEach(node => {
+                container.appendChild(createNodeElement(node, messages, true));
+            });
+        });
+    } else {
+        rootNodes.forEach(node => {
+            container.appendChild(createNodeElement(node, messages, true));
+        });
+    }
 
     observeVisibleNodes();
 }
@@ -892,6 +1137,14 @@ function applyFilters(messages) {
             include = false;
         }
 
+        if (include && node.deletedUpstreamAt && !showDeletedUpstream) {
+            include = false;
+        }
+
+        if (include && !inSelectedProject(node)) {
+            include = false;
+        }
+
         if (include && hideEmptyResponses && node.type === 'response') {
             const isEmpty = !node.content || node.content.trim() === '' || node.content.length === 0;
             if (isEmpty) {
@@ -929,6 +1182,22 @@ function applyFilters(messages) {
     return filtered;
 }
 
+// The model behind a response, with its tokens and cost on hover.
+function renderNodeUsage(node) {
+    if (node.type !== 'response' || !node.modelId) return '';
+    const details = [node.providerId ? `${node.providerId}/${node.modelId}` : node.modelId];
+    if (node.agent) details.push(`agent: ${node.agent}`);
+    if (node.tokens) {
+        const t = node.tokens;
+        details.push(`tokens: ${t.input} in, ${t.output} out, ${t.reasoning} reasoning, ${t.cacheRead} cache read, ${t.cacheWrite} cache write`);
+    }
+    if (node.latencyMs) {
+        details.push(`took ${(node.latencyMs / 1000).toFixed(1)}s`);
+    }
+    const cost = node.cost ? ` · $${node.cost.toFixed(node.cost < 0.01 ? 4 : 2)}` : '';
+    return `<span class="node-usage" title="${escapeHtml(details.join('\n'))}">${escapeHtml(node.modelId)}${cost}</span>`;
+}
+
 function createNodeElement(node, messages, isRoot = false) {
     const div = document.createElement('div');
     div.className = `tree-node ${isRoot ? 'tree-root' : ''}`;
@@ -951,7 +1220,7 @@ function createNodeElement(node, messages, isRoot = false) {
     const folderInfo = getFolderInfo(node.id);
 
     div.innerHTML = `
-        <div class="node-content ${node.type}-node ${node.selected ? 'selected' : ''}"
+        <div class="node-content ${node.type}-node ${node.selected ? 'selected' : ''} ${node.deletedUpstreamAt ? 'deleted-upstream' : ''}"
              role="treeitem"
              aria-expanded="${node.expanded}"
              aria-selected="${node.selected}"
@@ -966,10 +1235,17 @@ function createNodeElement(node, messages, isRoot = false) {
             <div class="node-content-wrapper">
                 <div class="node-header">
                     <span class="node-text">${escapeHtml(displayContent)}</span>
+                    ${node.deletedUpstreamAt ? `<span class="node-deleted-badge" title="Source file deleted upstream ${escapeHtml(formatTimestamp(node.deletedUpstreamAt))}">deleted upstream</span>` : ''}
+                    ${node.boundary ? `<span class="node-boundary-badge" title="${node.boundary === 'summary' ? 'Summary that replaced the earlier context of this session' : 'Asked OpenCode to compact this session'}">${escapeHtml(node.boundary)}</span>` : ''}
+                    ${node.error ? `<span class="node-error-badge ${escapeHtml(node.error.kind)}" title="${escapeHtml(node.error.name + (node.error.message ? ': ' + node.error.message : ''))}">${node.error.kind === 'aborted' ? 'aborted' : 'error: ' + escapeHtml(node.error.kind)}</span>` : ''}
                 </div>
+                ${renderSearchSnippets(node.id)}
                 <div class="node-meta">
                     ${folderInfo ? `<div class="node-folder"><span class="folder-color" style="background: ${folderInfo.color}"></span>${escapeHtml(folderInfo.name)}</div>` : ''}
-                    <span class="node-timestamp" aria-label="Timestamp: ${timestamp}">${timestamp}</span>
+                    ${node.timestampEstimated
+                        ? `<span class="node-timestamp estimated" title="Estimated: this history entry has no recorded time" aria-label="Estimated timestamp: ${timestamp}">~${timestamp}</span>`
+                        : `<span class="node-timestamp" aria-label="Timestamp: ${timestamp}">${timestamp}</span>`}
+                    ${renderNodeUsage(node)}
                     ${hasChildren ? `<span style="color: var(--text-secondary); font-size: 12px; margin-left: 8px;" aria-label="${node.children.length} child message${node.children.length > 1 ? 's' : ''}">${node.children.length} child(ren)</span>` : ''}
                 </div>
             </div>
@@ -1143,26 +1419,76 @@ function createNodeElement(node, messages, isRoot = false) {
         draggedNodeId = null;
         div.classList.remove('dragging');
     };
+    const clearDropMarkers = () => div.classList.remove('drag-over', 'drag-before', 'drag-after');
     div.ondragover = (e) => {
         e.preventDefault();
+        e.stopPropagation();
+        clearDropMarkers();
         if (draggedNodeId && draggedNodeId !== node.id) {
-            div.classList.add('drag-over');
+            const position = dropPosition(e, div);
+            div.classList.add(position === 'inside' ? 'drag-over' : `drag-${position}`);
         }
     };
     div.ondragleave = (e) => {
-        div.classList.remove('drag-over');
+        clearDropMarkers();
     };
     div.ondrop = (e) => {
         e.preventDefault();
-        div.classList.remove('drag-over');
-        if (draggedNodeId && draggedNodeId !== node.id) {
-            moveNode(draggedNodeId, node.id, 0);
+        e.stopPropagation();
+        clearDropMarkers();
+        if (!draggedNodeId || draggedNodeId === node.id) return;
+
+        if (nodeFolderMap.get(draggedNodeId) !== nodeFolderMap.get(node.id)) {
+            showNotification('Messages can only be moved within their folder', 'error');
+            return;
         }
+
+        const position = dropPosition(e, div);
+        if (position === 'inside') {
+            moveNode(draggedNodeId, node.id, { newIndex: 0 });
+            return;
+        }
+
+        const parentId = node.parentId && allMessages[node.parentId] ? node.parentId : '';
+        const siblings = siblingIdsFor(parentId, node.id).filter(id => id !== draggedNodeId);
+        const targetIndex = siblings.indexOf(node.id);
+        if (targetIndex < 0) {
+            moveNode(draggedNodeId, parentId, { newIndex: -1 });
+            return;
+        }
+        const precedingIds = siblings.slice(0, targetIndex + (position === 'after' ? 1 : 0));
+        moveNode(draggedNodeId, parentId, { precedingIds });
     };
 
     return div;
 }
 
+// dropPosition splits a row into before/inside/after zones so a drag can
+// either nest a message or place it next to its siblings.
+function dropPosition(e, div) {
+    const row = div.querySelector('.node-content') || div;
+    const rect = row.getBoundingClientRect();
+    const offset = e.clientY - rect.top;
+    if (offset < rect.height * 0.25) return 'before';
+    if (offset > rect.height * 0.75) return 'after';
+    return 'inside';
+}
+
+// siblingIdsFor lists a node's siblings in the order the tree shows them,
+// so the ones above a drop point can be sent to /api/reorder. Root
+// siblings are scoped to one folder.
+function siblingIdsFor(parentId, nodeId) {
+    if (parentId) {
+        return [...(allMessages[parentId]?.children || [])];
+    }
+
+    const folderId = nodeFolderMap.get(nodeId);
+    return Object.values(allMessages)
+        .filter(n => !n.parentId && nodeFolderMap.get(n.id) === folderId)
+        .sort(compareRoots)
+        .map(n => n.id);
+}
+
 function getFolderInfo(nodeId) {
     const folderId = nodeFolderMap.get(nodeId);
     if (folderId && folders[folderId]) {
@@ -1549,6 +1875,32 @@ function formatTimestamp(timestamp) {
     return `${relativeTime} [${fullTime}]`;
 }
 
+// Snippets come from the server already HTML-escaped, with hits in <mark>.
+function renderSearchSnippets(nodeId) {
+    const meta = searchQuery ? searchResultMeta[nodeId] : null;
+    if (!meta || !meta.snippets || meta.snippets.length === 0) return '';
+
+    return meta.snippets
+        .map(snippet => `<div class="node-snippet" data-field="${snippet.field}">${snippet.text}</div>`)
+        .join('');
+}
+
+// ensureOk turns an error response from a mutation into a rejected promise
+// carrying the server's message, so callers can report the failure.
+async function ensureOk(res) {
+    if (res.ok) return res;
+    let message = `Request failed with status ${res.status}`;
+    try {
+        const body = await res.json();
+        if (body && body.error) message = body.error;
+    } catch (e) {
+        // keep the generic message
+    }
+    const error = new Error(message);
+    error.status = res.status;
+    throw error;
+}
+
 function escapeHtml(text) {
     const div = document.createElement('div');
     div.textContent = text;
@@ -1785,7 +2137,7 @@ function saveNode() {
                 method: 'PUT',
                 headers: { 'Content-Type': 'application/json' },
                 body: JSON.stringify(updatedNode)
-            });
+            }).then(ensureOk);
 
             showNotification('Message saved');
             closeEditor();
@@ -1814,7 +2166,7 @@ function saveNode() {
     undoRedoManager.pushAction(action);
     action.execute().catch(err => {
         console.error('[EDIT] Failed to save message:', err);
-        showNotification('Failed to save message');
+        showNotification(`Failed to save message: ${err.message}`, 'error');
     });
 }
 
@@ -1831,7 +2183,7 @@ function deleteNode() {
             console.log('[DELETE] Deleting message:', nodeId);
             await fetch(`/api/messages/${nodeId}`, {
                 method: 'DELETE'
-            });
+            }).then(ensureOk);
 
             for (const folderId in folders) {
                 if (folders[folderId].nodes[nodeId]) {
@@ -2038,6 +2390,7 @@ function createFolder() {
             nodes: {}
         })
     })
+        .then(ensureOk)
         .then(res => res.json())
         .then(() => {
             showNotification('Folder created');
@@ -2046,7 +2399,7 @@ function createFolder() {
         })
         .catch(err => {
             console.error('Failed to create folder:', err);
-            showNotification('Failed to create folder');
+            showNotification(`Failed to create folder: ${err.message}`, 'error');
         });
 }
 
@@ -2054,12 +2407,13 @@ function deleteFolder(folderId) {
     fetch(`/api/folders/${folderId}`, {
         method: 'DELETE'
     })
+        .then(ensureOk)
         .then(() => {
             showNotification('Folder deleted');
         })
         .catch(err => {
             console.error('Failed to delete folder:', err);
-            showNotification('Failed to delete folder');
+            showNotification(`Failed to delete folder: ${err.message}`, 'error');
         });
 }
 
@@ -2081,9 +2435,11 @@ function createMessage() {
             type,
             content,
             tags,
-            parentId
+            parentId,
+            folderId: currentFolderId
         })
     })
+        .then(ensureOk)
         .then(res => res.json())
         .then(() => {
             showNotification('Message created');
@@ -2093,7 +2449,7 @@ function createMessage() {
         })
         .catch(err => {
             console.error('Failed to create message:', err);
-            showNotification('Failed to create message');
+            showNotification(`Failed to create message: ${err.message}`, 'error');
         });
 }
 
@@ -2673,7 +3029,9 @@ function setupCombineDragAndDrop() {
     };
 }
 
-function moveNode(nodeId, newParentId, newIndex) {
+// moveNode places a message either after the siblings shown above it
+// ({ precedingIds }) or at an index among them ({ newIndex }).
+function moveNode(nodeId, newParentId, placement) {
     fetch('/api/reorder', {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
@@ -2681,15 +3039,16 @@ function moveNode(nodeId, newParentId, newIndex) {
             nodeId,
             folderId: currentFolderId,
             newParentId,
-            newIndex
+            ...placement
         })
     })
+        .then(ensureOk)
         .then(() => {
             showNotification('Message moved');
         })
         .catch(err => {
             console.error('Failed to move node:', err);
-            showNotification('Failed to move message');
+            showNotification(`Failed to move message: ${err.message}`, 'error');
         });
 }
 
@@ -2697,35 +3056,32 @@ function exportData() {
     window.location.href = '/api/export';
 }
 
+// Sends the file as is: the server recognises this app's exports, ChatGPT
+// and claude.ai conversations.json files, and zips of either.
 function importData(event) {
     const file = event.target.files[0];
     if (!file) return;
 
-    const reader = new FileReader();
-    reader.onload = (e) => {
-        try {
-            const data = JSON.parse(e.target.result);
-
-            fetch('/api/import', {
-                method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify(data)
-            })
-                .then(() => {
-                    showNotification('Data imported');
-                    event.target.value = '';
-                })
-                .catch(err => {
-                    console.error('Failed to import:', err);
-                    showNotification('Failed to import data');
-                });
-        } catch (err) {
-            console.error('Failed to parse JSON:', err);
-            showNotification('Failed to parse file');
-        }
-    };
-
-    reader.readAsText(file);
+    fetch('/api/import', {
+        method: 'POST',
+        headers: { 'Content-Type': file.type || 'application/octet-stream' },
+        body: file
+    })
+        .then(ensureOk)
+        .then(res => res.json())
+        .then(result => {
+            const imported = result.format === 'oc-message-explorer'
+                ? 'Data imported'
+                : `Imported ${result.count} ${result.format} conversations`;
+            showNotification(imported);
+        })
+        .catch(err => {
+            console.error('Failed to import:', err);
+            showNotification(`Failed to import data: ${err.message}`, 'error');
+        })
+        .finally(() => {
+            event.target.value = '';
+        });
 }
 
 function triggerSync() {
@@ -3258,14 +3614,18 @@ function handleProgress(data) {
             syncStatusMessage.className = 'sync-status-message syncing';
             syncStatusProgress.textContent = '';
             syncStatusActions.style.display = 'flex';
-        } else if (data.phase === 'reading' || data.phase === 'building' || data.phase === 'writing') {
+        } else if (data.phase === 'reading' || data.phase === 'building' || data.phase === 'writing' || data.phase === 'hydrating') {
             syncStatusMessage.textContent = data.message;
             syncStatusMessage.className = 'sync-status-message syncing';
             if (data.totalMessages && data.processed !== undefined) {
                 const percent = Math.round((data.processed / data.totalMessages) * 100);
                 syncStatusProgress.textContent = `${data.processed}/${data.totalMessages} (${percent}%)`;
             }
-        } else if (data.phase === 'complete') {
+        } else if (data.phase === 'complete' || data.phase === 'hydrated') {
+            if (data.phase === 'complete') {
+                if (groupBySession) loadSessions();
+                loadProjects();
+            }
             syncStatusMessage.textContent = data.message;
             syncStatusMessage.className = 'sync-status-message complete';
             syncStatusProgress.textContent = '';
@@ -3351,12 +3711,13 @@ function setupDragAndDrop() {
                     newIndex: -1
                 })
             })
+                .then(ensureOk)
                 .then(() => {
                     showNotification('Message moved');
                 })
                 .catch(err => {
                     console.error('Failed to move node:', err);
-                    showNotification('Failed to move message');
+                    showNotification(`Failed to move message: ${err.message}`, 'error');
                 });
         }
     };
@@ -3602,6 +3963,24 @@ function startSync() {
         });
 }
 
+function purgeDeletedUpstream() {
+    if (!confirm('Permanently remove messages deleted upstream? Locked messages are kept.')) return;
+
+    fetch('/api/sync/purge-deleted', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' }
+    })
+        .then(ensureOk)
+        .then(res => res.json())
+        .then(data => {
+            showNotification(data.purged ? `Purged ${data.purged} deleted messages` : 'Nothing to purge');
+        })
+        .catch(err => {
+            console.error('Failed to purge deleted messages:', err);
+            showNotification(`Failed to purge deleted messages: ${err.message}`, 'error');
+        });
+}
+
 function cancelSync() {
     fetch('/api/sync/cancel', {
         method: 'POST',
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	apperrors "oc-message-explorer/internal/errors"
+)
+
+func newTestStore(t *testing.T, dbPath string) *Store {
+	t.Helper()
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &Store{
+		Folders:     make(map[string]*Folder),
+		clients:     make(map[*websocket.Conn]bool),
+		broadcastCh: make(chan WSMessage, 100),
+		db:          db,
+	}
+}
+
+func TestStoreMutationsPersist(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	store := newTestStore(t, dbPath)
+
+	if err := store.AddFolder(&Folder{ID: "f1", Name: "Curated", Color: "#fff", CreatedAt: "2026-01-01T00:00:00Z", Nodes: map[string]*MessageNode{}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range []*MessageNode{
+		{ID: "a", Type: "prompt", Content: "first", Timestamp: "2026-01-01T10:00:00Z", HasLoaded: true},
+		{ID: "b", Type: "prompt", Content: "second", Timestamp: "2026-01-01T11:00:00Z", HasLoaded: true},
+		{ID: "c", Type: "prompt", Content: "third", Timestamp: "2026-01-01T12:00:00Z", HasLoaded: true},
+	} {
+		if err := store.AddNode("f1", node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A client that never loaded the content must not wipe it.
+	if err := store.UpdateNode(&MessageNode{ID: "a", Type: "prompt", Summary: "renamed", Timestamp: "2026-01-01T10:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MoveNode("b", "a", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteNode("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddNode("", &MessageNode{ID: "d", Type: "prompt", Content: "loose", Timestamp: "2026-01-01T13:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ImportFolders(map[string]*Folder{"f2": {Name: "Imported", Color: "#000", Nodes: map[string]*MessageNode{
+		"e": {Type: "prompt", Content: "imported", Timestamp: "2026-01-02T10:00:00Z"},
+	}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.DeleteNode("missing"); err == nil {
+		t.Error("expected an error deleting a missing node")
+	}
+
+	store.db.Close()
+	reopened := newTestStore(t, dbPath)
+	if err := reopened.loadFromDatabase(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reopened.Folders) != 3 {
+		t.Fatalf("expected folders f1, f2 and %s, got %d", userFolderID, len(reopened.Folders))
+	}
+
+	nodes := reopened.Folders["f1"].Nodes
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes in f1, got %d", len(nodes))
+	}
+	if a := nodes["a"]; a.Summary != "renamed" || a.Content != "first" {
+		t.Errorf("unexpected node a: %+v", a)
+	}
+	if b := nodes["b"]; b.ParentID != "a" {
+		t.Errorf("expected b under a, got parent %q", b.ParentID)
+	}
+	if _, exists := reopened.Folders[userFolderID].Nodes["d"]; !exists {
+		t.Error("expected d in the user folder")
+	}
+	if e := reopened.Folders["f2"].Nodes["e"]; e == nil || e.Content != "imported" {
+		t.Errorf("unexpected imported node: %+v", e)
+	}
+}
+
+func TestStoreMoveNodeKeepsOrder(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	store := newTestStore(t, dbPath)
+
+	if err := store.AddFolder(&Folder{ID: "f1", Name: "Curated", Color: "#fff", CreatedAt: "2026-01-01T00:00:00Z", Nodes: map[string]*MessageNode{}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range []*MessageNode{
+		{ID: "root1", Type: "prompt", Timestamp: "2026-01-01T10:00:00Z"},
+		{ID: "root2", Type: "prompt", Timestamp: "2026-01-01T11:00:00Z"},
+		{ID: "root3", Type: "prompt", Timestamp: "2026-01-01T12:00:00Z"},
+		{ID: "x", Type: "prompt", ParentID: "root1", Timestamp: "2026-01-01T10:01:00Z"},
+		{ID: "y", Type: "prompt", ParentID: "root1", Timestamp: "2026-01-01T10:02:00Z"},
+		{ID: "z", Type: "prompt", ParentID: "root1", Timestamp: "2026-01-01T10:03:00Z"},
+	} {
+		if err := store.AddNode("f1", node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	moves := []struct {
+		node, parent string
+		index        int
+		preceding    []string
+	}{
+		{"z", "root1", 0, nil},
+		{"y", "root1", 0, []string{"z"}},
+		// Between z and y, which keep their ranks.
+		{"x", "root1", 0, []string{"z"}},
+		{"root3", "", 0, nil},
+		// Dropped last in a view showing the newest first.
+		{"root1", "", 0, []string{"root3", "root2"}},
+		{"root2", "", -1, nil},
+	}
+	for _, move := range moves {
+		if err := store.MoveNode(move.node, move.parent, move.index, move.preceding); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if nodes := store.Folders["f1"].Nodes; nodes["root2"].SortIndex != 0 || nodes["root1"].SortIndex <= nodes["root3"].SortIndex {
+		t.Errorf("got ranks root1 %d, root2 %d, root3 %d in memory", nodes["root1"].SortIndex, nodes["root2"].SortIndex, nodes["root3"].SortIndex)
+	}
+
+	if err := store.MoveNode("root1", "x", 0, nil); err == nil {
+		t.Error("expected an error moving a node under its own child")
+	}
+	if err := store.AddFolder(&Folder{ID: "f2", Name: "Drafts", Color: "#eee", CreatedAt: "2026-01-01T00:00:00Z", Nodes: map[string]*MessageNode{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddNode("f2", &MessageNode{ID: "other", Type: "prompt", Timestamp: "2026-01-01T09:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	var appErr *apperrors.AppError
+	if err := store.MoveNode("root3", "other", 0, nil); !errors.As(err, &appErr) || appErr.Type != apperrors.ErrorTypeValidation {
+		t.Errorf("expected moving a node under a parent in another folder to be rejected, got %v", err)
+	}
+	if _, err := store.db.ReorderNode("root3", "other", 0, nil); err == nil {
+		t.Error("expected the database to refuse a parent in another folder")
+	}
+
+	store.db.Close()
+	reopened := newTestStore(t, dbPath)
+	if err := reopened.loadFromDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	nodes := reopened.Folders["f1"].Nodes
+
+	if got := strings.Join(nodes["root1"].Children, ","); got != "z,x,y" {
+		t.Errorf("children of root1 = %s, want z,x,y", got)
+	}
+	if z, x, y := nodes["z"].SortIndex, nodes["x"].SortIndex, nodes["y"].SortIndex; z != rankGap || y != 2*rankGap || x <= z || x >= y {
+		t.Errorf("got ranks z %d, x %d, y %d, want x placed between the others without moving them", z, x, y)
+	}
+
+	var roots []*MessageNode
+	for _, node := range nodes {
+		if node.ParentID == "" {
+			roots = append(roots, node)
+		}
+	}
+	sortSiblings(roots)
+	var got []string
+	for _, node := range roots {
+		got = append(got, node.ID)
+	}
+	if strings.Join(got, ",") != "root3,root1,root2" {
+		t.Errorf("roots = %v, want root3,root1,root2", got)
+	}
+}
+
+func TestStoreImportMergesFolders(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	store := newTestStore(t, dbPath)
+
+	for _, folder := range []*Folder{
+		{ID: "f1", Name: "Curated", Color: "#fff", CreatedAt: "2026-01-01T00:00:00Z", Nodes: map[string]*MessageNode{}},
+		{ID: "f2", Name: "Drafts", Color: "#eee", CreatedAt: "2026-01-01T00:00:00Z", Nodes: map[string]*MessageNode{}},
+	} {
+		if err := store.AddFolder(folder); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for folderID, node := range map[string]*MessageNode{
+		"f1": {ID: "a", Type: "prompt", Content: "kept", Timestamp: "2026-01-01T10:00:00Z", HasLoaded: true},
+		"f2": {ID: "c", Type: "prompt", Content: "draft", Timestamp: "2026-01-01T12:00:00Z", HasLoaded: true},
+	} {
+		if err := store.AddNode(folderID, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.AddNode("f1", &MessageNode{ID: "b", Type: "prompt", Content: "old", Timestamp: "2026-01-01T11:00:00Z", HasLoaded: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// f1 exists: it is renamed, b is replaced, c moves in from f2 and e is
+	// added, while a stays.
+	if err := store.ImportFolders(map[string]*Folder{"f1": {Name: "Imported", Color: "#000", Nodes: map[string]*MessageNode{
+		"b": {Type: "prompt", Content: "new", Timestamp: "2026-01-01T11:00:00Z", HasLoaded: true},
+		"c": {Type: "prompt", Content: "draft", Timestamp: "2026-01-01T12:00:00Z", HasLoaded: true},
+		"e": {Type: "prompt", Content: "added", Timestamp: "2026-01-02T10:00:00Z", HasLoaded: true},
+	}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(source string, folders map[string]*Folder) {
+		t.Helper()
+		f1 := folders["f1"]
+		if f1.Name != "Imported" || f1.Color != "#000" {
+			t.Errorf("%s: expected f1 to take the imported name and color, got %q %q", source, f1.Name, f1.Color)
+		}
+		var ids []string
+		for id := range f1.Nodes {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		if got := strings.Join(ids, ","); got != "a,b,c,e" {
+			t.Errorf("%s: got f1 nodes %s, want a,b,c,e", source, got)
+		}
+		if b := f1.Nodes["b"]; b == nil || b.Content != "new" {
+			t.Errorf("%s: expected b replaced, got %+v", source, b)
+		}
+		if len(folders["f2"].Nodes) != 0 {
+			t.Errorf("%s: expected c to leave f2, got %d nodes", source, len(folders["f2"].Nodes))
+		}
+	}
+	check("memory", store.Folders)
+
+	store.db.Close()
+	reopened := newTestStore(t, dbPath)
+	if err := reopened.loadFromDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	check("database", reopened.Folders)
+}
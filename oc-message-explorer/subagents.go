@@ -0,0 +1,190 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	apperrors "oc-message-explorer/internal/errors"
+)
+
+// SubagentCall is the task tool call a subagent session was spawned by.
+type SubagentCall struct {
+	PartID      string `json:"partId"`
+	MessageID   string `json:"messageId"`
+	CallID      string `json:"callId,omitempty"`
+	Description string `json:"description,omitempty"`
+	Agent       string `json:"agent,omitempty"` // the subagent type the call asked for
+	Status      string `json:"status,omitempty"`
+}
+
+// SessionTree is returned by GET /api/sessions/{id}/tree: a session and
+// the subagent sessions run for it, each with the call that spawned it
+// when that is known.
+type SessionTree struct {
+	*Session
+	SpawnedBy *SubagentCall  `json:"spawnedBy,omitempty"`
+	Children  []*SessionTree `json:"children"`
+}
+
+// taskInput is the part of a task call's input that identifies the
+// subagent session it spawned.
+type taskInput struct {
+	Description  string `json:"description"`
+	SubagentType string `json:"subagent_type"`
+}
+
+func parseTaskInput(input string) taskInput {
+	var task taskInput
+	if input != "" {
+		json.Unmarshal([]byte(input), &task)
+	}
+	return task
+}
+
+// linkSubagentsTx links the task calls matching scope that do not know
+// the session they spawned to one of the unlinked child sessions of
+// their session. OpenCode titles a subagent session after the call's
+// description and creates it while the call runs, so a session created
+// within the call is taken, preferring one titled after it. Calls
+// without a start time need the title to match.
+func linkSubagentsTx(tx *sql.Tx, scope string, args ...any) error {
+	rows, err := tx.Query(`
+		SELECT id, session_id, input, started_at, ended_at FROM parts
+		WHERE tool = 'task' AND child_session_id = '' AND session_id != '' AND `+scope, args...)
+	if err != nil {
+		return err
+	}
+	type call struct{ id, sessionID, input, startedAt, endedAt string }
+	var calls []call
+	for rows.Next() {
+		var c call
+		var sessionID, input, startedAt, endedAt sql.NullString
+		if err := rows.Scan(&c.id, &sessionID, &input, &startedAt, &endedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		c.sessionID, c.input, c.startedAt, c.endedAt = sessionID.String, input.String, startedAt.String, endedAt.String
+		calls = append(calls, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range calls {
+		description := parseTaskInput(c.input).Description
+		if description == "" && c.startedAt == "" {
+			continue
+		}
+		var childID string
+		err := tx.QueryRow(`
+			SELECT s.id FROM sessions s
+			WHERE s.parent_id = ?1
+			  AND NOT EXISTS (SELECT 1 FROM parts p WHERE p.child_session_id = s.id)
+			  AND CASE WHEN ?3 = ''
+			           THEN ?2 != '' AND substr(s.title, 1, length(?2)) = ?2
+			           ELSE s.created_at >= ?3 AND (?4 = '' OR s.created_at <= ?4) END
+			ORDER BY ?2 != '' AND substr(s.title, 1, length(?2)) = ?2 DESC, s.created_at, s.id
+			LIMIT 1`, c.sessionID, description, c.startedAt, c.endedAt).Scan(&childID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE parts SET child_session_id = ? WHERE id = ?", childID, c.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subagentCalls returns the task calls made in a session that are known
+// to have spawned a session, by the session they spawned.
+func (d *Database) subagentCalls(sessionID string) (map[string]*SubagentCall, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	rows, err := d.db.Query(`
+		SELECT id, message_id, call_id, input, status, child_session_id FROM parts
+		WHERE session_id = ? AND child_session_id != ''
+		ORDER BY message_id, position`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	calls := make(map[string]*SubagentCall)
+	for rows.Next() {
+		var call SubagentCall
+		var callID, input, status sql.NullString
+		var childID string
+		if err := rows.Scan(&call.PartID, &call.MessageID, &callID, &input, &status, &childID); err != nil {
+			return nil, err
+		}
+		task := parseTaskInput(input.String)
+		call.CallID, call.Status = callID.String, status.String
+		call.Description, call.Agent = task.Description, task.SubagentType
+		if calls[childID] == nil {
+			calls[childID] = &call
+		}
+	}
+	return calls, rows.Err()
+}
+
+// GetSessionTree returns a session with the subagent sessions run for it,
+// and theirs in turn, or nil if it is unknown.
+func (d *Database) GetSessionTree(id string) (*SessionTree, error) {
+	detail, err := d.GetSession(id)
+	if err != nil || detail == nil {
+		return nil, err
+	}
+	root := &SessionTree{Session: detail.Session}
+	seen := map[string]bool{id: true}
+	if err := d.growSessionTree(root, detail.Subsessions, seen); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// growSessionTree adds children to tree, then their own children. seen
+// guards against sessions that name each other as parents.
+func (d *Database) growSessionTree(tree *SessionTree, children []*Session, seen map[string]bool) error {
+	calls, err := d.subagentCalls(tree.ID)
+	if err != nil {
+		return err
+	}
+	tree.Children = []*SessionTree{}
+	for _, child := range children {
+		if seen[child.ID] {
+			continue
+		}
+		seen[child.ID] = true
+
+		node := &SessionTree{Session: child, SpawnedBy: calls[child.ID]}
+		grandchildren, err := d.ListSessions(SessionFilter{ParentID: child.ID})
+		if err != nil {
+			return err
+		}
+		if err := d.growSessionTree(node, grandchildren, seen); err != nil {
+			return err
+		}
+		tree.Children = append(tree.Children, node)
+	}
+	return nil
+}
+
+// GetSessionTree returns a session tree for /api/sessions/{id}/tree.
+func (s *Store) GetSessionTree(id string) (*SessionTree, error) {
+	if s.db == nil {
+		return nil, apperrors.NewNotFoundError("Session not found", nil)
+	}
+	tree, err := s.db.GetSessionTree(id)
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("failed to load session tree", err)
+	}
+	if tree == nil {
+		return nil, apperrors.NewNotFoundError("Session not found", nil)
+	}
+	return tree, nil
+}
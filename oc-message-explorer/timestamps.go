@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyTimeKeys are the fields history formats keep an entry's time in,
+// most specific first.
+var historyTimeKeys = []string{"timestamp", "time", "createdAt", "created_at", "created", "ts", "date"}
+
+// historyEntryTime reads the time an entry was recorded from whichever of
+// historyTimeKeys it has. Numbers are Unix seconds or milliseconds, strings
+// are RFC 3339 or a number, and an object such as OpenCode's
+// {"created": ...} is searched the same way.
+func historyEntryTime(raw map[string]interface{}) (time.Time, bool) {
+	for _, key := range historyTimeKeys {
+		value, ok := raw[key]
+		if !ok {
+			continue
+		}
+		if t, ok := parseHistoryTime(value); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// timestampBefore reports whether timestamp a is earlier than b,
+// comparing the instants they name so differing UTC offsets order
+// correctly. Values that do not parse are compared as text.
+func timestampBefore(a, b string) bool {
+	ta, errA := time.Parse(time.RFC3339Nano, a)
+	tb, errB := time.Parse(time.RFC3339Nano, b)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	return ta.Before(tb)
+}
+
+// timestampAfter reports whether timestamp a is later than b; see
+// timestampBefore.
+func timestampAfter(a, b string) bool {
+	return timestampBefore(b, a)
+}
+
+func parseHistoryTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case float64:
+		return unixTime(v)
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, true
+		}
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return unixTime(n)
+		}
+	case map[string]interface{}:
+		return historyEntryTime(v)
+	}
+	return time.Time{}, false
+}
+
+// unixTime takes seconds or milliseconds since the epoch; anything large
+// enough to be milliseconds is treated as such.
+func unixTime(n float64) (time.Time, bool) {
+	if n <= 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return time.Time{}, false
+	}
+	if n >= 1e11 {
+		return time.UnixMilli(int64(n)), true
+	}
+	return time.Unix(0, int64(n*float64(time.Second))), true
+}
+
+// promptTimesLookup finds the times a prompt was sent, oldest first.
+type promptTimesLookup func(prompts []string) (map[string][]string, error)
+
+// promptTrimSet is what is trimmed from both sides before prompts and
+// message contents are compared; the SQL below trims the same set.
+const promptTrimSet = " \t\r\n"
+
+// UserMessageTimes returns, for each prompt, the timestamps of the
+// OpenCode user messages whose text is that prompt, oldest first. Only
+// hydrated messages have text to match.
+func (d *Database) UserMessageTimes(prompts []string) (map[string][]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	times := make(map[string][]string)
+	for start := 0; start < len(prompts); start += syncBatchSize {
+		batch := prompts[start:min(start+syncBatchSize, len(prompts))]
+		args := make([]any, len(batch))
+		for i, prompt := range batch {
+			args[i] = strings.Trim(prompt, promptTrimSet)
+		}
+
+		rows, err := d.db.Query(fmt.Sprintf(`
+			SELECT trim(content, char(32, 9, 13, 10)), timestamp FROM nodes
+			WHERE type = 'user' AND trim(content, char(32, 9, 13, 10)) IN (%s)
+			ORDER BY julianday(timestamp)
+		`, placeholders(len(batch))), args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var prompt, timestamp string
+			if err := rows.Scan(&prompt, &timestamp); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			times[prompt] = append(times[prompt], timestamp)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return times, nil
+}
+
+// inferPromptTimes gives history entries without a time of their own the
+// time of the OpenCode message they match. pending holds each prompt's
+// entries in file order. History files keep the latest entries, so when a
+// prompt was sent more often than the file remembers, the entries are
+// matched to the latest messages. Entries are left estimated when a prompt
+// was sent fewer times than it appears in the file.
+func inferPromptTimes(pending map[string][]*MessageNode, lookup promptTimesLookup) {
+	if lookup == nil || len(pending) == 0 {
+		return
+	}
+
+	prompts := make([]string, 0, len(pending))
+	for prompt := range pending {
+		prompts = append(prompts, prompt)
+	}
+	times, err := lookup(prompts)
+	if err != nil {
+		log.Printf("Failed to look up prompt times: %v", err)
+		return
+	}
+
+	for prompt, nodes := range pending {
+		matches := times[strings.Trim(prompt, promptTrimSet)]
+		if len(matches) < len(nodes) {
+			continue
+		}
+		offset := len(matches) - len(nodes)
+		for i, node := range nodes {
+			node.Timestamp = matches[offset+i]
+			node.TimestampEstimated = false
+		}
+	}
+}
+
+// ResolveTimestamps replaces estimated timestamps with the given ones.
+func (d *Database) ResolveTimestamps(timestamps map[string]string) error {
+	if len(timestamps) == 0 {
+		return nil
+	}
+	return d.withTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare("UPDATE nodes SET timestamp = ?, timestamp_estimated = 0 WHERE id = ?")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for id, timestamp := range timestamps {
+			if _, err := stmt.Exec(timestamp, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CountEstimatedTimestamps counts a folder's nodes whose time is a guess.
+func (d *Database) CountEstimatedTimestamps(folderID string) (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM nodes WHERE folder_id = ? AND timestamp_estimated = 1", folderID).Scan(&count)
+	return count, err
+}
+
+// resolveHistoryTimes reads history files again once message content has
+// been hydrated, since entries can only be matched to messages whose text
+// is known.
+func (sm *SyncManager) resolveHistoryTimes() {
+	for _, source := range sm.historySources {
+		if source.Disabled {
+			continue
+		}
+		estimated, err := sm.db.CountEstimatedTimestamps(source.FolderID)
+		if err != nil {
+			log.Printf("Failed to count estimated timestamps in %s: %v", source.FolderID, err)
+			continue
+		}
+		if estimated == 0 {
+			continue
+		}
+		if _, err := os.Stat(source.Path); err != nil {
+			continue
+		}
+
+		changed, err := sm.importHistory(source, nil)
+		if err != nil {
+			log.Printf("Failed to resolve %s timestamps: %v", source.Name, err)
+			continue
+		}
+		sm.pushNodes(source.FolderID, changed)
+	}
+}
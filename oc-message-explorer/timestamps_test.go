@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryTimestamps(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sm := NewSyncManager(db, nil, t.TempDir(), nil)
+	source := HistorySource{Path: filepath.Join(t.TempDir(), "prompt-history.jsonl"), FolderID: "webui", Name: "Web UI History", Color: "#6b8afd"}
+	sm.historySources = []HistorySource{source}
+
+	history := `{"input":"run the tests","mode":"normal","timestamp":1760000000000}
+{"input":"deploy it","mode":"normal","time":"2025-10-09T08:00:00Z"}
+{"input":"fix the build","mode":"normal"}
+{"input":"fix the build","mode":"normal"}
+{"input":"write the docs","mode":"normal"}
+`
+	if err := os.WriteFile(source.Path, []byte(history), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.EnsureFolder(openChatFolder()); err != nil {
+		t.Fatal(err)
+	}
+	sendMessage := func(id, content, timestamp string) {
+		t.Helper()
+		node := &MessageNode{ID: id, Type: "user", Content: content, Timestamp: timestamp, SessionID: "ses_a", HasLoaded: true}
+		if err := db.InsertNode("openchat", node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Sent three times, but the history file only remembers the last two.
+	sendMessage("msg_1", "fix the build", "2025-10-01T09:00:00Z")
+	sendMessage("msg_2", "fix the build\n", "2025-10-02T09:00:00Z")
+	sendMessage("msg_3", "fix the build", "2025-10-03T09:00:00Z")
+
+	if err := sm.syncHistorySource(source); err != nil {
+		t.Fatal(err)
+	}
+
+	assertTime := func(content string, occurrence int, want string, estimated bool) {
+		t.Helper()
+		node, err := db.GetNode(historyNodeID("webui", content, occurrence))
+		if err != nil || node == nil {
+			t.Fatalf("%q not synced: %v", content, err)
+		}
+		if node.TimestampEstimated != estimated {
+			t.Errorf("%q: timestampEstimated = %v, want %v", content, node.TimestampEstimated, estimated)
+		}
+		if want == "" {
+			return
+		}
+		got, err := time.Parse(time.RFC3339, node.Timestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, _ := time.Parse(time.RFC3339, want); !got.Equal(expected) {
+			t.Errorf("%q: timestamp = %s, want %s", content, node.Timestamp, want)
+		}
+	}
+
+	assertTime("run the tests", 1, time.UnixMilli(1760000000000).Format(time.RFC3339), false)
+	assertTime("deploy it", 1, "2025-10-09T08:00:00Z", false)
+	assertTime("fix the build", 1, "2025-10-02T09:00:00Z", false)
+	assertTime("fix the build", 2, "2025-10-03T09:00:00Z", false)
+	assertTime("write the docs", 1, "", true)
+
+	// Once the matching message is hydrated, the estimate is replaced.
+	sendMessage("msg_4", "write the docs", "2025-10-04T09:00:00Z")
+	sm.resolveHistoryTimes()
+	assertTime("write the docs", 1, "2025-10-04T09:00:00Z", false)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	apperrors "oc-message-explorer/internal/errors"
+)
+
+const (
+	transcriptDefaultLimit = 100
+	transcriptMaxLimit     = 500
+)
+
+// TranscriptOptions control GET /api/sessions/{id}/transcript. Compacted
+// is "reveal" (the default) to include the messages sent before the
+// session was last compacted, marked as such, or "collapse" to leave them
+// out and show only what the model still saw. A Branch limits it to one
+// branch, "main" for the main line.
+type TranscriptOptions struct {
+	Offset         int
+	Limit          int
+	ToolOutput     bool
+	Compacted      string
+	Branch         string
+	IncludeDeleted bool
+}
+
+// parseTranscriptOptions reads the transcript options from a query string:
+// offset, limit, toolOutput, compacted, branch and includeDeleted.
+func parseTranscriptOptions(query url.Values) (TranscriptOptions, error) {
+	opts := TranscriptOptions{
+		Limit:          transcriptDefaultLimit,
+		ToolOutput:     query.Get("toolOutput") != "false",
+		Compacted:      query.Get("compacted"),
+		Branch:         query.Get("branch"),
+		IncludeDeleted: query.Get("includeDeleted") == "true",
+	}
+	for name, dest := range map[string]*int{"offset": &opts.Offset, "limit": &opts.Limit} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return opts, apperrors.NewValidationError(fmt.Sprintf("%s must be a whole number, got %q", name, value), nil)
+		}
+		*dest = n
+	}
+	if opts.Limit == 0 {
+		opts.Limit = transcriptDefaultLimit
+	}
+	if opts.Limit > transcriptMaxLimit {
+		opts.Limit = transcriptMaxLimit
+	}
+	switch opts.Compacted {
+	case "":
+		opts.Compacted = "reveal"
+	case "reveal", "collapse":
+	default:
+		return opts, apperrors.NewValidationError(fmt.Sprintf("compacted must be reveal or collapse, got %q", opts.Compacted), nil)
+	}
+	return opts, nil
+}
+
+// Transcript is one page of a session read top to bottom. Total counts
+// the messages of the whole transcript; Collapsed those left out because
+// the session was compacted after they were sent.
+type Transcript struct {
+	Session     *Session             `json:"session"`
+	Messages    []*TranscriptMessage `json:"messages"`
+	Total       int                  `json:"total"`
+	Offset      int                  `json:"offset"`
+	Limit       int                  `json:"limit"`
+	CompactedAt string               `json:"compactedAt,omitempty"`
+	Collapsed   int                  `json:"collapsed"`
+}
+
+// TranscriptMessage is a message with its content loaded and its parts,
+// and the branch of the session it is on.
+type TranscriptMessage struct {
+	*MessageNode
+	Parts     []*MessagePart `json:"parts"`
+	Compacted bool           `json:"compacted,omitempty"` // sent before the context last started over
+	Branch    string         `json:"branch"`
+	MainLine  bool           `json:"mainLine"`
+}
+
+// SessionMessages returns the messages of a session in transcript order,
+// and when its context last started over. Each message is followed by
+// its replies before the next message; messages without a parent in the
+// session, and replies to the same message, are taken oldest first.
+func (d *Database) SessionMessages(sessionID string, includeDeleted bool) ([]*MessageNode, string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var cutoff string
+	if err := d.db.QueryRow("SELECT COALESCE("+compactionCutoff("?")+", '')", sessionID).Scan(&cutoff); err != nil {
+		return nil, "", err
+	}
+
+	where := "n.session_id = ?"
+	if !includeDeleted {
+		where += " AND n.deleted_upstream_at IS NULL"
+	}
+	rows, err := d.db.Query(fmt.Sprintf("SELECT %s FROM nodes n WHERE %s ORDER BY julianday(n.timestamp), n.id", nodeSelectColumns, where), sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var nodes []*MessageNode
+	for rows.Next() {
+		node, err := scanNode(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	return threadOrder(nodes), cutoff, nil
+}
+
+// threadReplies splits nodes sorted by time into those without a parent
+// among them and the replies to each node, keeping their order.
+func threadReplies(nodes []*MessageNode) ([]*MessageNode, map[string][]*MessageNode) {
+	byID := make(map[string]*MessageNode, len(nodes))
+	for _, node := range nodes {
+		byID[node.ID] = node
+	}
+	replies := make(map[string][]*MessageNode)
+	var roots []*MessageNode
+	for _, node := range nodes {
+		if node.ParentID != "" && node.ParentID != node.ID && byID[node.ParentID] != nil {
+			replies[node.ParentID] = append(replies[node.ParentID], node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots, replies
+}
+
+// threadOrder orders nodes sorted by time so each is followed by its
+// replies, depth first.
+func threadOrder(nodes []*MessageNode) []*MessageNode {
+	roots, replies := threadReplies(nodes)
+
+	ordered := make([]*MessageNode, 0, len(nodes))
+	seen := make(map[string]bool, len(nodes))
+	var visit func(node *MessageNode)
+	visit = func(node *MessageNode) {
+		if seen[node.ID] {
+			return
+		}
+		seen[node.ID] = true
+		ordered = append(ordered, node)
+		for _, reply := range replies[node.ID] {
+			visit(reply)
+		}
+	}
+	for _, root := range roots {
+		visit(root)
+	}
+	// Messages whose parents form a loop are reachable from no root.
+	for _, node := range nodes {
+		visit(node)
+	}
+	return ordered
+}
+
+// Transcript returns a page of a session's transcript for
+// /api/sessions/{id}/transcript, loading the content of messages that
+// have not been hydrated yet.
+func (s *Store) Transcript(sessionID string, opts TranscriptOptions) (*Transcript, error) {
+	if s.db == nil {
+		return nil, apperrors.NewNotFoundError("Session not found", nil)
+	}
+	detail, err := s.db.GetSession(sessionID)
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("failed to load session", err)
+	}
+	nodes, cutoff, err := s.db.SessionMessages(sessionID, opts.IncludeDeleted)
+	if err != nil {
+		return nil, apperrors.NewDatabaseError("failed to load transcript", err)
+	}
+	if detail == nil && len(nodes) == 0 {
+		return nil, apperrors.NewNotFoundError("Session not found", nil)
+	}
+
+	transcript := &Transcript{Offset: opts.Offset, Limit: opts.Limit, CompactedAt: cutoff, Messages: []*TranscriptMessage{}}
+	if detail != nil {
+		transcript.Session = detail.Session
+	} else {
+		// Messages can name a session OpenCode has no file for.
+		transcript.Session = &Session{ID: sessionID, MessageCount: len(nodes)}
+	}
+
+	plan := planBranches(nodes)
+	var messages []*TranscriptMessage
+	for _, node := range nodes {
+		branch := plan.branchOf[node.ID]
+		if opts.Branch != "" && branch != opts.Branch {
+			continue
+		}
+		compacted := cutoff != "" && timestampBefore(node.Timestamp, cutoff)
+		if compacted && opts.Compacted == "collapse" {
+			transcript.Collapsed++
+			continue
+		}
+		messages = append(messages, &TranscriptMessage{MessageNode: node, Compacted: compacted, Branch: branch, MainLine: branch == mainBranch})
+	}
+	transcript.Total = len(messages)
+	if opts.Offset >= len(messages) {
+		return transcript, nil
+	}
+	messages = messages[opts.Offset:min(opts.Offset+opts.Limit, len(messages))]
+
+	for _, message := range messages {
+		if !message.HasLoaded {
+			if loaded := s.loadMessageContent(message.ID); loaded != nil {
+				message.Content, message.HasLoaded = loaded.Content, loaded.HasLoaded
+			}
+		}
+		parts, err := s.getMessageParts(message.ID)
+		if err != nil {
+			return nil, apperrors.NewDatabaseError("failed to load message parts", err)
+		}
+		if !opts.ToolOutput {
+			for _, part := range parts {
+				if part.Type == "tool" {
+					part.Output = ""
+				}
+			}
+		}
+		message.Parts = parts
+	}
+	transcript.Messages = messages
+	return transcript, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSessionTranscript(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	sm := NewSyncManager(store.db, store, t.TempDir(), nil)
+	sm.historySources = nil
+
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	message := func(id, content string) {
+		write(filepath.Join(sm.msgPath, "ses_t", id+".json"), content)
+	}
+	part := func(messageID, id, content string) {
+		write(filepath.Join(sm.partPath, messageID, id+".json"), content)
+	}
+	message("msg_u1", `{"id":"msg_u1","sessionID":"ses_t","role":"user","time":{"created":1759309200000}}`)
+	part("msg_u1", "prt_1", `{"id":"prt_1","type":"text","text":"Why is the build red?"}`)
+	message("msg_a1", `{"id":"msg_a1","sessionID":"ses_t","role":"assistant","parentID":"msg_u1","time":{"created":1759309201000}}`)
+	part("msg_a1", "prt_2", `{"id":"prt_2","type":"tool","tool":"bash","callID":"call_1","state":{"status":"completed","input":{"command":"go build ./..."},"output":"parser.go:12: undefined: lex"}}`)
+	part("msg_a1", "prt_3", `{"id":"prt_3","type":"text","text":"lex was renamed."}`)
+	message("msg_u2", `{"id":"msg_u2","sessionID":"ses_t","role":"user","time":{"created":1759309300000}}`)
+	part("msg_u2", "prt_4", `{"id":"prt_4","type":"compaction"}`)
+	message("msg_a2", `{"id":"msg_a2","sessionID":"ses_t","role":"assistant","parentID":"msg_u2","time":{"created":1759309301000},"summary":true}`)
+	part("msg_a2", "prt_5", `{"id":"prt_5","type":"text","text":"We found lex was renamed."}`)
+	message("msg_u3", `{"id":"msg_u3","sessionID":"ses_t","role":"user","time":{"created":1759309400000}}`)
+	// A retry of the first answer, written after everything else.
+	message("msg_a1b", `{"id":"msg_a1b","sessionID":"ses_t","role":"assistant","parentID":"msg_u1","time":{"created":1759309500000}}`)
+	syncAndHydrate(sm)
+
+	opts, err := parseTranscriptOptions(url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript, err := store.Transcript("ses_t", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, message := range transcript.Messages {
+		order = append(order, message.ID)
+	}
+	want := []string{"msg_u1", "msg_a1", "msg_a1b", "msg_u2", "msg_a2", "msg_u3"}
+	if len(order) != len(want) || transcript.Total != len(want) {
+		t.Fatalf("got %v (total %d), want %v", order, transcript.Total, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got %v, want %v", order, want)
+		}
+	}
+	if transcript.Session.ID != "ses_t" || !sameTime(transcript.CompactedAt, "2025-10-01T09:01:40Z") {
+		t.Errorf("unexpected transcript header %+v", transcript)
+	}
+	answer := transcript.Messages[1]
+	if answer.Content != "lex was renamed." || len(answer.Parts) != 2 || answer.Parts[0].Output == "" || !answer.Compacted {
+		t.Errorf("expected the compacted answer with its parts, got %+v", answer)
+	}
+	if transcript.Messages[3].Compacted || transcript.Messages[3].Boundary != "compaction" {
+		t.Errorf("expected the compaction request to start the live context, got %+v", transcript.Messages[3])
+	}
+
+	opts, err = parseTranscriptOptions(url.Values{"offset": {"1"}, "limit": {"2"}, "toolOutput": {"false"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := store.Transcript("ses_t", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 2 || page.Messages[0].ID != "msg_a1" || page.Total != 6 {
+		t.Fatalf("unexpected page %+v", page.Messages)
+	}
+	if tool := page.Messages[0].Parts[0]; tool.Output != "" || tool.Input == "" || tool.Status != "completed" {
+		t.Errorf("expected the tool call without its output, got %+v", tool)
+	}
+
+	opts, err = parseTranscriptOptions(url.Values{"compacted": {"collapse"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	collapsed, err := store.Transcript("ses_t", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The retry came after the compaction, so the model saw it.
+	if collapsed.Collapsed != 2 || collapsed.Total != 4 || collapsed.Messages[0].ID != "msg_a1b" || collapsed.Messages[1].ID != "msg_u2" {
+		t.Errorf("expected only what the model saw after compacting, got %+v", collapsed)
+	}
+
+	if _, err := store.Transcript("ses_missing", opts); err == nil {
+		t.Error("expected an unknown session to be an error")
+	}
+	for _, query := range []url.Values{{"limit": {"-1"}}, {"offset": {"x"}}, {"compacted": {"hide"}}} {
+		if _, err := parseTranscriptOptions(query); err == nil {
+			t.Errorf("expected %v to be rejected", query)
+		}
+	}
+}
+
+func TestTranscriptComparesInstants(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+
+	// Times written with different UTC offsets, whose text sorts in
+	// another order than the instants they name.
+	for _, node := range []*MessageNode{
+		{ID: "u1", Type: "user", Timestamp: "2025-10-01T09:00:00+02:00"},                               // 07:00Z
+		{ID: "c1", Type: "user", Timestamp: "2025-10-01T09:50:00+02:00", Boundary: boundaryCompaction}, // 07:50Z
+		{ID: "s1", Type: "assistant", ParentID: "c1", Timestamp: "2025-10-01T07:51:00Z", Boundary: boundarySummary},
+		{ID: "c2", Type: "user", Timestamp: "2025-10-01T08:30:00Z", Boundary: boundaryCompaction},
+		{ID: "s2", Type: "assistant", ParentID: "c2", Timestamp: "2025-10-01T08:31:00Z", Boundary: boundarySummary},
+		{ID: "u3", Type: "user", Timestamp: "2025-10-01T10:45:00+02:00"}, // 08:45Z
+	} {
+		node.SessionID = "ses_o"
+		node.HasLoaded = true
+		if err := store.db.InsertNode("openchat", node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts, err := parseTranscriptOptions(url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript, err := store.Transcript("ses_o", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameTime(transcript.CompactedAt, "2025-10-01T08:30:00Z") {
+		t.Errorf("got cutoff %s, want the latest compaction at 08:30Z", transcript.CompactedAt)
+	}
+	want := []struct {
+		id        string
+		compacted bool
+	}{{"u1", true}, {"c1", true}, {"s1", true}, {"c2", false}, {"s2", false}, {"u3", false}}
+	if len(transcript.Messages) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(transcript.Messages), len(want))
+	}
+	for i, w := range want {
+		if got := transcript.Messages[i]; got.ID != w.id || got.Compacted != w.compacted {
+			t.Errorf("message %d: got %s (compacted %v), want %s (compacted %v)", i, got.ID, got.Compacted, w.id, w.compacted)
+		}
+	}
+
+	// Search filters agree with the transcript.
+	for query, want := range map[string]int{"compacted:true session:ses_o": 3, "compacted:false session:ses_o": 3} {
+		response, err := store.db.SearchNodes(SearchRequest{Query: query})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if response.Total != want {
+			t.Errorf("%s: got %d results, want %d", query, response.Total, want)
+		}
+	}
+}
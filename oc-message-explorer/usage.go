@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	apperrors "oc-message-explorer/internal/errors"
+)
+
+// usageGroups maps each /api/usage groupBy value to the expression it
+// groups responses by, over nodes n joined to their sessions s.
+var usageGroups = map[string]string{
+	"model":    "CASE WHEN n.provider_id = '' THEN n.model_id ELSE n.provider_id || '/' || n.model_id END",
+	"provider": "n.provider_id",
+	"agent":    "n.agent",
+	"session":  "COALESCE(n.session_id, '')",
+	"project":  "COALESCE(s.project_id, '')",
+	"day":      "substr(n.timestamp, 1, 10)",
+}
+
+// UsageGroup totals what the responses sharing a model, provider, agent,
+// session, project or day used. AvgLatencyMs is the mean time from a
+// response starting to completing, over the responses that completed;
+// Errors counts the responses that failed or were aborted.
+type UsageGroup struct {
+	Key          string     `json:"key"`
+	Messages     int        `json:"messages"`
+	Tokens       TokenUsage `json:"tokens"`
+	Cost         float64    `json:"cost"`
+	AvgLatencyMs int64      `json:"avgLatencyMs,omitempty"`
+	Errors       int        `json:"errors"`
+
+	completed int
+}
+
+// UsageReport is returned by GET /api/usage.
+type UsageReport struct {
+	GroupBy string        `json:"groupBy"`
+	Query   string        `json:"query,omitempty"`
+	Groups  []*UsageGroup `json:"groups"`
+	Total   *UsageGroup   `json:"total"`
+}
+
+// Usage totals the usage of responses, most expensive group first. A
+// non-empty query limits it to the responses the search syntax matches.
+func (d *Database) Usage(groupBy, query string) (*UsageReport, error) {
+	key, ok := usageGroups[groupBy]
+	if !ok {
+		return nil, apperrors.NewValidationError(fmt.Sprintf("cannot group usage by %q (use model, provider, agent, session, project or day)", groupBy), nil)
+	}
+
+	where, args := "1 = 1", []any{}
+	if query != "" {
+		parsed, err := ParseSearchQuery(query)
+		if err != nil {
+			return nil, err
+		}
+		if where, args, _, err = parsed.compile(false); err != nil {
+			return nil, err
+		}
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	rows, err := d.db.Query(fmt.Sprintf(`
+		SELECT %s AS usage_key, COUNT(*),
+		       SUM(n.tokens_input), SUM(n.tokens_output), SUM(n.tokens_reasoning),
+		       SUM(n.tokens_cache_read), SUM(n.tokens_cache_write), SUM(n.cost),
+		       COUNT(NULLIF(n.error_kind, '')),
+		       COUNT(NULLIF(n.latency_ms, 0)), COALESCE(AVG(NULLIF(n.latency_ms, 0)), 0)
+		FROM nodes n
+		LEFT JOIN sessions s ON s.id = n.session_id
+		WHERE n.type = 'response' AND (%s)
+		GROUP BY usage_key
+		ORDER BY SUM(n.cost) DESC, COUNT(*) DESC, usage_key`, key, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &UsageReport{GroupBy: groupBy, Query: query, Groups: []*UsageGroup{}, Total: &UsageGroup{}}
+	var latencyTotal float64
+	for rows.Next() {
+		group := &UsageGroup{}
+		var avgLatency float64
+		if err := rows.Scan(&group.Key, &group.Messages,
+			&group.Tokens.Input, &group.Tokens.Output, &group.Tokens.Reasoning,
+			&group.Tokens.CacheRead, &group.Tokens.CacheWrite, &group.Cost,
+			&group.Errors, &group.completed, &avgLatency); err != nil {
+			return nil, err
+		}
+		group.AvgLatencyMs = int64(math.Round(avgLatency))
+		report.Groups = append(report.Groups, group)
+
+		total := report.Total
+		total.Messages += group.Messages
+		total.Tokens.Input += group.Tokens.Input
+		total.Tokens.Output += group.Tokens.Output
+		total.Tokens.Reasoning += group.Tokens.Reasoning
+		total.Tokens.CacheRead += group.Tokens.CacheRead
+		total.Tokens.CacheWrite += group.Tokens.CacheWrite
+		total.Cost += group.Cost
+		total.Errors += group.Errors
+		total.completed += group.completed
+		latencyTotal += avgLatency * float64(group.completed)
+	}
+	if report.Total.completed > 0 {
+		report.Total.AvgLatencyMs = int64(math.Round(latencyTotal / float64(report.Total.completed)))
+	}
+	return report, rows.Err()
+}
+
+// Usage reports usage for /api/usage.
+func (s *Store) Usage(groupBy, query string) (*UsageReport, error) {
+	if s.db == nil {
+		return &UsageReport{GroupBy: groupBy, Query: query, Groups: []*UsageGroup{}, Total: &UsageGroup{}}, nil
+	}
+	report, err := s.db.Usage(groupBy, query)
+	if err != nil {
+		// Mistakes in the query are already validation errors.
+		var appErr *apperrors.AppError
+		if errors.As(err, &appErr) {
+			return nil, err
+		}
+		return nil, apperrors.NewDatabaseError("failed to total usage", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncMessageUsage(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	sm := NewSyncManager(store.db, store, t.TempDir(), nil)
+	sm.historySources = nil
+
+	dir := filepath.Join(sm.msgPath, "ses_a")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(id, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, id+".json"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("msg_1", `{"id":"msg_1","sessionID":"ses_a","role":"user","time":{"created":1759309200000},"agent":"build","model":{"providerID":"anthropic","modelID":"claude-sonnet-4-20250514"}}`)
+	write("msg_2", `{"id":"msg_2","sessionID":"ses_a","role":"assistant","time":{"created":1759309201000,"completed":1759309205000},"mode":"build","modelID":"claude-sonnet-4-20250514","providerID":"anthropic","cost":0.25,"tokens":{"input":1200,"output":300,"reasoning":50,"cache":{"read":4000,"write":100}}}`)
+	write("msg_3", `{"id":"msg_3","sessionID":"ses_a","role":"assistant","time":{"created":1759309210000},"agent":"plan","modelID":"gpt-5","providerID":"openai"}`)
+	syncAndHydrate(sm)
+
+	prompt, err := store.db.GetNode("msg_1")
+	if err != nil || prompt == nil {
+		t.Fatalf("expected msg_1: %v", err)
+	}
+	if prompt.ModelID != "claude-sonnet-4-20250514" || prompt.ProviderID != "anthropic" || prompt.Agent != "build" || prompt.Tokens != nil {
+		t.Errorf("expected the prompt to name its model, got %+v", prompt.MessageUsage)
+	}
+
+	response, err := store.db.GetNode("msg_2")
+	if err != nil || response == nil {
+		t.Fatalf("expected msg_2: %v", err)
+	}
+	want := TokenUsage{Input: 1200, Output: 300, Reasoning: 50, CacheRead: 4000, CacheWrite: 100}
+	if response.Tokens == nil || *response.Tokens != want || response.Cost != 0.25 || response.Agent != "build" {
+		t.Errorf("unexpected usage %+v", response.MessageUsage)
+	}
+	if !sameTime(response.CompletedAt, "2025-10-01T09:00:05Z") {
+		t.Errorf("unexpected completion time %q", response.CompletedAt)
+	}
+
+	// OpenCode rewrites a response's file once it completes.
+	write("msg_3", `{"id":"msg_3","sessionID":"ses_a","role":"assistant","time":{"created":1759309210000,"completed":1759309212000},"agent":"plan","modelID":"gpt-5","providerID":"openai","cost":0.05,"tokens":{"input":800,"output":100,"reasoning":0,"cache":{"read":0,"write":0}}}`)
+	syncAndHydrate(sm)
+
+	finished, err := store.db.GetNode("msg_3")
+	if err != nil || finished == nil {
+		t.Fatalf("expected msg_3: %v", err)
+	}
+	if finished.Tokens == nil || finished.Tokens.Input != 800 || finished.Cost != 0.05 || finished.CompletedAt == "" {
+		t.Errorf("expected the completed response's usage, got %+v", finished.MessageUsage)
+	}
+
+	report, err := store.Usage("model", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Groups) != 2 || report.Groups[0].Key != "anthropic/claude-sonnet-4-20250514" || report.Groups[1].Key != "openai/gpt-5" {
+		t.Fatalf("expected the prompt to be left out and the costlier model first, got %+v", report.Groups)
+	}
+	if claude := report.Groups[0]; claude.Messages != 1 || claude.Tokens != want || claude.AvgLatencyMs != 4000 {
+		t.Errorf("unexpected model usage %+v", claude)
+	}
+	if report.Total.Messages != 2 || report.Total.Cost != 0.3 || report.Total.Tokens.Input != 2000 || report.Total.AvgLatencyMs != 3000 {
+		t.Errorf("unexpected total %+v", report.Total)
+	}
+
+	report, err = store.Usage("agent", "provider:openai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Groups) != 1 || report.Groups[0].Key != "plan" {
+		t.Errorf("expected only the plan agent's response, got %+v", report.Groups)
+	}
+	if _, err := store.Usage("colour", ""); err == nil {
+		t.Error("expected an unknown grouping to be rejected")
+	}
+}
+
+func TestSyncMessageErrors(t *testing.T) {
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	sm := NewSyncManager(store.db, store, t.TempDir(), nil)
+	sm.historySources = nil
+
+	write := func(sessionID, id, content string) {
+		t.Helper()
+		dir := filepath.Join(sm.msgPath, sessionID)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, id+".json"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("ses_ok", "msg_ok", `{"id":"msg_ok","sessionID":"ses_ok","role":"assistant","time":{"created":1759309200000,"completed":1759309201250},"modelID":"gpt-5","providerID":"openai"}`)
+	write("ses_bad", "msg_overloaded", `{"id":"msg_overloaded","sessionID":"ses_bad","role":"assistant","time":{"created":1759309300000,"completed":1759309302000},"modelID":"claude-sonnet-4","providerID":"anthropic","error":{"name":"APIError","data":{"message":"Overloaded","statusCode":529,"isRetryable":true}}}`)
+	write("ses_bad", "msg_aborted", `{"id":"msg_aborted","sessionID":"ses_bad","role":"assistant","time":{"created":1759309400000,"completed":1759309490000},"modelID":"claude-sonnet-4","providerID":"anthropic","error":{"name":"MessageAbortedError","data":{"message":"The operation was aborted."}}}`)
+	write("ses_bad", "msg_stalled", `{"id":"msg_stalled","sessionID":"ses_bad","role":"assistant","time":{"created":1759309500000},"modelID":"claude-sonnet-4","providerID":"anthropic"}`)
+	if err := store.db.WriteSessions([]*Session{
+		{ID: "ses_ok", Title: "Fine", Source: "opencode", UpdatedAt: "2025-10-02T00:00:00Z"},
+		{ID: "ses_bad", Title: "Troubled", Source: "opencode", UpdatedAt: "2025-10-01T00:00:00Z"},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	syncAndHydrate(sm)
+
+	ok, err := store.db.GetNode("msg_ok")
+	if err != nil || ok == nil {
+		t.Fatalf("expected msg_ok: %v", err)
+	}
+	if ok.LatencyMs != 1250 || ok.Error != nil {
+		t.Errorf("expected an exact latency and no error, got %+v", ok.MessageUsage)
+	}
+
+	overloaded, err := store.db.GetNode("msg_overloaded")
+	if err != nil || overloaded == nil {
+		t.Fatalf("expected msg_overloaded: %v", err)
+	}
+	if overloaded.Error == nil || *overloaded.Error != (MessageError{Kind: "api", Name: "APIError", Message: "Overloaded"}) {
+		t.Errorf("unexpected error %+v", overloaded.Error)
+	}
+	if len(overloaded.Tags) != 3 || overloaded.Tags[2] != "error" {
+		t.Errorf("expected the response to be tagged as an error, got %v", overloaded.Tags)
+	}
+	aborted, err := store.db.GetNode("msg_aborted")
+	if err != nil || aborted == nil || aborted.Error == nil || aborted.Error.Kind != "aborted" || aborted.Tags[2] != "aborted" {
+		t.Errorf("expected an aborted response, got %+v (%v)", aborted, err)
+	}
+
+	for query, want := range map[string]int{
+		"error:true":             2,
+		"error:false":            2,
+		"error:aborted":          1,
+		"error:APIError":         1,
+		"tag:error":              1,
+		"completed:false":        1,
+		"latency:>1m":            1,
+		"latency:<=2s":           2,
+		"latency:1.5":            2,
+		"error:false latency:>0": 1,
+	} {
+		response, err := store.db.SearchNodes(SearchRequest{Query: query})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if response.Total != want {
+			t.Errorf("%s: got %d results, want %d", query, response.Total, want)
+		}
+	}
+
+	sessions, err := store.ListSessions(SessionFilter{Sort: "errors"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != "ses_bad" {
+		t.Fatalf("expected the failing session first, got %+v", sessions)
+	}
+	if bad := sessions[0]; bad.ErrorCount != 1 || bad.AbortedCount != 1 || bad.MaxLatencyMs != 90000 {
+		t.Errorf("unexpected session stats %+v", bad)
+	}
+	if sessions, err := store.ListSessions(SessionFilter{}); err != nil || sessions[0].ID != "ses_ok" {
+		t.Errorf("expected the most recently updated session first by default, got %+v (%v)", sessions, err)
+	}
+	if _, err := store.ListSessions(SessionFilter{Sort: "colour"}); err == nil {
+		t.Error("expected an unknown sort to be rejected")
+	}
+
+	report, err := store.Usage("session", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total.Errors != 2 || report.Total.AvgLatencyMs != 31083 {
+		t.Errorf("unexpected usage total %+v", report.Total)
+	}
+}
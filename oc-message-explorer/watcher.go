@@ -0,0 +1,560 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// watchDebounce is how long the watcher waits for a burst of writes to
+	// settle; watchMaxDelay caps the wait while writes keep coming.
+	watchDebounce = 300 * time.Millisecond
+	watchMaxDelay = 1500 * time.Millisecond
+
+	// Part directories are watched individually. Only messages touched
+	// recently are likely to change again, so older ones are skipped to
+	// stay well inside the inotify watch limit.
+	watchRecentParts = 24 * time.Hour
+)
+
+// NodesUpdate is pushed over the WebSocket when the watcher ingests
+// changes, carrying only the affected nodes of one folder.
+type NodesUpdate struct {
+	FolderID string         `json:"folderId"`
+	Nodes    []*MessageNode `json:"nodes"`
+}
+
+// Watcher feeds OpenCode storage changes into the database as they happen.
+type Watcher struct {
+	sm      *SyncManager
+	fsw     *fsnotify.Watcher
+	done    chan struct{}
+	stopped chan struct{} // closed once run has returned
+
+	messageFiles map[string]bool // storage/message/<session>/<msg>.json
+	removedFiles map[string]bool // message files deleted or renamed away
+	partMessages map[string]bool // message IDs whose parts changed
+	sessionFiles map[string]bool // storage/session/<project>/<session>.json
+	projectFiles map[string]bool // storage/project/<project>.json
+	histories    map[int]bool    // indexes into sm.historySources
+	sessionLogs  map[string]int  // session log path to its source's index
+
+	sessions map[int]SessionImporter // sources holding session logs, by index
+}
+
+// Watch starts watching the OpenCode storage directories and history
+// files. It is a no-op if a watcher is already running.
+func (sm *SyncManager) Watch() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.watcher != nil {
+		return nil
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	w := &Watcher{
+		sm:           sm,
+		fsw:          fsw,
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		messageFiles: make(map[string]bool),
+		removedFiles: make(map[string]bool),
+		partMessages: make(map[string]bool),
+		sessionFiles: make(map[string]bool),
+		projectFiles: make(map[string]bool),
+		histories:    make(map[int]bool),
+		sessionLogs:  make(map[string]int),
+		sessions:     make(map[int]SessionImporter),
+	}
+
+	w.add(sm.msgPath)
+	w.addSubdirs(sm.msgPath, 0)
+	w.add(sm.partPath)
+	w.addSubdirs(sm.partPath, watchRecentParts)
+	w.add(sm.sessionPath)
+	w.addSubdirs(sm.sessionPath, 0)
+	w.add(sm.projectPath)
+
+	watchedDirs := make(map[string]bool)
+	for i, source := range sm.historySources {
+		if source.Disabled {
+			continue
+		}
+		if importer, err := sourceImporter(source); err == nil {
+			if session, ok := importer.(SessionImporter); ok {
+				w.sessions[i] = session
+				if isDir(source.Path) {
+					// Agents file logs into nested directories, such as
+					// one per day.
+					w.addTree(source.Path, watchedDirs)
+					continue
+				}
+			}
+		}
+		// Watch the directory: history files are often replaced rather
+		// than appended to, which drops a watch on the file itself.
+		dir := filepath.Dir(source.Path)
+		if !watchedDirs[dir] {
+			watchedDirs[dir] = true
+			w.add(dir)
+		}
+	}
+
+	sm.watcher = w
+	go w.run()
+
+	log.Printf("Watching %s, %s and %d history directories for changes", sm.msgPath, sm.partPath, len(watchedDirs))
+	return nil
+}
+
+// StopWatching stops the watcher started by Watch and waits for any
+// ingest it is in the middle of, so nothing is written after it returns.
+func (sm *SyncManager) StopWatching() {
+	sm.mu.Lock()
+	w := sm.watcher
+	sm.watcher = nil
+	sm.mu.Unlock()
+
+	if w != nil {
+		close(w.done)
+		w.fsw.Close()
+		// Not under sm.mu: an ingest can take it.
+		<-w.stopped
+	}
+}
+
+func (w *Watcher) add(path string) {
+	if err := w.fsw.Add(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("[WATCH] Failed to watch %s: %v", path, err)
+	}
+}
+
+// addSubdirs watches every directory under root, or only those modified
+// within maxAge when it is non-zero.
+func (w *Watcher) addSubdirs(root string, maxAge time.Duration) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if maxAge > 0 {
+			info, err := entry.Info()
+			if err != nil || info.ModTime().Before(cutoff) {
+				continue
+			}
+		}
+		w.add(filepath.Join(root, entry.Name()))
+	}
+}
+
+// addTree watches root and every directory under it.
+func (w *Watcher) addTree(root string, watched map[string]bool) {
+	filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.IsDir() && !watched[path] {
+			watched[path] = true
+			w.add(path)
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) run() {
+	defer close(w.stopped)
+
+	var flush <-chan time.Time
+	var first time.Time
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			if !w.track(event) {
+				continue
+			}
+
+			now := time.Now()
+			if flush == nil {
+				first = now
+			}
+			delay := watchDebounce
+			if remaining := first.Add(watchMaxDelay).Sub(now); remaining < delay {
+				delay = remaining
+			}
+			flush = time.After(delay)
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("[WATCH] Watcher error: %v", err)
+		case <-flush:
+			flush = nil
+			w.flush()
+		}
+	}
+}
+
+// track records what an event means for the next flush and reports
+// whether anything needs ingesting.
+func (w *Watcher) track(event fsnotify.Event) bool {
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		rel, ok := relativeTo(w.sm.msgPath, event.Name)
+		if ok && len(rel) == 2 && strings.HasSuffix(event.Name, ".json") {
+			w.removedFiles[event.Name] = true
+			return true
+		}
+		return false
+	}
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return false
+	}
+
+	for i, source := range w.sm.historySources {
+		if source.Disabled {
+			continue
+		}
+		if session, ok := w.sessions[i]; ok {
+			if w.trackSessionLog(i, source, session, event) {
+				return true
+			}
+			continue
+		}
+		if event.Name == source.Path {
+			w.histories[i] = true
+			return true
+		}
+	}
+
+	if rel, ok := relativeTo(w.sm.msgPath, event.Name); ok {
+		switch len(rel) {
+		case 1: // a new session directory
+			if event.Has(fsnotify.Create) && isDir(event.Name) {
+				w.add(event.Name)
+				w.queueDir(event.Name, func(path string) { w.messageFiles[path] = true })
+				return true
+			}
+		case 2:
+			if strings.HasSuffix(event.Name, ".json") {
+				w.messageFiles[event.Name] = true
+				return true
+			}
+		}
+		return false
+	}
+
+	if rel, ok := relativeTo(w.sm.sessionPath, event.Name); ok {
+		switch len(rel) {
+		case 1: // a new project directory
+			if event.Has(fsnotify.Create) && isDir(event.Name) {
+				w.add(event.Name)
+				w.queueDir(event.Name, func(path string) { w.sessionFiles[path] = true })
+				return true
+			}
+		case 2:
+			if strings.HasSuffix(event.Name, ".json") {
+				w.sessionFiles[event.Name] = true
+				return true
+			}
+		}
+		return false
+	}
+
+	if rel, ok := relativeTo(w.sm.projectPath, event.Name); ok {
+		if len(rel) == 1 && strings.HasSuffix(event.Name, ".json") {
+			w.projectFiles[event.Name] = true
+			return true
+		}
+		return false
+	}
+
+	if rel, ok := relativeTo(w.sm.partPath, event.Name); ok {
+		switch len(rel) {
+		case 1: // a new message's part directory
+			if event.Has(fsnotify.Create) && isDir(event.Name) {
+				w.add(event.Name)
+				w.partMessages[rel[0]] = true
+				return true
+			}
+		case 2:
+			if strings.HasSuffix(event.Name, ".json") {
+				w.partMessages[rel[0]] = true
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// trackSessionLog queues a changed log of a session source, watching any
+// directory created under it along with the logs already written there.
+func (w *Watcher) trackSessionLog(i int, source HistorySource, session SessionImporter, event fsnotify.Event) bool {
+	if event.Name == source.Path {
+		w.sessionLogs[event.Name] = i
+		return true
+	}
+	if _, ok := relativeTo(source.Path, event.Name); !ok {
+		return false
+	}
+
+	if event.Has(fsnotify.Create) && isDir(event.Name) {
+		w.addTree(event.Name, make(map[string]bool))
+		files, _ := sessionLogFiles(event.Name, session)
+		for _, path := range files {
+			w.sessionLogs[path] = i
+		}
+		return len(files) > 0
+	}
+	if session.Match(event.Name) {
+		w.sessionLogs[event.Name] = i
+		return true
+	}
+	return false
+}
+
+// queueDir catches files written into a directory before its watch was
+// added.
+func (w *Watcher) queueDir(dir string, queue func(path string)) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			queue(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
+
+func relativeTo(root, path string) ([]string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return nil, false
+	}
+	return strings.Split(rel, string(filepath.Separator)), true
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// flush ingests everything tracked since the last flush and pushes the
+// affected nodes to connected clients.
+func (w *Watcher) flush() {
+	messageFiles, removedFiles, partMessages, histories := w.messageFiles, w.removedFiles, w.partMessages, w.histories
+	sessionFiles, projectFiles, sessionLogs := w.sessionFiles, w.projectFiles, w.sessionLogs
+	w.messageFiles = make(map[string]bool)
+	w.removedFiles = make(map[string]bool)
+	w.partMessages = make(map[string]bool)
+	w.sessionFiles = make(map[string]bool)
+	w.projectFiles = make(map[string]bool)
+	w.histories = make(map[int]bool)
+	w.sessionLogs = make(map[string]int)
+
+	if len(projectFiles) > 0 {
+		w.syncProjectFiles(projectFiles)
+	}
+	if len(sessionFiles) > 0 {
+		w.syncSessionFiles(sessionFiles)
+	}
+
+	affected := make(map[string]bool)
+	for path := range messageFiles {
+		id, err := w.sm.ingestMessageFile(path)
+		if err != nil {
+			log.Printf("[WATCH] Failed to ingest %s: %v", path, err)
+			continue
+		}
+		affected[id] = true
+		// Parts are usually written right after the message file.
+		partMessages[id] = true
+	}
+
+	var removedIDs, removedPaths []string
+	for path := range removedFiles {
+		// Editors and atomic writers remove and recreate files.
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		removedIDs = append(removedIDs, strings.TrimSuffix(filepath.Base(path), ".json"))
+		removedPaths = append(removedPaths, path)
+	}
+	if len(removedIDs) > 0 {
+		if _, err := w.sm.db.MarkDeletedUpstream(removedIDs, removedPaths); err != nil {
+			log.Printf("[WATCH] Failed to mark %d messages deleted upstream: %v", len(removedIDs), err)
+		} else {
+			for _, id := range removedIDs {
+				affected[id] = true
+			}
+		}
+	}
+
+	for id := range partMessages {
+		node, err := w.sm.db.GetNode(id)
+		if err != nil || node == nil {
+			// Parts can land before their message; the message event
+			// will hydrate it.
+			continue
+		}
+		if _, _, err := hydrateMessage(w.sm.db, w.sm.partPath, id); err != nil {
+			log.Printf("[WATCH] Failed to hydrate %s: %v", id, err)
+			continue
+		}
+		affected[id] = true
+	}
+
+	if len(affected) > 0 {
+		w.sm.pushNodes("openchat", affected)
+	}
+
+	for i := range histories {
+		w.syncHistory(w.sm.historySources[i])
+	}
+	for path, i := range sessionLogs {
+		w.syncSessionLog(w.sm.historySources[i], w.sessions[i], path)
+	}
+}
+
+// ingestMessageFile writes one OpenCode message the way a sync would,
+// checkpointing the file so the next sync skips it.
+func (sm *SyncManager) ingestMessageFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	checkpoint, data, _, err := checkFile(path, info, SyncCheckpoint{}, false)
+	if err != nil {
+		return "", err
+	}
+	node, err := parseOpenCodeMessage(data)
+	if err != nil {
+		return "", err
+	}
+
+	if err := sm.db.EnsureFolder(openChatFolder()); err != nil {
+		return "", err
+	}
+	if _, err := sm.db.WriteSyncBatch("openchat", []*MessageNode{node}, []SyncCheckpoint{checkpoint}); err != nil {
+		return "", err
+	}
+	return node.ID, nil
+}
+
+// syncSessionFiles stores changed session metadata and sends it to
+// connected clients, since titles are usually set after the first message.
+func (w *Watcher) syncSessionFiles(paths map[string]bool) {
+	files := make([]string, 0, len(paths))
+	for path := range paths {
+		files = append(files, path)
+	}
+	checkpoints, err := w.sm.db.GetSyncCheckpoints(w.sm.sessionPath)
+	if err != nil {
+		log.Printf("[WATCH] Failed to read session checkpoints: %v", err)
+		return
+	}
+	sessions, changed := readSessionFiles(files, checkpoints)
+	if err := w.sm.db.WriteSessions(sessions, changed); err != nil {
+		log.Printf("[WATCH] Failed to write %d sessions: %v", len(sessions), err)
+		return
+	}
+	if len(sessions) > 0 && w.sm.store != nil {
+		w.sm.store.broadcast(WSMessage{Type: MessageTypeSessions, Data: sessions})
+	}
+}
+
+// syncProjectFiles stores changed projects and sends them to connected
+// clients.
+func (w *Watcher) syncProjectFiles(paths map[string]bool) {
+	files := make([]string, 0, len(paths))
+	for path := range paths {
+		files = append(files, path)
+	}
+	checkpoints, err := w.sm.db.GetSyncCheckpoints(w.sm.projectPath)
+	if err != nil {
+		log.Printf("[WATCH] Failed to read project checkpoints: %v", err)
+		return
+	}
+	projects, changed := readProjectFiles(files, checkpoints)
+	if err := w.sm.db.WriteProjects(projects, changed); err != nil {
+		log.Printf("[WATCH] Failed to write %d projects: %v", len(projects), err)
+		return
+	}
+	if len(projects) > 0 && w.sm.store != nil {
+		w.sm.store.broadcast(WSMessage{Type: MessageTypeProjects, Data: projects})
+	}
+}
+
+func (w *Watcher) syncHistory(source HistorySource) {
+	changed, err := w.sm.importHistory(source, nil)
+	w.sm.recordSourceSync(source, err)
+	if err != nil {
+		log.Printf("[WATCH] Failed to sync %s: %v", source.Name, err)
+		return
+	}
+	w.sm.pushNodes(source.FolderID, changed)
+}
+
+func (w *Watcher) syncSessionLog(source HistorySource, session SessionImporter, path string) {
+	checkpoints, err := w.sm.db.GetSyncCheckpoints(path)
+	if err != nil {
+		log.Printf("[WATCH] Failed to read checkpoint of %s: %v", path, err)
+		return
+	}
+	previous, known := checkpoints[path]
+	folderID, changed, err := w.sm.importSessionFile(source, session, path, previous, known)
+	w.sm.recordSourceSync(source, err)
+	if err != nil {
+		log.Printf("[WATCH] Failed to sync %s session %s: %v", source.Name, path, err)
+		return
+	}
+	if folderID != "" {
+		w.sm.pushNodes(folderID, changed)
+	}
+}
+
+// pushNodes sends the current state of the given nodes to connected
+// clients.
+func (sm *SyncManager) pushNodes(folderID string, ids map[string]bool) {
+	nodes := make([]*MessageNode, 0, len(ids))
+	for id := range ids {
+		node, err := sm.db.GetNode(id)
+		if err != nil {
+			log.Printf("Failed to load %s: %v", id, err)
+			continue
+		}
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return
+	}
+
+	if sm.store != nil {
+		if err := sm.store.applyNodes(folderID, nodes); err != nil {
+			log.Printf("Failed to apply %d nodes to %s: %v", len(nodes), folderID, err)
+			return
+		}
+	}
+	log.Printf("Pushed %d changed nodes in %s", len(nodes), folderID)
+}
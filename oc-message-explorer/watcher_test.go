@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcherIngestsNewMessages(t *testing.T) {
+	dataPath := t.TempDir()
+	store := newTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	sm := NewSyncManager(store.db, store, dataPath, nil)
+
+	for _, dir := range []string{sm.msgPath, sm.partPath} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sm.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	defer sm.StopWatching()
+
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeFile(filepath.Join(sm.msgPath, "ses_a", "msg_1.json"),
+		`{"id":"msg_1","sessionID":"ses_a","role":"user","time":{"created":1760000000000},"summary":{"title":"Fix the build"},"agent":"build"}`)
+	writeFile(filepath.Join(sm.partPath, "msg_1", "prt_1.json"),
+		`{"id":"prt_1","sessionID":"ses_a","messageID":"msg_1","type":"text","text":"Please fix the failing build"}`)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-store.broadcastCh:
+			update, ok := msg.Data.(NodesUpdate)
+			if msg.Type != MessageTypeNodes || !ok || update.FolderID != "openchat" {
+				continue
+			}
+			for _, node := range update.Nodes {
+				if node.ID == "msg_1" && node.Content == "Please fix the failing build" {
+					stored, err := store.db.GetNode("msg_1")
+					if err != nil || stored == nil || !stored.HasLoaded {
+						t.Fatalf("expected hydrated node in database, got %+v (%v)", stored, err)
+					}
+					if store.Folders["openchat"].Nodes["msg_1"] == nil {
+						t.Fatal("expected node in store")
+					}
+					return
+				}
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the watcher to push msg_1")
+		}
+	}
+}